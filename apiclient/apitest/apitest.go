@@ -0,0 +1,36 @@
+// Package apitest provides a stand-in for an OpenAI style api, for the tests of
+// the packages which call one through apiclient.Client.
+package apitest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// APIKey is the api key which the stand-in expects as a bearer token.
+const APIKey = "test-key"
+
+// NewServer starts a stand-in for a POST endpoint of the api under /v1, eg: for
+// /chat/completions at /v1/chat/completions, so its base url is the url of the
+// server with /v1. The handler is called after the common request checks have
+// passed, and the server is closed when the test ends.
+func NewServer(t testing.TB, endpoint string, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %v, want POST", r.Method)
+		}
+		if r.URL.Path != "/v1"+endpoint {
+			t.Errorf("path = %v, want /v1%v", r.URL.Path, endpoint)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer "+APIKey {
+			t.Errorf("authorization = %q, want %q", got, "Bearer "+APIKey)
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
@@ -0,0 +1,140 @@
+// Package apiclient makes the requests to OpenAI style apis, eg: for
+// transcription, chat completions or speech, retrying the failed ones and
+// sharing a rate limiter between the workers which call the same api.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/spiritorai/spiritor/logging"
+	"github.com/spiritorai/spiritor/progress"
+)
+
+// Client makes the requests to an OpenAI style api. It is embedded by the
+// engines and providers of such an api so that they all retry and back off in
+// the same way.
+type Client struct {
+	BaseURL    string        // api base url, eg: https://api.openai.com/v1
+	APIKey     string        // api key sent as a bearer token, if set
+	Timeout    time.Duration // max time for a single request, 0 for none
+	Retry      RetryPolicy   // retry settings for failed requests
+	Limiter    *Limiter      // shared by all requests made by this client
+	HTTPClient *http.Client  // http client used for all requests
+}
+
+// PostJSON sends v as json to the endpoint, see Post.
+func (c *Client) PostJSON(ctx context.Context, endpoint string, v any) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	return c.Post(ctx, endpoint, "application/json", body)
+}
+
+// Post sends the body to the endpoint and returns the body of the response,
+// retrying rate limit, server and network errors by the retry policy. The body
+// is kept in memory so that it can be re-sent on each retry, and its upload is
+// reported to the progress func of the ctx.
+func (c *Client) Post(ctx context.Context, endpoint, contentType string, body []byte) ([]byte, error) {
+
+	for retry := 0; ; retry++ {
+
+		data, err := c.send(ctx, endpoint, contentType, body)
+		if err == nil {
+			return data, nil
+		}
+
+		if retry >= c.Retry.MaxRetries || !Retryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		log := logging.FromContext(ctx).With("retry", retry+1, "err", err)
+
+		// Rate limits pause every request made by this client through the shared
+		// limiter, which send waits on. Other errors only delay this request.
+		var errRateLimit ErrRateLimit
+		if errors.As(err, &errRateLimit) {
+			delay := errRateLimit.RetryAfter
+			if delay <= 0 {
+				delay = c.Retry.Backoff(retry + 1)
+			}
+			log.Warn("rate limited, pausing requests", "delay", delay)
+			c.Limiter.PauseUntil(time.Now().Add(delay))
+			continue
+		}
+
+		delay := c.Retry.Backoff(retry + 1)
+		log.Warn("request failed, retrying", "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// send makes a single request attempt, waiting for the limiter first. Failed
+// responses are returned as classified errors.
+func (c *Client) send(ctx context.Context, endpoint, contentType string, body []byte) ([]byte, error) {
+
+	if err := c.Limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	// The reader hides the length of the body from the request, so it is set here.
+	upload := progress.NewReader(ctx, progress.Upload, bytes.NewReader(body), int64(len(body)))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+endpoint, upload)
+	if err != nil {
+		return nil, fmt.Errorf("failed create new http request: %v", err)
+	}
+	req.ContentLength = int64(len(body))
+
+	req.Header.Add("Content-Type", contentType)
+	c.Authorize(req)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	c.Limiter.Observe(resp.Header, time.Now())
+
+	if resp.StatusCode != http.StatusOK {
+		var errMsg string
+		if body, err := io.ReadAll(resp.Body); err == nil {
+			errMsg = string(body)
+		}
+		return nil, ClassifyStatus(resp, errMsg, time.Now())
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return data, nil
+}
+
+// Authorize adds the api key to a request which is not sent through Post, eg: a
+// GET request.
+func (c *Client) Authorize(req *http.Request) {
+	if c.APIKey != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", c.APIKey))
+	}
+}
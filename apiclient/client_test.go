@@ -0,0 +1,146 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiritorai/spiritor/apiclient/apitest"
+	"github.com/spiritorai/spiritor/progress"
+)
+
+const testEP = "/test"
+
+func newTestClient(url string, maxRetries int) *Client {
+	return &Client{
+		BaseURL:    url + "/v1",
+		APIKey:     apitest.APIKey,
+		Retry:      RetryPolicy{MaxRetries: maxRetries, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		Limiter:    NewLimiter(0),
+		HTTPClient: &http.Client{},
+	}
+}
+
+func TestClientPostJSON(t *testing.T) {
+
+	server := apitest.NewServer(t, testEP, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("content type = %q, want application/json", got)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"input":"hello"}` {
+			t.Errorf("body = %s, want the request as json", body)
+		}
+		io.WriteString(w, "reply")
+	})
+
+	var uploaded int64
+	ctx := progress.WithFunc(context.Background(), func(kind progress.Kind, done, total int64) {
+		uploaded = done
+	})
+
+	data, err := newTestClient(server.URL, 0).PostJSON(ctx, testEP, map[string]string{"input": "hello"})
+	if err != nil {
+		t.Fatalf("PostJSON() error = %v", err)
+	}
+	if string(data) != "reply" {
+		t.Errorf("reply = %q, want %q", data, "reply")
+	}
+	if uploaded != int64(len(`{"input":"hello"}`)) {
+		t.Errorf("uploaded = %v, want the size of the body", uploaded)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+
+	var attempts int
+	server := apitest.NewServer(t, testEP, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch attempts {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"error": {"message": "slow down"}}`)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			io.WriteString(w, "ok")
+		}
+	})
+
+	data, err := newTestClient(server.URL, 3).Post(context.Background(), testEP, "text/plain", []byte("body"))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %v, want 3", attempts)
+	}
+	if string(data) != "ok" {
+		t.Errorf("reply = %q, want %q", data, "ok")
+	}
+}
+
+func TestClientNoRetry(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		check  func(error) bool
+	}{
+		{"auth", http.StatusUnauthorized, "invalid key", func(err error) bool { return errors.As(err, &ErrAuth{}) }},
+		{"quota", http.StatusTooManyRequests, "insufficient_quota", func(err error) bool { return errors.As(err, &ErrAuth{}) }},
+		{"client", http.StatusBadRequest, "bad file", func(err error) bool { return errors.As(err, &ErrClient{}) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var attempts int
+			server := apitest.NewServer(t, testEP, func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+
+			_, err := newTestClient(server.URL, 3).Post(context.Background(), testEP, "text/plain", []byte("body"))
+			if !tt.check(err) {
+				t.Errorf("error = %v (%T), want %v error", err, err, tt.name)
+			}
+			if !strings.Contains(err.Error(), tt.body) {
+				t.Errorf("error = %v, want the message of the response", err)
+			}
+			if attempts != 1 {
+				t.Errorf("attempts = %v, want 1", attempts)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"seconds", http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{"date", http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute},
+		{"ratelimit", http.Header{"X-Ratelimit-Reset-Requests": {"1s"}, "X-Ratelimit-Reset-Tokens": {"6m0s"}}, 6 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.header, now); got != tt.want {
+				t.Errorf("retryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
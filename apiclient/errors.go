@@ -0,0 +1,81 @@
+package apiclient
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The client classifies failed api responses into these errors so that callers
+// can decide whether to retry, abort the batch or skip the file.
+
+// Auth
+// The api key is missing, invalid or lacks permission. Retrying will not help
+// and every other request with the same key will fail too.
+
+type ErrAuth struct {
+	StatusCode int
+	Message    string
+}
+
+func (e ErrAuth) Error() string {
+	return fmt.Sprintf("auth error: status %v: %v", e.StatusCode, e.Message)
+}
+
+// Rate Limit
+// Too many requests or tokens in the current window. The request may be retried
+// after the RetryAfter delay, which is 0 if the api did not send one.
+
+type ErrRateLimit struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e ErrRateLimit) Error() string {
+	return fmt.Sprintf("rate limit error: status %v: %v", e.StatusCode, e.Message)
+}
+
+// Server
+// The api failed to handle a valid request. The request may be retried.
+
+type ErrServer struct {
+	StatusCode int
+	Message    string
+}
+
+func (e ErrServer) Error() string {
+	return fmt.Sprintf("server error: status %v: %v", e.StatusCode, e.Message)
+}
+
+// Client
+// The api rejected the request itself, eg: an unsupported file. Retrying the
+// same request will not help.
+
+type ErrClient struct {
+	StatusCode int
+	Message    string
+}
+
+func (e ErrClient) Error() string {
+	return fmt.Sprintf("client error: status %v: %v", e.StatusCode, e.Message)
+}
+
+// ClassifyStatus returns the error type for a non-200 response.
+func ClassifyStatus(resp *http.Response, message string, now time.Time) error {
+	switch code := resp.StatusCode; {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrAuth{StatusCode: code, Message: message}
+	case code == http.StatusTooManyRequests && strings.Contains(message, "insufficient_quota"):
+		// Exhausted billing quota is also sent as a 429 but it will not recover by
+		// waiting, so it is treated like an auth error.
+		return ErrAuth{StatusCode: code, Message: message}
+	case code == http.StatusTooManyRequests:
+		return ErrRateLimit{StatusCode: code, Message: message, RetryAfter: retryAfter(resp.Header, now)}
+	case code >= 500:
+		return ErrServer{StatusCode: code, Message: message}
+	default:
+		return ErrClient{StatusCode: code, Message: message}
+	}
+}
@@ -0,0 +1,153 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Only rate limit, server
+// and network errors are retried, auth and client errors fail immediately.
+type RetryPolicy struct {
+	MaxRetries int           // max retries after the first attempt, 0 for none
+	BaseDelay  time.Duration // delay before the first retry, doubled for each retry
+	MaxDelay   time.Duration // upper bound for any single delay
+}
+
+const (
+	defaultBaseDelay time.Duration = 2 * time.Second
+	defaultMaxDelay  time.Duration = 2 * time.Minute
+)
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultMaxDelay
+	}
+	return p
+}
+
+// Backoff returns the jittered delay before the retry with the given number,
+// starting at 1. The delay is randomized between half and all of the exponential
+// delay so that concurrent workers do not retry in lockstep. Zero delays of the
+// policy are replaced by the defaults.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	p = p.withDefaults()
+	delay := p.BaseDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Retryable reports whether the error from a request attempt may succeed if it is
+// sent again.
+func Retryable(err error) bool {
+	var errAuth ErrAuth
+	var errClient ErrClient
+	switch {
+	case errors.As(err, &errAuth), errors.As(err, &errClient):
+		return false
+	case errors.Is(err, context.Canceled):
+		return false
+	default:
+		return true
+	}
+}
+
+// Limiter is shared by all of the workers which call the same api so that a rate
+// limit hit by one worker pauses the others too, rather than each of them
+// hammering the api until they are individually rejected. It can also space out
+// requests to stay below a requests per minute limit. The zero value is ready to
+// use and does not limit anything until a pause is requested.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // min time between the start of two requests
+	next     time.Time     // earliest time the next request may start
+}
+
+// NewLimiter creates a limiter which allows at most rpm requests per minute, or
+// any number if rpm is 0.
+func NewLimiter(rpm int) *Limiter {
+	l := &Limiter{}
+	if rpm > 0 {
+		l.interval = time.Minute / time.Duration(rpm)
+	}
+	return l
+}
+
+// Wait blocks until the next request may start or the ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+
+	l.mu.Lock()
+	now := time.Now()
+	start := now
+	if l.next.After(start) {
+		start = l.next
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	if delay := start.Sub(now); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return nil
+}
+
+// PauseUntil holds back all requests until t. Earlier times than an existing
+// pause are ignored.
+func (l *Limiter) PauseUntil(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.next) {
+		l.next = t
+	}
+}
+
+// Observe pauses the limiter when the rate limit headers of a response show that
+// the request quota is used up, so that the next request is not rejected.
+func (l *Limiter) Observe(header http.Header, now time.Time) {
+	if header.Get("x-ratelimit-remaining-requests") != "0" {
+		return
+	}
+	if reset, err := time.ParseDuration(header.Get("x-ratelimit-reset-requests")); err == nil {
+		l.PauseUntil(now.Add(reset))
+	}
+}
+
+// retryAfter reads the delay requested by the api from the Retry-After header,
+// which may be in seconds or an http date, or from the openai specific rate limit
+// reset headers. It returns 0 if none are present.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+
+	if value := header.Get("Retry-After"); value != "" {
+		if secs, err := strconv.ParseFloat(value, 64); err == nil {
+			return time.Duration(secs * float64(time.Second))
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0)
+		}
+	}
+
+	var delay time.Duration
+	for _, name := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+		if reset, err := time.ParseDuration(header.Get(name)); err == nil {
+			delay = max(delay, reset)
+		}
+	}
+
+	return delay
+}
@@ -0,0 +1,131 @@
+package avmedia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spiritorai/spiritor/ffmpeg"
+	"github.com/spiritorai/spiritor/logging"
+)
+
+// concatCodecs are the audio codecs and bitrates of the formats which Concat
+// can write, by output extension.
+var concatCodecs = map[string][2]string{
+	".mp3":  {"libmp3lame", "128k"},
+	".ogg":  {"libopus", "64k"},
+	".opus": {"libopus", "64k"},
+}
+
+type ConcatConfig struct {
+	OutputPath string        // path of the output file, one of mp3, ogg or opus
+	Chapters   []Chapter     // chapter markers of the output, none if empty
+	Timeout    time.Duration // max time for the ffmpeg render, 0 for none
+}
+
+func (config ConcatConfig) Validate() error {
+	errs := []error{}
+
+	if config.OutputPath == "" {
+		errs = append(errs, fmt.Errorf("invalid OutputPath: cannot be empty"))
+	} else if info, err := os.Stat(filepath.Dir(config.OutputPath)); err != nil {
+		errs = append(errs, fmt.Errorf("invalid OutputPath [%v]: %v", config.OutputPath, err))
+	} else if !info.IsDir() {
+		errs = append(errs, fmt.Errorf("invalid OutputPath [%v]: parent is not a directory", config.OutputPath))
+	}
+
+	if _, ok := concatCodecs[strings.ToLower(filepath.Ext(config.OutputPath))]; config.OutputPath != "" && !ok {
+		errs = append(errs, fmt.Errorf("invalid OutputPath [%v]: must be an mp3, ogg or opus file", config.OutputPath))
+	}
+
+	var prevEnd time.Duration
+	for i, chapter := range config.Chapters {
+		if chapter.Start < prevEnd || chapter.End <= chapter.Start {
+			errs = append(errs, fmt.Errorf("invalid Chapters [%v]: must be ordered and not overlap", i))
+		}
+		prevEnd = chapter.End
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// Concat joins the audio of the source media back to back into a new file and
+// returns a media wrapper for it. The sources must share the same codec and
+// parameters, eg: the chunks of a single speech synthesis. The output file is
+// written to a temp file next to the output path and moved into place once
+// complete, it is the callers responsibility to remove it.
+func Concat(ctx context.Context, sources []Media, config ConcatConfig) (Media, error) {
+
+	var targetMedia Media
+
+	if len(sources) == 0 {
+		return targetMedia, ErrValidation{
+			Err: fmt.Errorf("no source media"),
+		}
+	}
+
+	paths := make([]string, len(sources))
+	for i, source := range sources {
+		if !source.initialized {
+			return targetMedia, ErrValidation{
+				Err: fmt.Errorf("media uninitialized: use media constructor"),
+			}
+		}
+		paths[i] = source.GetPath()
+	}
+
+	if err := config.Validate(); err != nil {
+		return targetMedia, ErrValidation{
+			Err: fmt.Errorf("bad config: %v", err),
+		}
+	}
+
+	chapters := make([]ffmpeg.Chapter, len(config.Chapters))
+	for i, chapter := range config.Chapters {
+		chapters[i] = ffmpeg.Chapter{ID: int64(i), Start: chapter.Start, End: chapter.End, Title: chapter.Title}
+	}
+
+	// The temp file keeps the extension of the output so that ffmpeg picks the same
+	// format, see Cut.
+	ext := filepath.Ext(config.OutputPath)
+	codec := concatCodecs[strings.ToLower(ext)]
+	tempFilePath := config.OutputPath[:len(config.OutputPath)-len(ext)] + ".partial" + ext
+	os.Remove(tempFilePath)
+	defer os.Remove(tempFilePath)
+
+	logging.FromContext(ctx).Debug("concatenating media", "sources", len(sources), "chapters", len(chapters), "output", config.OutputPath)
+
+	concatCtx := ctx
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		concatCtx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	if err := ffmpeg.ConcatAudio(concatCtx, paths, tempFilePath, codec[0], codec[1], chapters); err != nil {
+		return targetMedia, ErrFileOp{
+			Err: fmt.Errorf("ffmpeg failed: %v", err),
+		}
+	}
+
+	if err := os.Rename(tempFilePath, config.OutputPath); err != nil {
+		return targetMedia, ErrFileOp{
+			Err: fmt.Errorf("file move/rename failed: %v", err),
+		}
+	}
+
+	targetMedia, err := NewMedia(ctx, config.OutputPath)
+	if err != nil {
+		return targetMedia, fmt.Errorf("new concat media failed: %w", err)
+	}
+
+	return targetMedia, nil
+}
@@ -0,0 +1,145 @@
+package avmedia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spiritorai/spiritor/ffmpeg"
+	"github.com/spiritorai/spiritor/logging"
+)
+
+// TimeRange is a section of a media file relative to its start.
+type TimeRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+type CutConfig struct {
+	OutputPath string        // path of the output file, its extension selects the format
+	Ranges     []TimeRange   // sections of the source to keep, in order
+	Crossfade  time.Duration // length of the fade at each cut point, 0 for hard cuts
+	AudioOnly  bool          // drop the video stream of video files
+	Timeout    time.Duration // max time for the ffmpeg render, 0 for none
+}
+
+func (config CutConfig) Validate() error {
+	errs := []error{}
+
+	if config.OutputPath == "" {
+		errs = append(errs, fmt.Errorf("invalid OutputPath: cannot be empty"))
+	} else if info, err := os.Stat(filepath.Dir(config.OutputPath)); err != nil {
+		errs = append(errs, fmt.Errorf("invalid OutputPath [%v]: %v", config.OutputPath, err))
+	} else if !info.IsDir() {
+		errs = append(errs, fmt.Errorf("invalid OutputPath [%v]: parent is not a directory", config.OutputPath))
+	}
+
+	if len(config.Ranges) == 0 {
+		errs = append(errs, fmt.Errorf("invalid Ranges: nothing to keep"))
+	}
+
+	if config.Crossfade < 0 {
+		errs = append(errs, fmt.Errorf("invalid Crossfade [%v]: must not be negative", config.Crossfade))
+	}
+
+	// Each crossfade overlaps the end of one range with the start of the next so
+	// every range must be longer than the fade.
+	var prevEnd time.Duration
+	for i, r := range config.Ranges {
+		if r.Start < prevEnd || r.End <= r.Start {
+			errs = append(errs, fmt.Errorf("invalid Ranges [%v]: must be ordered and not overlap", i))
+		} else if len(config.Ranges) > 1 && r.End-r.Start <= config.Crossfade {
+			errs = append(errs, fmt.Errorf("invalid Ranges [%v]: must be longer than the crossfade", i))
+		}
+		prevEnd = r.End
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// Cut renders the ranges of the source media back to back into a new file with a
+// crossfade at every cut point, and returns a media wrapper for it. Only the
+// selected audio stream and the video stream, if any, are kept and both are
+// re-encoded. The output file is written to a temp file next to the output path
+// and moved into place once complete, it is the callers responsibility to remove it.
+func (sourceMedia Media) Cut(ctx context.Context, config CutConfig) (Media, error) {
+
+	var targetMedia Media
+
+	if !sourceMedia.initialized {
+		return targetMedia, ErrValidation{
+			Err: fmt.Errorf("media uninitialized: use media constructor"),
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return targetMedia, ErrValidation{
+			Err: fmt.Errorf("bad config: %v", err),
+		}
+	}
+
+	if filepath.Clean(config.OutputPath) == sourceMedia.GetPath() {
+		return targetMedia, ErrValidation{
+			Err: fmt.Errorf("output path cannot be the source file"),
+		}
+	}
+
+	ranges := make([]ffmpeg.Range, len(config.Ranges))
+	for i, r := range config.Ranges {
+		ranges[i] = ffmpeg.Range{Start: r.Start.Seconds(), End: r.End.Seconds()}
+	}
+
+	videoStream := -1
+	if !config.AudioOnly {
+		for _, stream := range sourceMedia.streams {
+			if stream.Type == StreamTypeVideo && !stream.AttachedPic {
+				videoStream = stream.Index
+				break
+			}
+		}
+	}
+
+	// The temp file keeps the extension of the output so that ffmpeg picks the same
+	// format, and stays in the same directory so that the final rename is atomic.
+	// Any leftover from an interrupted run is removed since ffmpeg will not
+	// overwrite it.
+	ext := filepath.Ext(config.OutputPath)
+	tempFilePath := config.OutputPath[:len(config.OutputPath)-len(ext)] + ".partial" + ext
+	os.Remove(tempFilePath)
+	defer os.Remove(tempFilePath)
+
+	logging.FromContext(ctx).Debug("cutting media", "file", sourceMedia.GetName(), "ranges", len(ranges), "audio_stream", sourceMedia.audioStream, "video_stream", videoStream)
+
+	cutCtx := ctx
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		cutCtx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	if err := ffmpeg.ConcatRanges(cutCtx, sourceMedia.GetPath(), tempFilePath, ranges, sourceMedia.audioStream, videoStream, config.Crossfade.Seconds()); err != nil {
+		return targetMedia, ErrFileOp{
+			Err: fmt.Errorf("ffmpeg failed: %v", err),
+		}
+	}
+
+	if err := os.Rename(tempFilePath, config.OutputPath); err != nil {
+		return targetMedia, ErrFileOp{
+			Err: fmt.Errorf("file move/rename failed: %v", err),
+		}
+	}
+
+	targetMedia, err := NewMedia(ctx, config.OutputPath)
+	if err != nil {
+		return targetMedia, fmt.Errorf("new cut media failed: %w", err)
+	}
+
+	return targetMedia, nil
+}
@@ -52,3 +52,19 @@ type ErrSizeCapExceeded struct {
 func (e ErrSizeCapExceeded) Error() string {
 	return fmt.Sprintf("file size [%v] exceeded the maximum size cap: %v", e.SizeCap, e.FileSize)
 }
+
+// Unsupported
+// The file is not a media file that ffprobe can read, or none of its audio
+// streams can be decoded by the installed ffmpeg. Callers should skip the file.
+
+type ErrUnsupported struct {
+	Err error
+}
+
+func (e ErrUnsupported) Error() string {
+	return fmt.Sprintf("unsupported media: %v", e.Err)
+}
+
+func (e ErrUnsupported) Unwrap() error {
+	return e.Err
+}
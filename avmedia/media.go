@@ -1,28 +1,31 @@
 package avmedia
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/spiritorai/spiritor/ffmpeg"
+	"github.com/spiritorai/spiritor/logging"
 )
 
+// probeTimeout is the max time allowed to probe a single file.
+const probeTimeout = 30 * time.Second
+
 // NewMedia should always be used to initialize a new media struct from outside
 // of the avtools package
-func NewMedia(debug bool, filePath string) (Media, error) {
+func NewMedia(ctx context.Context, filePath string) (Media, error) {
 
-	if debug {
-		fmt.Printf("new media: %v\n", filePath)
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
 
-		dump, err := ffmpeg.ProbeDump(filePath)
-		if err != nil {
-			fmt.Printf("ffprobe dump failed: %v\n", err)
-		}
-		fmt.Printf("ffprobe dump: %v\n", dump)
-	}
+	log := logging.FromContext(ctx).With("file", filepath.Base(filePath))
+	log.Debug("new media", "path", filePath)
 
 	var media Media
 
@@ -33,36 +36,101 @@ func NewMedia(debug bool, filePath string) (Media, error) {
 		}
 	}
 
+	if fileInfo.IsDir() {
+		return media, ErrUnsupported{
+			Err: fmt.Errorf("is a directory"),
+		}
+	}
+
 	media.path = filePath
 	media.size = fileInfo.Size()
 
-	bitrate, err := ffmpeg.ProbeBitrate(filePath)
+	// Inputs are classified by their contents rather than their extension, so any
+	// file that ffprobe can read and which has an audio stream that the installed
+	// ffmpeg can decode is supported. All of the metadata comes from a single
+	// probe since it is run for every source file and again for every target.
+	probe, err := ffmpeg.Probe(ctx, filePath)
 	if err != nil {
-		return media, ErrFileOp{
-			Err: fmt.Errorf("failed to probe bitrate: %v", err),
+		if errors.Is(err, exec.ErrNotFound) || ctx.Err() != nil {
+			return media, ErrFileOp{
+				Err: fmt.Errorf("failed to probe: %v", err),
+			}
+		}
+		return media, ErrUnsupported{
+			Err: fmt.Errorf("not a media file: %v", err),
 		}
 	}
 
-	media.bitrate = bitrate
-
-	duration, err := ffmpeg.ProbeDuration(filePath)
+	codecs, err := audioDecoders(ctx)
 	if err != nil {
 		return media, ErrFileOp{
-			Err: fmt.Errorf("failed to probe duration: %v", err),
+			Err: fmt.Errorf("failed to list decoders: %v", err),
 		}
 	}
 
-	media.duration = duration
+	media.format = probe.Format.Name
+	media.formatDuration = probe.Format.Duration
+	media.formatBitrate = probe.Format.Bitrate
+	media.tags = probe.Format.Tags
 
-	media.initialized = true
+	for _, stream := range probe.Streams {
+		_, decoded := codecs[stream.Codec]
+		media.streams = append(media.streams, newStream(stream, decoded && stream.Type == StreamTypeAudio))
+	}
+
+	for _, chapter := range probe.Chapters {
+		media.chapters = append(media.chapters, Chapter{
+			Start: chapter.Start,
+			End:   chapter.End,
+			Title: chapter.Title,
+		})
+	}
+
+	// Video containers may hold several audio tracks, eg: one per language. The
+	// default track is used unless the caller selects another.
+	index := defaultAudioStream(media.streams)
+	if index < 0 {
+		if len(media.GetAudioStreams()) == 0 {
+			return media, ErrUnsupported{
+				Err: fmt.Errorf("no audio stream found"),
+			}
+		}
+		return media, ErrUnsupported{
+			Err: fmt.Errorf("no decoder for audio streams: %v", media.GetAudioStreams()),
+		}
+	}
 
-	if debug {
-		fmt.Printf("media file created: %+v\n", media)
+	for _, stream := range media.streams {
+		if stream.Index == index {
+			media.useAudioStream(stream)
+		}
 	}
 
+	media.initialized = true
+
+	log.Debug("media file created", "size", media.size, "duration", media.duration, "bitrate", media.bitrate, "format", media.format, "streams", media.streams, "audio_stream", media.audioStream, "chapters", len(media.chapters))
+
 	return media, nil
 }
 
+// useAudioStream selects the audio stream along with its bitrate and duration.
+// Containers which do not report these per stream fall back to the format
+// values, though the format bitrate is only used if there is no video since it
+// covers all of the streams.
+func (f *Media) useAudioStream(stream Stream) {
+	f.audioStream = stream.Index
+
+	f.duration = stream.Duration
+	if f.duration <= 0 {
+		f.duration = f.formatDuration
+	}
+
+	f.bitrate = stream.Bitrate
+	if f.bitrate <= 0 && !f.HasVideo() {
+		f.bitrate = f.formatBitrate
+	}
+}
+
 // Media is a wrapper for an AV file which enables fast lookups of essential
 // properties as well as common transformations.
 // IMPORTANT: These structs are wrappers for file paths and metadata only, and
@@ -71,11 +139,19 @@ func NewMedia(debug bool, filePath string) (Media, error) {
 // to be garbage collected without having to perform any cleanup tasks, for example
 // closing a reader.
 type Media struct {
-	path        string        // absolute file path, eg: /my/docs/zoom.mp3
-	size        int64         // file size in bytes
-	duration    time.Duration // playback length over time
-	bitrate     int           // encoded bitrate
-	initialized bool          // internal tracking for properly initialized media
+	path        string            // absolute file path, eg: /my/docs/zoom.mp3
+	size        int64             // file size in bytes
+	duration    time.Duration     // playback length over time
+	bitrate     int               // encoded bitrate of the selected audio stream
+	format      string            // container format names, eg: mov,mp4,m4a,3gp,3g2,mj2
+	tags        map[string]string // container tags, eg: title, artist
+	streams     []Stream          // inventory of all streams in the file
+	chapters    []Chapter         // chapter markers, eg: from an audiobook
+	audioStream int               // index of the selected audio stream
+	initialized bool              // internal tracking for properly initialized media
+
+	formatDuration time.Duration // container duration, used if the stream has none
+	formatBitrate  int           // container bitrate, used if the stream has none
 }
 
 // absolute file path, eg: /my/docs/zoom.mp3
@@ -108,7 +184,78 @@ func (f Media) GetDuration() time.Duration {
 	return f.duration
 }
 
-// encoded bitrate
+// encoded bitrate of the selected audio stream
 func (f Media) GetBitrate() int {
 	return f.bitrate
 }
+
+// container format names as reported by ffprobe, eg: mov,mp4,m4a,3gp,3g2,mj2
+func (f Media) GetFormat() string {
+	return f.format
+}
+
+// container tags, eg: title, artist
+func (f Media) GetTags() map[string]string {
+	tags := make(map[string]string, len(f.tags))
+	for k, v := range f.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// chapter markers, eg: from an audiobook
+func (f Media) GetChapters() []Chapter {
+	return append([]Chapter(nil), f.chapters...)
+}
+
+// sample rate of the selected audio stream in Hz
+func (f Media) GetSampleRate() int {
+	return f.audio().SampleRate
+}
+
+// channel count of the selected audio stream
+func (f Media) GetChannels() int {
+	return f.audio().Channels
+}
+
+// inventory of all streams in the file
+func (f Media) GetStreams() []Stream {
+	return append([]Stream(nil), f.streams...)
+}
+
+// inventory of the audio streams in the file
+func (f Media) GetAudioStreams() []Stream {
+	var streams []Stream
+	for _, stream := range f.streams {
+		if stream.Type == StreamTypeAudio {
+			streams = append(streams, stream)
+		}
+	}
+	return streams
+}
+
+// index of the selected audio stream, see SelectAudioStream
+func (f Media) GetAudioStream() int {
+	return f.audioStream
+}
+
+// the selected audio stream
+func (f Media) audio() Stream {
+	for _, stream := range f.streams {
+		if stream.Index == f.audioStream {
+			return stream
+		}
+	}
+	return Stream{}
+}
+
+// true if the file has a video stream, eg: an mp4 movie rather than an m4a. The
+// cover art of audio files is not counted.
+func (f Media) HasVideo() bool {
+	for _, stream := range f.streams {
+		if stream.Type == StreamTypeVideo && !stream.AttachedPic {
+			return true
+		}
+	}
+	return false
+}
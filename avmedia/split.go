@@ -0,0 +1,198 @@
+package avmedia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spiritorai/spiritor/ffmpeg"
+	"github.com/spiritorai/spiritor/logging"
+)
+
+const (
+	silenceNoise       = "-35dB" // volume threshold for silence detection
+	silenceMinDuration = "0.5"   // min seconds of silence to be considered a pause
+
+	// Chunk sizes are projected from the average bytes per second of the source so
+	// this leaves some headroom for variable bitrate encodings.
+	splitSizeMargin float64 = 0.9
+)
+
+// Chunk is a section of a media file which has been split out into its own file.
+// The offset is the start of the chunk relative to the start of the source media.
+type Chunk struct {
+	Media  Media
+	Offset time.Duration
+}
+
+type SplitOGGConfig struct {
+	OutputBasePath string        // base path for the chunk files
+	SizeCap        int64         // max allowed size of each chunk file
+	Overlap        time.Duration // length of audio shared by consecutive chunks
+	Timeout        time.Duration // max time for all of the ffmpeg operations, 0 for none
+}
+
+func (config SplitOGGConfig) Validate() error {
+	errs := []error{}
+
+	basePathInfo, err := os.Stat(config.OutputBasePath)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid OutputBasePath [%v]: %v", config.OutputBasePath, err))
+	} else if !basePathInfo.IsDir() {
+		errs = append(errs, fmt.Errorf("invalid OutputBasePath [%v]: not a directory", config.OutputBasePath))
+	}
+
+	if config.SizeCap <= 0 {
+		errs = append(errs, fmt.Errorf("invalid SizeCap [%v]: must be greater than 0", config.SizeCap))
+	}
+
+	if config.Overlap < 0 {
+		errs = append(errs, fmt.Errorf("invalid Overlap [%v]: must not be negative", config.Overlap))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// SplitOGG splits an ogg media file into overlapping chunks which are each below
+// the size cap. Cut points are placed in the middle of a silence where possible so
+// that words are not broken up, otherwise a hard cut is made and the overlap is
+// relied upon to capture any broken words. The chunk files are created in the
+// SplitOGGConfig.OutputBasePath directory and it is the callers responsibility to
+// remove them.
+func (sourceMedia Media) SplitOGG(ctx context.Context, config SplitOGGConfig) ([]Chunk, error) {
+
+	if !sourceMedia.initialized {
+		return nil, ErrValidation{
+			Err: fmt.Errorf("media uninitialized: use media constructor"),
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, ErrValidation{
+			Err: fmt.Errorf("bad config: %v", err),
+		}
+	}
+
+	if sourceMedia.GetExt() != extOGG {
+		return nil, ErrValidation{
+			Err: fmt.Errorf("ext not allowed: %v", sourceMedia.GetExt()),
+		}
+	}
+
+	duration := sourceMedia.GetDuration().Seconds()
+	if duration <= 0 || sourceMedia.GetSize() <= 0 {
+		return nil, ErrValidation{
+			Err: fmt.Errorf("cannot split media without a size and duration"),
+		}
+	}
+
+	bytesPerSecond := float64(sourceMedia.GetSize()) / duration
+	maxChunk := float64(config.SizeCap) / bytesPerSecond * splitSizeMargin
+	overlap := config.Overlap.Seconds()
+
+	if maxChunk <= overlap*2 {
+		return nil, ErrValidation{
+			Err: fmt.Errorf("size cap too small: max chunk of %.1fs cannot fit the overlap of %.1fs", maxChunk, overlap),
+		}
+	}
+
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	silences, err := ffmpeg.DetectSilence(ctx, sourceMedia.GetPath(), silenceNoise, silenceMinDuration)
+	if err != nil {
+		return nil, ErrFileOp{
+			Err: fmt.Errorf("ffmpeg failed: %v", err),
+		}
+	}
+
+	spans := planChunks(duration, maxChunk, overlap, silences)
+
+	logging.FromContext(ctx).Debug("split plan",
+		"file", sourceMedia.GetName(),
+		"max_chunk", time.Duration(maxChunk*float64(time.Second)),
+		"silences", len(silences),
+		"chunks", len(spans),
+	)
+
+	var chunks []Chunk
+	for i, span := range spans {
+
+		chunkPath := filepath.Join(config.OutputBasePath, fmt.Sprintf("%v.part%03d.%v", sourceMedia.GetName(), i+1, extOGG))
+
+		if err := ffmpeg.ExtractSegment(ctx, sourceMedia.GetPath(), chunkPath, span.start, span.end-span.start); err != nil {
+			return chunks, ErrFileOp{
+				Err: fmt.Errorf("ffmpeg failed: %v", err),
+			}
+		}
+
+		chunkMedia, err := NewMedia(ctx, chunkPath)
+		if err != nil {
+			return chunks, fmt.Errorf("new chunk media failed: %w", err)
+		}
+
+		chunks = append(chunks, Chunk{
+			Media:  chunkMedia,
+			Offset: time.Duration(span.start * float64(time.Second)),
+		})
+
+		if chunkMedia.GetSize() > config.SizeCap {
+			return chunks, ErrSizeCapExceeded{
+				SizeCap:  config.SizeCap,
+				FileSize: chunkMedia.GetSize(),
+			}
+		}
+	}
+
+	return chunks, nil
+}
+
+// span is a section of media in seconds.
+type span struct {
+	start float64
+	end   float64
+}
+
+// planChunks chooses the chunk boundaries for a file of the given duration. Each
+// chunk runs from the previous cut point until the next cut point plus the overlap,
+// and never exceeds maxChunk seconds. The cut point is the middle of the latest
+// silence in the second half of the chunk, or a hard cut if there is none.
+func planChunks(duration, maxChunk, overlap float64, silences []ffmpeg.Silence) []span {
+
+	var spans []span
+	start := 0.0
+
+	for start+maxChunk < duration {
+
+		target := start + maxChunk - overlap
+		earliest := start + maxChunk/2
+		cut := target
+
+		best := math.Inf(-1)
+		for _, silence := range silences {
+			mid := (silence.Start + silence.End) / 2
+			if mid >= earliest && mid <= target && mid > best {
+				best = mid
+			}
+		}
+		if !math.IsInf(best, -1) {
+			cut = best
+		}
+
+		spans = append(spans, span{start: start, end: math.Min(cut+overlap, duration)})
+		start = cut
+	}
+
+	return append(spans, span{start: start, end: duration})
+}
@@ -0,0 +1,62 @@
+package avmedia
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spiritorai/spiritor/ffmpeg"
+)
+
+func TestPlanChunks(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		duration float64
+		silences []ffmpeg.Silence
+		want     []span
+	}{
+		{
+			name:     "fits in one chunk",
+			duration: 30,
+			want:     []span{{0, 30}},
+		},
+		{
+			name:     "hard cuts",
+			duration: 100,
+			want:     []span{{0, 40}, {35, 75}, {70, 100}},
+		},
+		{
+			name:     "cut at the latest silence",
+			duration: 100,
+			silences: []ffmpeg.Silence{{Start: 22, End: 24}, {Start: 28, End: 30}},
+			want:     []span{{0, 34}, {29, 69}, {64, 100}},
+		},
+		{
+			// Silences in the first half of a chunk or past the target would make
+			// the chunk too short or too long.
+			name:     "silences out of range",
+			duration: 100,
+			silences: []ffmpeg.Silence{{Start: 9, End: 11}, {Start: 36, End: 38}},
+			want:     []span{{0, 40}, {35, 75}, {70, 100}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planChunks(tt.duration, 40, 5, tt.silences)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planChunks() = %v, want %v", got, tt.want)
+			}
+
+			// Consecutive chunks overlap and every chunk fits the max.
+			for i, s := range got {
+				if s.end-s.start > 40 {
+					t.Errorf("chunk %v is %vs, over the max of 40s", i, s.end-s.start)
+				}
+				if i > 0 && s.start >= got[i-1].end {
+					t.Errorf("chunk %v starts at %v, after the end of the previous chunk at %v", i, s.start, got[i-1].end)
+				}
+			}
+		})
+	}
+}
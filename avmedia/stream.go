@@ -0,0 +1,232 @@
+package avmedia
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiritorai/spiritor/ffmpeg"
+)
+
+const (
+	StreamTypeAudio    = "audio"
+	StreamTypeVideo    = "video"
+	StreamTypeSubtitle = "subtitle"
+)
+
+// Stream describes a single stream of a media file, eg: one of the audio tracks
+// of a movie.
+type Stream struct {
+	Index         int           // absolute stream index within the file
+	Type          string        // stream type, eg: audio
+	Codec         string        // codec name, eg: aac
+	SampleRate    int           // audio sample rate in Hz
+	Channels      int           // audio channel count
+	ChannelLayout string        // audio channel layout, eg: stereo
+	Width         int           // video width in pixels
+	Height        int           // video height in pixels
+	Bitrate       int           // encoded bitrate, 0 if unknown
+	Duration      time.Duration // 0 if unknown
+	Language      string        // language tag, usually ISO-639-2, eg: eng
+	Title         string        // title tag, eg: Director's Commentary
+	Default       bool          // stream is flagged as the default of its type
+	AttachedPic   bool          // video stream is cover art rather than a video
+	Decoded       bool          // the installed ffmpeg can decode the codec, audio only
+}
+
+func newStream(s ffmpeg.Stream, decoded bool) Stream {
+	return Stream{
+		Index:         s.Index,
+		Type:          s.Type,
+		Codec:         s.Codec,
+		SampleRate:    s.SampleRate,
+		Channels:      s.Channels,
+		ChannelLayout: s.ChannelLayout,
+		Width:         s.Width,
+		Height:        s.Height,
+		Bitrate:       s.Bitrate,
+		Duration:      s.Duration,
+		Language:      s.Language,
+		Title:         s.Title,
+		Default:       s.Default,
+		AttachedPic:   s.AttachedPic,
+		Decoded:       decoded,
+	}
+}
+
+// Chapter is a named section of a media file.
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+// Stream tags use the ISO-639-2 codes, which come in both bibliographic and
+// terminology variants, while the transcription engines use ISO-639-1. This
+// covers the common languages, other tags have to be matched by their own code.
+var iso6392 = map[string][]string{
+	"ar": {"ara"},
+	"cs": {"cze", "ces"},
+	"da": {"dan"},
+	"de": {"ger", "deu"},
+	"el": {"gre", "ell"},
+	"en": {"eng"},
+	"es": {"spa"},
+	"fi": {"fin"},
+	"fr": {"fre", "fra"},
+	"he": {"heb"},
+	"hi": {"hin"},
+	"hu": {"hun"},
+	"it": {"ita"},
+	"ja": {"jpn"},
+	"ko": {"kor"},
+	"nl": {"dut", "nld"},
+	"no": {"nor"},
+	"pl": {"pol"},
+	"pt": {"por"},
+	"ro": {"rum", "ron"},
+	"ru": {"rus"},
+	"sv": {"swe"},
+	"th": {"tha"},
+	"tr": {"tur"},
+	"uk": {"ukr"},
+	"vi": {"vie"},
+	"zh": {"chi", "zho"},
+}
+
+// MatchLanguage reports whether the stream language tag matches the language
+// code. Both may be either ISO-639-1 or any of the ISO-639-2 variants, eg: de
+// matches a ger or deu tag, and ger matches a deu tag.
+func (s Stream) MatchLanguage(language string) bool {
+	tag := strings.ToLower(s.Language)
+	language = strings.ToLower(language)
+	if tag == "" || language == "" {
+		return false
+	}
+	if tag == language {
+		return true
+	}
+	for code, codes := range iso6392 {
+		isLanguage := language == code || slices.Contains(codes, language)
+		isTag := tag == code || slices.Contains(codes, tag)
+		if isLanguage && isTag {
+			return true
+		}
+	}
+	return false
+}
+
+var decoders struct {
+	mu     sync.Mutex
+	codecs map[string]struct{}
+}
+
+// audioDecoders returns the set of audio codecs the installed ffmpeg can decode.
+// The set is loaded once per process, a failure is not cached so that it will be
+// retried by the next file.
+func audioDecoders(ctx context.Context) (map[string]struct{}, error) {
+	decoders.mu.Lock()
+	defer decoders.mu.Unlock()
+
+	if decoders.codecs != nil {
+		return decoders.codecs, nil
+	}
+
+	names, err := ffmpeg.AudioDecoders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	decoders.codecs = map[string]struct{}{}
+	for _, name := range names {
+		decoders.codecs[name] = struct{}{}
+	}
+
+	return decoders.codecs, nil
+}
+
+// AudioDecoders returns the sorted names of the audio codecs which are supported
+// by the installed ffmpeg.
+func AudioDecoders(ctx context.Context) ([]string, error) {
+	codecs, err := audioDecoders(ctx)
+	if err != nil {
+		return nil, ErrFileOp{
+			Err: fmt.Errorf("failed to list decoders: %v", err),
+		}
+	}
+
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// String is a short description for logs and errors, eg: #1 audio eng aac
+func (s Stream) String() string {
+	parts := []string{fmt.Sprintf("#%v", s.Index), s.Type}
+	if s.Language != "" {
+		parts = append(parts, s.Language)
+	}
+	if s.Codec != "" {
+		parts = append(parts, s.Codec)
+	}
+	if s.Title != "" {
+		parts = append(parts, strconv.Quote(s.Title))
+	}
+	return strings.Join(parts, " ")
+}
+
+// defaultAudioStream returns the index of the decodable audio stream flagged as
+// default, or the first decodable audio stream, or -1 if there are none.
+func defaultAudioStream(streams []Stream) int {
+	index := -1
+	for _, stream := range streams {
+		if stream.Type != StreamTypeAudio || !stream.Decoded {
+			continue
+		}
+		if stream.Default {
+			return stream.Index
+		}
+		if index < 0 {
+			index = stream.Index
+		}
+	}
+	return index
+}
+
+// SelectAudioStream returns a copy of the media which uses the audio stream
+// chosen by the selector for all transforms. The selector is either an absolute
+// stream index, eg: 2, or a language code, eg: en or eng, in which case the
+// first matching stream is chosen.
+func (f Media) SelectAudioStream(selector string) (Media, error) {
+
+	if !f.initialized {
+		return f, ErrValidation{
+			Err: fmt.Errorf("media uninitialized: use media constructor"),
+		}
+	}
+
+	index, err := strconv.Atoi(selector)
+	isIndex := err == nil
+
+	for _, stream := range f.GetAudioStreams() {
+		if !stream.Decoded {
+			continue
+		}
+		if (isIndex && stream.Index == index) || (!isIndex && stream.MatchLanguage(selector)) {
+			f.useAudioStream(stream)
+			return f, nil
+		}
+	}
+
+	return f, ErrValidation{
+		Err: fmt.Errorf("no decodable audio stream matches %q: available streams: %v", selector, f.GetAudioStreams()),
+	}
+}
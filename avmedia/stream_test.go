@@ -0,0 +1,92 @@
+package avmedia
+
+import (
+	"testing"
+)
+
+func TestMatchLanguage(t *testing.T) {
+
+	tests := []struct {
+		tag      string
+		language string
+		want     bool
+	}{
+		{"eng", "en", true},
+		{"eng", "eng", true},
+		{"ENG", "En", true},
+		{"en", "eng", true},
+		{"ger", "de", true},
+		{"deu", "de", true},
+		{"deu", "ger", true},
+		{"fre", "fra", true},
+		{"eng", "de", false},
+		{"ger", "fre", false},
+		{"", "en", false},
+		{"eng", "", false},
+		{"tlh", "tlh", true}, // other tags only match their own code
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag+"/"+tt.language, func(t *testing.T) {
+			if got := (Stream{Language: tt.tag}).MatchLanguage(tt.language); got != tt.want {
+				t.Errorf("MatchLanguage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectAudioStream(t *testing.T) {
+
+	media := Media{
+		initialized: true,
+		streams: []Stream{
+			{Index: 0, Type: StreamTypeVideo},
+			{Index: 1, Type: StreamTypeAudio, Language: "eng", Decoded: true, Default: true},
+			{Index: 2, Type: StreamTypeAudio, Language: "ger", Decoded: true},
+			{Index: 3, Type: StreamTypeAudio, Language: "deu", Decoded: true},
+			{Index: 4, Type: StreamTypeAudio, Language: "fre"},
+		},
+		audioStream: 1,
+	}
+
+	tests := []struct {
+		name     string
+		selector string
+		want     int // -1 for an error
+	}{
+		{"index", "2", 2},
+		{"index of a video stream", "0", -1},
+		{"missing index", "9", -1},
+		{"iso 639-1", "en", 1},
+		{"iso 639-2", "eng", 1},
+		{"first of several tracks of the language", "de", 2},
+		{"other iso 639-2 variant", "deu", 2},
+		{"no decoder", "fr", -1},
+		{"no track of the language", "es", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := media.SelectAudioStream(tt.selector)
+			if tt.want < 0 {
+				if err == nil {
+					t.Errorf("SelectAudioStream() = %v, want error", selected.GetAudioStream())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SelectAudioStream() error = %v", err)
+			}
+			if got := selected.GetAudioStream(); got != tt.want {
+				t.Errorf("SelectAudioStream() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if media.GetAudioStream() != 1 {
+		t.Errorf("source audio stream = %v, want it unchanged", media.GetAudioStream())
+	}
+	if _, err := (Media{}).SelectAudioStream("1"); err == nil {
+		t.Errorf("SelectAudioStream() of uninitialized media error = nil, want error")
+	}
+}
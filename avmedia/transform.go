@@ -7,36 +7,16 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spiritorai/spiritor/ffmpeg"
+	"github.com/spiritorai/spiritor/logging"
 )
 
 const (
-	extMP3  = "mp3"
-	extAAC  = "aac"
-	extWAV  = "wav"
-	extOGG  = "ogg"
-	extFLAC = "flac"
-	extMP4  = "mp4"
-	extMPEG = "mpeg"
-	extMPGA = "mpga"
-	extM4A  = "m4a"
-	extWEBM = "webm"
+	extOGG = "ogg"
 )
 
-var downsampleOGGExt = map[string]struct{}{
-	extMP3: {},
-	extAAC: {},
-	extWAV: {},
-}
-
-func ExtAllowedDownsampleOGG(ext string) bool {
-	if _, ok := downsampleOGGExt[ext]; !ok {
-		return false
-	}
-	return true
-}
-
 type DownsampleStrategyOption string
 
 const (
@@ -57,6 +37,7 @@ type DownsampleOGGConfig struct {
 	OutputBasePath string                   // base path for the final output target file
 	SizeCap        int64                    // max allowed size of target file
 	Strategy       DownsampleStrategyOption // strategy for the downsampling steps
+	Timeout        time.Duration            // max time for the ffmpeg conversion, 0 for none
 }
 
 func (config DownsampleOGGConfig) Validate() error {
@@ -91,7 +72,9 @@ func (config DownsampleOGGConfig) Validate() error {
 // will handle the creation and cleanup of its own unique work directory based on the
 // system os.Temp. The final output file will be created in the DownsampleOGGConfig.OutputBasePath
 // directory defined by the caller and it is the callers responsibility to remove it.
-func (sourceMedia Media) DownsampleOGG(ctx context.Context, debug bool, config DownsampleOGGConfig) (Media, error) {
+// If the output file still exceeds the size cap then ErrSizeCapExceeded is returned
+// along with the target media, which the caller is also responsible for removing.
+func (sourceMedia Media) DownsampleOGG(ctx context.Context, config DownsampleOGGConfig) (Media, error) {
 
 	var targetMedia Media
 
@@ -107,13 +90,6 @@ func (sourceMedia Media) DownsampleOGG(ctx context.Context, debug bool, config D
 		}
 	}
 
-	if !ExtAllowedDownsampleOGG(sourceMedia.GetExt()) {
-		// TODO: Error handling, don't double wrap, implement err at public func
-		return targetMedia, ErrValidation{
-			Err: fmt.Errorf("ext not allowed: %v", sourceMedia.GetExt()),
-		}
-	}
-
 	workDir, err := os.MkdirTemp("", "spiritor")
 	if err != nil {
 		return targetMedia, ErrFileOp{
@@ -127,39 +103,41 @@ func (sourceMedia Media) DownsampleOGG(ctx context.Context, debug bool, config D
 	finalFilePath := filepath.Join(config.OutputBasePath, fmt.Sprintf("%v.%v", sourceMedia.GetName(), extOGG))
 	targetBitrate := calculateBestBitrate(sourceMedia, config.SizeCap)
 
-	if debug {
-		fmt.Printf("target bitrate for %v: %v\n", sourceMedia.GetName(), targetBitrate)
+	logging.FromContext(ctx).Debug("target bitrate", "file", sourceMedia.GetName(), "bitrate", targetBitrate, "audio_stream", sourceMedia.audioStream)
+
+	convertCtx := ctx
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		convertCtx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
 	}
 
-	if err := ffmpeg.DownsampleOpus(sourceFilePath, targetFilePath, targetBitrate); err != nil {
+	if err := ffmpeg.DownsampleOpus(convertCtx, sourceFilePath, targetFilePath, targetBitrate, sourceMedia.audioStream); err != nil {
 		return targetMedia, ErrFileOp{
 			Err: fmt.Errorf("ffmpeg failed: %v", err),
 		}
 	}
 
-	targetMedia, err = NewMedia(debug, targetFilePath)
-	if err != nil {
-		return targetMedia, fmt.Errorf("new target media failed: %w", err)
-	}
-
-	if targetMedia.GetSize() > config.SizeCap {
-		return targetMedia, ErrSizeCapExceeded{
-			SizeCap:  config.SizeCap,
-			FileSize: targetMedia.GetSize(),
-		}
-	}
-
 	if err := os.Rename(targetFilePath, finalFilePath); err != nil {
 		return targetMedia, ErrFileOp{
 			Err: fmt.Errorf("file move/rename failed: %v", err),
 		}
 	}
 
-	targetMedia, err = NewMedia(debug, finalFilePath)
+	targetMedia, err = NewMedia(ctx, finalFilePath)
 	if err != nil {
 		return targetMedia, fmt.Errorf("new final media failed: %w", err)
 	}
 
+	// The target media is returned along with the error so that the caller may
+	// recover by splitting it, eg: with SplitOGG.
+	if targetMedia.GetSize() > config.SizeCap {
+		return targetMedia, ErrSizeCapExceeded{
+			SizeCap:  config.SizeCap,
+			FileSize: targetMedia.GetSize(),
+		}
+	}
+
 	return targetMedia, nil
 }
 
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spiritorai/spiritor/compose"
+	"github.com/spiritorai/spiritor/config"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+type ComposeCmd struct {
+	Template    string   `help:"Draft template, one of: essay, script, outline, or the path to a prompt template file (default: essay)." short:"t"`
+	Prompt      string   `help:"Additional instructions for the draft, eg: the audience, the tone or the length." short:"p"`
+	Provider    string   `help:"Chat completion provider (default: openai)."`
+	Model       string   `help:"Chat model (default: gpt-4o-mini)." short:"m"`
+	ChunkTokens int      `help:"Max estimated tokens of transcript per request, longer material is reduced to notes first (default: 8000)."`
+	Output      string   `help:"Write the draft to a file instead of stdout." short:"o" type:"path"`
+	Force       bool     `help:"Force overwrite an existing output file." short:"f" default:"false"`
+	Files       []string `arg:"" name:"file" help:"Transcript files, either json outputs of scribe or plain text." type:"existingfile"`
+}
+
+// applyFlags overrides the compose config with any flags which have been set.
+func (cmd *ComposeCmd) applyFlags(conf *config.Config) {
+	if cmd.Template != "" {
+		conf.Compose.Template = cmd.Template
+	}
+	if cmd.Provider != "" {
+		conf.Compose.Provider = cmd.Provider
+	}
+	if cmd.Model != "" {
+		conf.Compose.Model = cmd.Model
+	}
+	if cmd.ChunkTokens > 0 {
+		conf.Compose.ChunkTokens = cmd.ChunkTokens
+	}
+}
+
+func (cmd *ComposeCmd) Run(ctx *Context) error {
+
+	conf := ctx.Config
+	cmd.applyFlags(&conf)
+
+	if !compose.ProviderAllowed(conf.Compose.Provider) {
+		return fmt.Errorf("unsupported provider: %v: available providers: %v", conf.Compose.Provider, strings.Join(compose.Providers(), ", "))
+	}
+
+	if cmd.Output != "" && !cmd.Force {
+		if _, err := os.Stat(cmd.Output); err == nil {
+			return fmt.Errorf("output %v already exists, use -f to overwrite it", cmd.Output)
+		}
+	}
+
+	tmpl, err := compose.LoadTemplate(conf.Compose.Template)
+	if err != nil {
+		return err
+	}
+
+	var sources []compose.Source
+	for _, path := range cmd.Files {
+		source, err := loadComposeSource(path, conf)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	provider, err := newComposeProvider(conf)
+	if err != nil {
+		return err
+	}
+
+	composer := compose.Composer{
+		Provider: provider,
+		Template: tmpl,
+		Options: compose.Options{
+			ChunkTokens: conf.Compose.ChunkTokens,
+			Workers:     conf.Compose.Workers,
+		},
+	}
+
+	ctx.Logger.Info("composing", "template", tmpl.Name, "provider", conf.Compose.Provider, "model", conf.Compose.Model, "files", len(sources))
+
+	draft, err := composer.Compose(ctx.Ctx, sources, cmd.Prompt)
+	if err != nil {
+		return fmt.Errorf("failed to compose draft: %w", err)
+	}
+
+	if cmd.Output == "" {
+		fmt.Println(draft)
+		return nil
+	}
+
+	if err := os.WriteFile(cmd.Output, []byte(draft+"\n"), 0666); err != nil {
+		return fmt.Errorf("failed to write draft: %v", err)
+	}
+
+	ctx.Logger.Info("draft complete", "output", cmd.Output)
+	return nil
+}
+
+// loadComposeSource reads a transcript for compose. Json transcripts are written
+// out as text with their speakers and paragraphs, any other file is used as is.
+func loadComposeSource(path string, conf config.Config) (compose.Source, error) {
+
+	source := compose.Source{Name: filepath.Base(path)}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		doc, err := loadJSONTranscript(path)
+		if err != nil {
+			return source, err
+		}
+
+		text, err := doc.Transcript.Format("txt", transcribe.FormatOptions{
+			Paragraphs: transcribe.ParagraphOptions{
+				MaxSentences: conf.Paragraphs.MaxSentences,
+				PauseGap:     seconds(conf.Paragraphs.PauseGap),
+			},
+		})
+		if err != nil {
+			return source, fmt.Errorf("failed to format transcript %v: %v", path, err)
+		}
+
+		source.Text = string(text)
+		source.Language = transcribe.NormalizeLanguage(doc.Transcript.Language)
+		return source, nil
+	}
+
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return source, fmt.Errorf("failed to read transcript: %v", err)
+	}
+	source.Text = string(text)
+
+	return source, nil
+}
+
+// newComposeProvider builds the configured chat completion provider. The base
+// url and api key fall back to the openai section, so an openai key set up for
+// scribe works for compose too.
+func newComposeProvider(conf config.Config) (compose.Provider, error) {
+
+	providerConfig := compose.ProviderConfig{
+		BaseURL: conf.Compose.BaseURL,
+		APIKey:  conf.Compose.APIKey,
+		Model:   conf.Compose.Model,
+		Timeout: seconds(conf.Timeouts.Compose),
+
+		MaxRetries:        conf.OpenAI.MaxRetries,
+		RequestsPerMinute: conf.OpenAI.RequestsPerMinute,
+	}
+
+	if providerConfig.BaseURL == "" {
+		providerConfig.BaseURL = conf.OpenAI.BaseURL
+	}
+	if providerConfig.APIKey == "" {
+		providerConfig.APIKey = conf.OpenAI.APIKey
+	}
+
+	provider, err := compose.NewProvider(conf.Compose.Provider, providerConfig)
+	if err != nil && conf.Compose.Provider == compose.ProviderOpenAI {
+		return nil, fmt.Errorf("failed to initialize provider %v: %v: set it with `spiritor config set` or the %v env var", conf.Compose.Provider, err, config.EnvName("openai.api_key"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize provider %v: %v", conf.Compose.Provider, err)
+	}
+
+	return provider, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spiritorai/spiritor/config"
+)
+
+type ConfigCmd struct {
+	Show ConfigShowCmd `cmd:"" help:"Prints the merged config from all files and env vars."`
+	Set  ConfigSetCmd  `cmd:"" help:"Sets a value in the user config file."`
+	Path ConfigPathCmd `cmd:"" help:"Prints the user config file path."`
+}
+
+type ConfigShowCmd struct {
+	Reveal bool `help:"Print secrets such as api keys without masking them."`
+}
+
+func (cmd *ConfigShowCmd) Run(ctx *Context) error {
+
+	conf := ctx.Config
+	if !cmd.Reveal {
+		conf = conf.Redacted()
+	}
+
+	data, err := json.MarshalIndent(conf.Map(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
+
+type ConfigSetCmd struct {
+	Key   string `arg:"" help:"Config key, eg: openai.api_key"`
+	Value string `arg:"" help:"Config value, lists are comma separated, eg: txt,srt"`
+}
+
+func (cmd *ConfigSetCmd) Run(ctx *Context) error {
+
+	// Only the user config file is modified, so the other layers are not
+	// loaded here or they would be copied into the file.
+	var conf config.Config
+	if err := conf.Set(cmd.Key, cmd.Value); err != nil {
+		return fmt.Errorf("%v: valid keys: %v", err, config.Keys())
+	}
+
+	if err := config.SetFile(ctx.ConfigPath, cmd.Key, cmd.Value); err != nil {
+		return err
+	}
+
+	fmt.Printf("set: %v in %v\n", cmd.Key, ctx.ConfigPath)
+	return nil
+}
+
+type ConfigPathCmd struct{}
+
+func (cmd *ConfigPathCmd) Run(ctx *Context) error {
+
+	fmt.Println(ctx.ConfigPath)
+
+	if _, err := os.Stat(ctx.ConfigPath); err != nil {
+		ctx.Logger.Debug("config file not found", "err", err)
+	}
+
+	return nil
+}
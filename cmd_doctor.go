@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+type DoctorCmd struct {
+	Engine string `help:"Transcription engine to check (default: scribe.engine)." short:"e"`
+}
+
+// doctorCheck is a single line of the doctor report.
+type doctorCheck struct {
+	name   string
+	err    error
+	detail string
+}
+
+func (cmd *DoctorCmd) Run(ctx *Context) error {
+
+	conf := ctx.Config
+	if cmd.Engine != "" {
+		conf.Scribe.Engine = cmd.Engine
+	}
+
+	var checks []doctorCheck
+
+	for _, app := range []string{"ffmpeg", "ffprobe"} {
+		path, err := exec.LookPath(app)
+		checks = append(checks, doctorCheck{name: app, err: err, detail: path})
+	}
+
+	decoders, err := avmedia.AudioDecoders(ctx.Ctx)
+	checks = append(checks, doctorCheck{name: "audio decoders", err: err, detail: fmt.Sprintf("%v available", len(decoders))})
+
+	checks = append(checks, cmd.checkEngine(ctx, conf.Scribe.Engine))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+	failed := 0
+	for _, check := range checks {
+		status, detail := "ok", check.detail
+		if check.err != nil {
+			status, detail = "FAIL", check.err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", check.name, status, detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%v of %v checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checkEngine builds the transcription engine and checks that its api is
+// reachable. The detail names the endpoint so that it is clear where the audio
+// will be sent.
+func (cmd *DoctorCmd) checkEngine(ctx *Context, engine string) doctorCheck {
+
+	check := doctorCheck{name: "engine " + engine}
+
+	if !transcribe.EngineAllowed(engine) {
+		check.err = fmt.Errorf("unsupported engine, available engines: %v", transcribe.Engines())
+		return check
+	}
+
+	conf := ctx.Config
+	conf.Scribe.Engine = engine
+	transcriber, err := newTranscriber(conf)
+	if err != nil {
+		check.err = err
+		return check
+	}
+
+	info := transcriber.Info()
+	check.detail = fmt.Sprintf("model %v", info.Model)
+	if openai, ok := transcriber.(*transcribe.OpenAI); ok {
+		check.detail = fmt.Sprintf("audio is sent to %v, model %v", openai.BaseURL, info.Model)
+	}
+
+	if checker, ok := transcriber.(transcribe.Checker); ok {
+		check.err = checker.Check(ctx.Ctx)
+	}
+
+	return check
+}
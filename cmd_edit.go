@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/edit"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+type EditCmd struct {
+	Transcript string        `help:"Json transcript of the file with word timestamps (default: <file>.json)." short:"t" type:"path"`
+	Output     string        `help:"Output file path, its extension selects the format (default: <name>.edited.<ext> next to the file)." short:"o" type:"path"`
+	Crossfade  time.Duration `help:"Length of the crossfade at each cut point, 0 for hard cuts." default:"${edit_crossfade}"`
+	Padding    time.Duration `help:"Time kept around each kept word, up to the neighboring deleted words." default:"${edit_padding}"`
+	AudioOnly  bool          `help:"Drop the video of video files."`
+	Force      bool          `help:"Force overwrite an existing output file." short:"f" default:"false"`
+	File       string        `arg:"" name:"file" help:"Source audio or video file." type:"existingfile"`
+	Text       string        `arg:"" name:"text" help:"Edited copy of the transcript text, with the words to cut deleted." type:"existingfile"`
+}
+
+func (cmd *EditCmd) Run(ctx *Context) error {
+
+	if cmd.Crossfade < 0 || cmd.Padding < 0 {
+		return fmt.Errorf("--crossfade and --padding must not be negative")
+	}
+
+	media, err := avmedia.NewMedia(ctx.Ctx, cmd.File)
+	if err != nil {
+		return fmt.Errorf("failed to open %v: %w", cmd.File, err)
+	}
+
+	transcriptPath := cmd.Transcript
+	if transcriptPath == "" {
+		transcriptPath = buildOutputPath(media, "json")
+	}
+
+	doc, err := loadJSONTranscript(transcriptPath)
+	if err != nil {
+		return err
+	}
+
+	// The cut must be rendered from the audio stream which the word timings
+	// were transcribed from.
+	if doc.Source != nil {
+		media, err = media.SelectAudioStream(strconv.Itoa(doc.Source.AudioStream))
+		if err != nil {
+			return fmt.Errorf("transcribed audio stream %v of %v not found: %w", doc.Source.AudioStream, media.GetName(), err)
+		}
+	}
+
+	text, err := os.ReadFile(cmd.Text)
+	if err != nil {
+		return fmt.Errorf("failed to read edited text: %v", err)
+	}
+
+	plan, err := edit.NewPlan(doc.Transcript.Words, string(text), media.GetDuration(), edit.Options{
+		Padding:   cmd.Padding,
+		Crossfade: cmd.Crossfade,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to plan edit of %v: %w", media.GetName(), err)
+	}
+
+	if plan.Deleted == 0 {
+		return fmt.Errorf("no words were deleted from the transcript text")
+	}
+	if plan.Ignored > 0 {
+		ctx.Logger.Warn("edited text has words which are not in the transcript, they were ignored", "words", plan.Ignored)
+	}
+
+	output := cmd.Output
+	if output == "" {
+		ext := filepath.Ext(media.GetPath())
+		output = strings.TrimSuffix(media.GetPath(), ext) + ".edited" + ext
+	}
+	if _, err := os.Stat(output); err == nil && !cmd.Force {
+		return fmt.Errorf("output %v already exists, use -f to overwrite it", output)
+	}
+
+	ctx.Logger.Info("rendering edit", "file", media.GetName(), "kept_words", plan.Kept, "deleted_words", plan.Deleted, "ranges", len(plan.Ranges), "duration", plan.Duration().Round(time.Second))
+
+	// The render writes to a temp file which is only moved over the output once
+	// complete, so an existing output is kept if it fails.
+	result, err := media.Cut(ctx.Ctx, avmedia.CutConfig{
+		OutputPath: output,
+		Ranges:     plan.Ranges,
+		Crossfade:  cmd.Crossfade,
+		AudioOnly:  cmd.AudioOnly,
+		Timeout:    seconds(ctx.Config.Timeouts.Render),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render edit of %v: %w", media.GetName(), err)
+	}
+
+	ctx.Logger.Info("edit complete", "output", result.GetPath(), "duration", result.GetDuration().Round(time.Second))
+	return nil
+}
+
+// loadJSONTranscript reads a transcript written by the json output format.
+func loadJSONTranscript(path string) (transcribe.JSONDocument, error) {
+
+	var doc transcribe.JSONDocument
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return doc, fmt.Errorf("transcript %v not found, create it with: spiritor scribe -o json", path)
+	}
+	if err != nil {
+		return doc, fmt.Errorf("failed to read transcript: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return doc, fmt.Errorf("failed to parse transcript %v: %v", path, err)
+	}
+
+	if doc.SchemaVersion > transcribe.JSONSchemaVersion {
+		return doc, fmt.Errorf("transcript %v has an unsupported schema version: %v", path, doc.SchemaVersion)
+	}
+
+	return doc, nil
+}
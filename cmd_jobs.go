@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spiritorai/spiritor/scribe"
+)
+
+type JobsCmd struct{}
+
+func (cmd *JobsCmd) Run(ctx *Context) error {
+
+	// The state is only read, so the jobs of a batch which is still running are
+	// listed too.
+	records, err := scribe.ReadRecords(ctx.Config.Scribe.StateDir)
+	if err != nil {
+		return fmt.Errorf("failed to read job state: %v", err)
+	}
+
+	if len(records) == 0 {
+		fmt.Printf("no jobs in %v\n", ctx.Config.Scribe.StateDir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tSTATUS\tUPDATED\tERROR")
+	for _, record := range records {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", record.Source, record.Status(), record.UpdatedAt.Local().Format(time.DateTime), record.Err)
+	}
+	return w.Flush()
+}
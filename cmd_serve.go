@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spiritorai/spiritor/config"
+)
+
+// shutdownTimeout is how long the requests in flight have to finish once the
+// server is interrupted.
+const shutdownTimeout = 10 * time.Second
+
+// ServeCmd runs the scribe pipeline behind an http api. Every submitted file is
+// transcribed, whether or not it already has outputs.
+type ServeCmd struct {
+	ScribeFlags `embed:""`
+	Addr        string   `help:"Listen address, eg: :8080 for all interfaces (default: 127.0.0.1:8080)." short:"a"`
+	AllowDir    []string `help:"Dir of the server-local files which may be transcribed, may be repeated (default: none)." type:"existingdir"`
+	QueueSize   int      `help:"Max jobs waiting for a worker before new jobs are refused (default: 100)."`
+}
+
+// applyFlags overrides the scribe and serve config with any flags which have
+// been set.
+func (cmd *ServeCmd) applyFlags(conf *config.Config) {
+	cmd.ScribeFlags.applyFlags(conf)
+	if cmd.Addr != "" {
+		conf.Serve.Addr = cmd.Addr
+	}
+	if len(cmd.AllowDir) > 0 {
+		conf.Serve.AllowedDirs = cmd.AllowDir
+	}
+	if cmd.QueueSize > 0 {
+		conf.Serve.QueueSize = cmd.QueueSize
+	}
+}
+
+func (cmd *ServeCmd) Run(ctx *Context) error {
+
+	conf := ctx.Config
+	cmd.applyFlags(&conf)
+
+	log := ctx.Logger
+	log.Debug("params", "engine", conf.Scribe.Engine, "language", conf.Scribe.Language, "outputs", conf.Scribe.Outputs, "serve", conf.Redacted().Serve)
+
+	scriber, err := newScriber(conf, cmd.NoCache)
+	if err != nil {
+		return err
+	}
+	defer scriber.close()
+
+	srv, err := newServer(ctx.Ctx, conf, scriber)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", conf.Serve.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %v", err)
+	}
+
+	// Requests carry the root ctx, and with it the logger.
+	httpServer := &http.Server{
+		Handler:           srv.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx.Ctx },
+	}
+
+	srv.start()
+
+	if conf.Serve.Token == "" {
+		log.Warn("no serve.token is set, anyone who can reach the server can use it")
+	}
+	log.Info("serving", "addr", listener.Addr().String(), "upload_dir", srv.uploadDir, "allowed_dirs", srv.allowedDirs, "outputs", scriber.outputs)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+
+	select {
+	case err = <-served:
+	case <-ctx.Ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		err = httpServer.Shutdown(shutdownCtx)
+		cancel()
+	}
+
+	// The jobs in progress fail fast once the root ctx is canceled, and they are
+	// left in the job state to be resumed with scribe --resume.
+	srv.close()
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %v", err)
+	}
+
+	log.Info("stopped serving")
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/config"
+	"github.com/spiritorai/spiritor/transcribe"
+	"github.com/spiritorai/spiritor/tts"
+)
+
+type SpeakCmd struct {
+	Output   string  `help:"Output file path, one of mp3, ogg or opus (default: <file>.mp3 next to the file)." short:"o" type:"path"`
+	Voice    string  `help:"Voice name (default: alloy)." short:"v"`
+	Provider string  `help:"Speech synthesis provider (default: openai)."`
+	Model    string  `help:"Speech model (default: tts-1)." short:"m"`
+	Speed    float64 `help:"Speaking speed from 0.25 to 4 (default: the provider default)."`
+	Language string  `help:"Language of the text as an ISO-639-1 code, used to split it into sentences." short:"l" default:"en"`
+	Chapters bool    `help:"Add a chapter marker for every markdown heading of the text."`
+	Force    bool    `help:"Force overwrite an existing output file." short:"f" default:"false"`
+	File     string  `arg:"" name:"file" help:"Text or markdown file to read out loud, eg: a draft from compose." type:"existingfile"`
+}
+
+// applyFlags overrides the speak config with any flags which have been set.
+func (cmd *SpeakCmd) applyFlags(conf *config.Config) {
+	if cmd.Voice != "" {
+		conf.Speak.Voice = cmd.Voice
+	}
+	if cmd.Provider != "" {
+		conf.Speak.Provider = cmd.Provider
+	}
+	if cmd.Model != "" {
+		conf.Speak.Model = cmd.Model
+	}
+	if cmd.Speed != 0 {
+		conf.Speak.Speed = cmd.Speed
+	}
+}
+
+func (cmd *SpeakCmd) Run(ctx *Context) error {
+
+	conf := ctx.Config
+	cmd.applyFlags(&conf)
+
+	if !tts.ProviderAllowed(conf.Speak.Provider) {
+		return fmt.Errorf("unsupported provider: %v: available providers: %v", conf.Speak.Provider, strings.Join(tts.Providers(), ", "))
+	}
+
+	language := transcribe.NormalizeLanguage(cmd.Language)
+	if !transcribe.LanguageAllowed(language) || language == transcribe.LanguageAuto {
+		return fmt.Errorf("unsupported language: %v: use one of: %v", cmd.Language, strings.Join(transcribe.Languages(), ", "))
+	}
+
+	output := cmd.Output
+	if output == "" {
+		output = strings.TrimSuffix(cmd.File, filepath.Ext(cmd.File)) + ".mp3"
+	}
+	if _, err := os.Stat(output); err == nil && !cmd.Force {
+		return fmt.Errorf("output %v already exists, use -f to overwrite it", output)
+	}
+
+	// The output is checked up front since it is only written once every chunk
+	// has been synthesized.
+	if err := (avmedia.ConcatConfig{OutputPath: output}).Validate(); err != nil {
+		return err
+	}
+
+	text, err := os.ReadFile(cmd.File)
+	if err != nil {
+		return fmt.Errorf("failed to read text: %v", err)
+	}
+
+	provider, err := newSpeakProvider(conf)
+	if err != nil {
+		return err
+	}
+
+	speaker := tts.Speaker{
+		Provider: provider,
+		Options: tts.Options{
+			MaxChars: conf.Speak.MaxChars,
+			Workers:  conf.Speak.Workers,
+			Language: language,
+		},
+	}
+
+	result, err := speaker.Speak(ctx.Ctx, tts.ParseSections(string(text)), tts.SpeakConfig{
+		OutputPath: output,
+		Chapters:   cmd.Chapters,
+		Timeout:    seconds(ctx.Config.Timeouts.Render),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to speak %v: %w", filepath.Base(cmd.File), err)
+	}
+
+	ctx.Logger.Info("speech complete", "output", result.GetPath(), "duration", result.GetDuration().Round(time.Second), "chapters", len(result.GetChapters()))
+	return nil
+}
+
+// newSpeakProvider builds the configured speech synthesis provider. The base url
+// and api key fall back to the openai section, see newComposeProvider.
+func newSpeakProvider(conf config.Config) (tts.Provider, error) {
+
+	providerConfig := tts.ProviderConfig{
+		BaseURL: conf.Speak.BaseURL,
+		APIKey:  conf.Speak.APIKey,
+		Model:   conf.Speak.Model,
+		Voice:   conf.Speak.Voice,
+		Speed:   conf.Speak.Speed,
+		Timeout: seconds(conf.Timeouts.Speak),
+
+		MaxRetries:        conf.OpenAI.MaxRetries,
+		RequestsPerMinute: conf.OpenAI.RequestsPerMinute,
+	}
+
+	if providerConfig.BaseURL == "" {
+		providerConfig.BaseURL = conf.OpenAI.BaseURL
+	}
+	if providerConfig.APIKey == "" {
+		providerConfig.APIKey = conf.OpenAI.APIKey
+	}
+
+	provider, err := tts.NewProvider(conf.Speak.Provider, providerConfig)
+	if err != nil && conf.Speak.Provider == tts.ProviderOpenAI {
+		return nil, fmt.Errorf("failed to initialize provider %v: %v: set it with `spiritor config set` or the %v env var", conf.Speak.Provider, err, config.EnvName("openai.api_key"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize provider %v: %v", conf.Speak.Provider, err)
+	}
+
+	return provider, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spiritorai/spiritor/config"
+	"github.com/spiritorai/spiritor/scribe"
+	"github.com/spiritorai/spiritor/watcher"
+)
+
+type WatchCmd struct {
+	ScribeFlags  `embed:""`
+	Force        bool    `help:"Force overwrite existing transcripts." short:"f" default:"false"`
+	Recursive    bool    `help:"Also watch the sub dirs." short:"r"`
+	Poll         bool    `help:"Poll the dir instead of using filesystem notifications, eg: for network shares."`
+	PollInterval float64 `help:"Seconds between scans when polling (default: 10)."`
+	Settle       float64 `help:"Seconds a file must stay unchanged before it is transcribed (default: 5)."`
+	Dir          string  `arg:"" name:"dir" help:"Dir to watch for new recordings." type:"existingdir"`
+}
+
+// applyFlags overrides the scribe and watch config with any flags which have
+// been set.
+func (cmd *WatchCmd) applyFlags(conf *config.Config) {
+	cmd.ScribeFlags.applyFlags(conf)
+	if cmd.Recursive {
+		conf.Watch.Recursive = true
+	}
+	if cmd.Poll {
+		conf.Watch.Poll = true
+	}
+	if cmd.PollInterval > 0 {
+		conf.Watch.PollInterval = cmd.PollInterval
+	}
+	if cmd.Settle > 0 {
+		conf.Watch.Settle = cmd.Settle
+	}
+}
+
+func (cmd *WatchCmd) Run(ctx *Context) error {
+
+	conf := ctx.Config
+	cmd.applyFlags(&conf)
+
+	log := ctx.Logger
+	log.Debug("params", "force", cmd.Force, "engine", conf.Scribe.Engine, "language", conf.Scribe.Language, "outputs", conf.Scribe.Outputs, "dir", cmd.Dir, "watch", conf.Watch)
+
+	scriber, err := newScriber(conf, cmd.NoCache)
+	if err != nil {
+		return err
+	}
+	defer scriber.close()
+
+	w, err := watcher.New(cmd.Dir, watcher.Options{
+		Recursive:    conf.Watch.Recursive,
+		Poll:         conf.Watch.Poll,
+		PollInterval: seconds(conf.Watch.PollInterval),
+		Settle:       seconds(conf.Watch.Settle),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch %v: %v", cmd.Dir, err)
+	}
+
+	// A file which changes again while its job is in the pipeline is left to
+	// that job, since the job state of a source can only track one job.
+	var mu sync.Mutex
+	inProgress := map[string]bool{}
+
+	pipeline := scriber.start(ctx.Ctx, func(job scribe.Job, outputs []string, err error) {
+		mu.Lock()
+		delete(inProgress, job.SourceMedia.GetPath())
+		mu.Unlock()
+	})
+
+	log.Info("watching", "dir", cmd.Dir, "recursive", conf.Watch.Recursive, "outputs", scriber.outputs, "state_dir", conf.Scribe.StateDir)
+
+	// Errors are logged per file so that one bad recording does not stop the
+	// watch, only an interrupt does.
+	err = w.Run(ctx.Ctx, func(path string) {
+
+		if isScribeOutput(path, scriber.outputs) {
+			return
+		}
+
+		mu.Lock()
+		busy := inProgress[path]
+		mu.Unlock()
+		if busy {
+			log.Info("skipped: job already in progress", "file", path)
+			return
+		}
+
+		resume, force, done := watchJobMode(scriber.state, path, cmd.Force)
+		if done {
+			log.Debug("skipped: job already completed", "file", path)
+			return
+		}
+
+		job, err := scriber.prepare(ctx.Ctx, path, resume, force)
+		var errSkip skipError
+		if errors.As(err, &errSkip) {
+			return
+		}
+		if err != nil {
+			log.Error("failed", "file", path, "err", err)
+			return
+		}
+
+		mu.Lock()
+		inProgress[job.SourceMedia.GetPath()] = true
+		mu.Unlock()
+		pipeline.Submit(job)
+	})
+
+	// The jobs in the pipeline are drained before returning, they fail fast once
+	// the ctx has been canceled and are resumed by the next watch.
+	pipeline.Close()
+
+	if err != nil {
+		return err
+	}
+
+	log.Info("stopped watching", "dir", cmd.Dir)
+	return nil
+}
+
+// watchJobMode decides how the job of a file found by the watcher starts, based
+// on its record in the job state. Unfinished jobs are resumed, eg: after the
+// watch was restarted, and completed jobs are done unless forced. A file which
+// has been modified since its job was recorded is transcribed again from the
+// start. Completed jobs are checked here so that the files of a large dir are
+// not probed again every time the watch starts.
+func watchJobMode(state *scribe.State, path string, force bool) (resume, restart, done bool) {
+
+	record, ok := state.Lookup(path)
+	if !ok {
+		return false, force, false
+	}
+
+	info, err := os.Stat(path)
+	if err == nil && info.ModTime().After(record.UpdatedAt) {
+		return false, true, false
+	}
+
+	return true, force, record.Stage == scribe.StageCompleted && !force
+}
+
+// isScribeOutput reports whether the file was written by scribe next to a
+// source file, ie: one of the outputs or a speaker names file.
+func isScribeOutput(path string, outputs []string) bool {
+	for _, output := range append([]string{speakerNamesExt}, outputs...) {
+		source, ok := strings.CutSuffix(path, "."+output)
+		if !ok {
+			continue
+		}
+		if info, err := os.Stat(source); err == nil && info.Mode().IsRegular() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spiritorai/spiritor/logging"
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// Source is the text of a single transcript.
+type Source struct {
+	Name     string // shown to the model, eg: the transcript file name
+	Text     string // transcript text, paragraphs are separated by blank lines
+	Language string // ISO-639-1 code used to split sentences, empty for english
+}
+
+// Options controls how the material is split up to fit the model context. Zero
+// values are replaced by the defaults.
+type Options struct {
+	ChunkTokens int // max estimated tokens of material per request
+	Workers     int // max concurrent requests
+}
+
+const (
+	defaultChunkTokens = 8000
+	defaultWorkers     = 4
+
+	// charsPerToken is the rough size of a token in english text. It is only an
+	// estimate, so the default chunk size leaves plenty of room in the context of
+	// current models for the prompt and the reply.
+	charsPerToken = 4
+)
+
+func (opts Options) withDefaults() Options {
+	if opts.ChunkTokens <= 0 {
+		opts.ChunkTokens = defaultChunkTokens
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	return opts
+}
+
+const systemPrompt = `You are a skilled ghostwriter who turns spoken material into writing. Keep the ideas, the phrasing and the examples of the speakers, never add facts which are not in the material, and write in the language of the material unless asked otherwise.`
+
+// notesPrompt is the map step over the chunks of long material. The arguments
+// are the part number, the part count, the source names, the extra instructions
+// and the chunk.
+const notesPrompt = `The following is part %d of %d of the transcripts of %v, which will later be written up into a single draft along with the other parts.
+
+Take notes of this part for the writer: the key ideas, arguments, stories, examples and memorable quotes, in the order in which they appear. Keep the quotes word for word and say who said them when the speaker is known. Leave out the small talk and the filler. Write only the notes.%v
+
+%v`
+
+// condensePrompt is the reduce step for notes which are still too long for a
+// single request. It takes the same arguments as the notesPrompt.
+const condensePrompt = `The following is part %d of %d of the notes which were taken from the transcripts of %v.
+
+Merge these notes into a shorter set of notes in the same order. Drop the duplicates, but keep every distinct idea, story, example and quote. Write only the notes.%v
+
+%v`
+
+// Composer writes drafts from transcripts with a provider. Material which does
+// not fit in a single request is first reduced to notes, chunk by chunk, and the
+// notes are condensed until they fit, so multi hour material can be drafted with
+// the context of any model.
+type Composer struct {
+	Provider Provider
+	Template Template
+	Options  Options
+}
+
+// Compose writes the draft of the sources, with the extra instructions if given,
+// eg: the audience or the length.
+func (c Composer) Compose(ctx context.Context, sources []Source, instructions string) (string, error) {
+
+	opts := c.Options.withDefaults()
+	maxChars := opts.ChunkTokens * charsPerToken
+
+	var names []string
+	var blocks []string
+	var language string
+	for _, source := range sources {
+		sourceBlocks, err := splitBlocks(source.Text, source.Language, maxChars)
+		if err != nil {
+			return "", fmt.Errorf("failed to split %v: %w", source.Name, err)
+		}
+		if len(sourceBlocks) == 0 {
+			continue
+		}
+		names = append(names, source.Name)
+
+		// Each source is labeled so that the model can tell the transcripts apart
+		// when several of them share a chunk.
+		if len(sources) > 1 {
+			sourceBlocks[0] = fmt.Sprintf("[Transcript: %v]\n\n%v", source.Name, sourceBlocks[0])
+		}
+		blocks = append(blocks, sourceBlocks...)
+		if language == "" {
+			language = source.Language
+		}
+	}
+
+	if len(blocks) == 0 {
+		return "", fmt.Errorf("transcripts have no text")
+	}
+
+	log := logging.FromContext(ctx)
+	sourceNames := strings.Join(names, ", ")
+	extra := ""
+	if instructions != "" {
+		extra = "\n\nThe draft will follow these instructions, so keep everything which they need: " + instructions
+	}
+
+	chunks := pack(blocks, maxChars)
+	notes := false
+	for len(chunks) > 1 {
+
+		prompt := notesPrompt
+		if notes {
+			prompt = condensePrompt
+		}
+
+		log.Info("taking notes", "parts", len(chunks), "condense", notes)
+		results, err := c.mapChunks(ctx, opts.Workers, chunks, func(i int, chunk string) string {
+			return fmt.Sprintf(prompt, i+1, len(chunks), sourceNames, extra, chunk)
+		})
+		if err != nil {
+			return "", err
+		}
+
+		next, err := splitNotes(results, language, maxChars)
+		if err != nil {
+			return "", err
+		}
+		next = pack(next, maxChars)
+
+		// Notes which do not get any shorter would never fit.
+		if len(next) >= len(chunks) {
+			return "", fmt.Errorf("notes do not fit in %v tokens, raise the chunk size", opts.ChunkTokens)
+		}
+
+		chunks = next
+		notes = true
+	}
+
+	prompt, err := c.Template.Render(TemplateData{
+		Material:     chunks[0],
+		Notes:        notes,
+		Instructions: instructions,
+		Sources:      names,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Info("writing draft", "template", c.Template.Name)
+	draft, err := c.Provider.Complete(ctx, []Message{
+		{Role: RoleSystem, Content: systemPrompt},
+		{Role: RoleUser, Content: prompt},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write draft: %w", err)
+	}
+
+	return draft, nil
+}
+
+// mapChunks sends the prompt of every chunk with up to workers requests at once
+// and returns the replies in the order of the chunks. The first error cancels
+// the remaining requests.
+func (c Composer) mapChunks(ctx context.Context, workers int, chunks []string, prompt func(i int, chunk string) string) ([]string, error) {
+
+	results := make([]string, len(chunks))
+	failed, err := utils.Parallel(ctx, workers, len(chunks), func(ctx context.Context, i int) error {
+		var err error
+		results[i], err = c.Provider.Complete(ctx, []Message{
+			{Role: RoleSystem, Content: systemPrompt},
+			{Role: RoleUser, Content: prompt(i, chunks[i])},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to take notes of part %d: %w", failed+1, err)
+	}
+
+	return results, nil
+}
+
+var blankLines = regexp.MustCompile(`\n\s*\n`)
+
+// splitBlocks splits the text into its paragraphs, and paragraphs which are too
+// long for a chunk into runs of sentences.
+func splitBlocks(text string, language string, maxChars int) ([]string, error) {
+
+	var blocks []string
+	for _, paragraph := range blankLines.Split(text, -1) {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if len(paragraph) <= maxChars {
+			blocks = append(blocks, paragraph)
+			continue
+		}
+
+		sentences, err := utils.SplitSentencesMax(paragraph, language, maxChars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split sentences: %w", err)
+		}
+		blocks = append(blocks, pack(sentences, maxChars)...)
+	}
+
+	return blocks, nil
+}
+
+// splitNotes splits the replies of the map step into blocks for packing. The
+// notes are written in the language of the material, which is taken from the
+// first source.
+func splitNotes(replies []string, language string, maxChars int) ([]string, error) {
+	var blocks []string
+	for _, reply := range replies {
+		replyBlocks, err := splitBlocks(reply, language, maxChars)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, replyBlocks...)
+	}
+	return blocks, nil
+}
+
+// pack joins consecutive blocks into chunks of up to maxChars, keeping the
+// blocks whole.
+func pack(blocks []string, maxChars int) []string {
+	var chunks []string
+	var b strings.Builder
+	for _, block := range blocks {
+		if b.Len() > 0 && b.Len()+len(block)+2 > maxChars {
+			chunks = append(chunks, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(block)
+	}
+	if b.Len() > 0 {
+		chunks = append(chunks, b.String())
+	}
+	return chunks
+}
@@ -0,0 +1,174 @@
+package compose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spiritorai/spiritor/apiclient/apitest"
+)
+
+// newChatStub starts a stand-in for an OpenAI compatible chat completions
+// endpoint. The reply function is called with the user message of each request
+// and returns the reply along with its finish reason.
+func newChatStub(t *testing.T, reply func(prompt string) (string, string)) *httptest.Server {
+	t.Helper()
+
+	return apitest.NewServer(t, openAIChatEP, func(w http.ResponseWriter, r *http.Request) {
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode chat request: %v", err)
+		}
+		if req.Model != "chat-test" {
+			t.Errorf("model = %q, want %q", req.Model, "chat-test")
+		}
+		if len(req.Messages) != 2 || req.Messages[0].Role != RoleSystem || req.Messages[1].Role != RoleUser {
+			t.Fatalf("messages = %+v, want a system and a user message", req.Messages)
+		}
+
+		content, finishReason := reply(req.Messages[1].Content)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{
+				"message":       map[string]string{"role": RoleAssistant, "content": content},
+				"finish_reason": finishReason,
+			}},
+		})
+	})
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server) *OpenAI {
+	t.Helper()
+
+	provider, err := NewOpenAI(ProviderConfig{
+		BaseURL: server.URL + "/v1",
+		APIKey:  apitest.APIKey,
+		Model:   "chat-test",
+	})
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	return provider
+}
+
+func TestOpenAIComplete(t *testing.T) {
+
+	server := newChatStub(t, func(prompt string) (string, string) {
+		return "  Hello there.\n", "stop"
+	})
+
+	reply, err := newTestProvider(t, server).Complete(context.Background(), []Message{
+		{Role: RoleSystem, Content: "system"},
+		{Role: RoleUser, Content: "user"},
+	})
+	if err != nil {
+		t.Fatalf("complete failed: %v", err)
+	}
+	if reply != "Hello there." {
+		t.Errorf("reply = %q, want %q", reply, "Hello there.")
+	}
+}
+
+func TestOpenAICompleteCutOff(t *testing.T) {
+
+	server := newChatStub(t, func(prompt string) (string, string) {
+		return "The start of a", "length"
+	})
+
+	_, err := newTestProvider(t, server).Complete(context.Background(), []Message{
+		{Role: RoleSystem, Content: "system"},
+		{Role: RoleUser, Content: "user"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "cut off") {
+		t.Fatalf("err = %v, want the reply to be cut off", err)
+	}
+}
+
+func TestComposeSingleRequest(t *testing.T) {
+
+	var prompts []string
+	server := newChatStub(t, func(prompt string) (string, string) {
+		prompts = append(prompts, prompt)
+		return "# Draft", "stop"
+	})
+
+	composer := Composer{Provider: newTestProvider(t, server), Template: templates[TemplateEssay]}
+	draft, err := composer.Compose(context.Background(), []Source{
+		{Name: "talk.json", Text: "SPEAKER_1: We should talk about gardens.\n\nSPEAKER_2: Yes."},
+	}, "for beginners")
+	if err != nil {
+		t.Fatalf("compose failed: %v", err)
+	}
+
+	if draft != "# Draft" {
+		t.Errorf("draft = %q, want %q", draft, "# Draft")
+	}
+	if len(prompts) != 1 {
+		t.Fatalf("requests = %d, want 1", len(prompts))
+	}
+	for _, want := range []string{"Write an essay", "transcripts of talk.json", "Additional instructions: for beginners", "SPEAKER_2: Yes."} {
+		if !strings.Contains(prompts[0], want) {
+			t.Errorf("prompt is missing %q:\n%v", want, prompts[0])
+		}
+	}
+}
+
+func TestComposeMapReduce(t *testing.T) {
+
+	var mu sync.Mutex
+	var notes, final []string
+	server := newChatStub(t, func(prompt string) (string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(prompt, "Write an article outline") {
+			final = append(final, prompt)
+			return "# Outline", "stop"
+		}
+		notes = append(notes, prompt)
+		return "- a note", "stop"
+	})
+
+	// Each paragraph is about 40 tokens, so the chunks of 50 tokens hold one
+	// paragraph each.
+	var paragraphs []string
+	for i := 0; i < 6; i++ {
+		paragraphs = append(paragraphs, fmt.Sprintf("Paragraph %d. %v", i+1, strings.Repeat("word ", 30)))
+	}
+
+	composer := Composer{
+		Provider: newTestProvider(t, server),
+		Template: templates[TemplateOutline],
+		Options:  Options{ChunkTokens: 50, Workers: 2},
+	}
+	draft, err := composer.Compose(context.Background(), []Source{
+		{Name: "a.json", Text: strings.Join(paragraphs[:3], "\n\n")},
+		{Name: "b.json", Text: strings.Join(paragraphs[3:], "\n\n")},
+	}, "")
+	if err != nil {
+		t.Fatalf("compose failed: %v", err)
+	}
+
+	if draft != "# Outline" {
+		t.Errorf("draft = %q, want %q", draft, "# Outline")
+	}
+	if len(notes) != 6 {
+		t.Errorf("notes requests = %d, want 6", len(notes))
+	}
+	for _, prompt := range notes {
+		if !strings.Contains(prompt, "of 6 of the transcripts of a.json, b.json") {
+			t.Errorf("notes prompt is missing the part count:\n%v", prompt)
+		}
+	}
+	if len(final) != 1 {
+		t.Fatalf("final requests = %d, want 1", len(final))
+	}
+	if !strings.Contains(final[0], "notes which were taken from the transcripts") || strings.Count(final[0], "- a note") != 6 {
+		t.Errorf("final prompt does not hold the notes:\n%v", final[0])
+	}
+}
@@ -0,0 +1,99 @@
+package compose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spiritorai/spiritor/apiclient"
+)
+
+const (
+	openAIBaseURL = "https://api.openai.com/v1"
+	openAIModel   = "gpt-4o-mini"
+	openAIChatEP  = "/chat/completions"
+)
+
+// OpenAI is the provider for the OpenAI chat completions api, and for any server
+// which implements the same api, eg: ollama, llama.cpp or vllm. The zero value is
+// not usable, use NewOpenAI to initialize it with defaults.
+type OpenAI struct {
+	apiclient.Client
+
+	Model string // model name, eg: gpt-4o-mini
+}
+
+// NewOpenAI will initialize a new OpenAI provider from the config and fill in
+// the defaults for any empty values. The api key is only required by the openai
+// api itself, self-hosted servers usually run without one.
+func NewOpenAI(config ProviderConfig) (*OpenAI, error) {
+
+	provider := &OpenAI{
+		Client: apiclient.Client{
+			BaseURL:    strings.TrimRight(config.BaseURL, "/"),
+			APIKey:     config.APIKey,
+			Timeout:    config.Timeout,
+			Retry:      apiclient.RetryPolicy{MaxRetries: config.MaxRetries},
+			Limiter:    apiclient.NewLimiter(config.RequestsPerMinute),
+			HTTPClient: &http.Client{},
+		},
+		Model: config.Model,
+	}
+
+	if provider.BaseURL == "" {
+		provider.BaseURL = openAIBaseURL
+	}
+
+	if provider.APIKey == "" && provider.BaseURL == openAIBaseURL {
+		return nil, fmt.Errorf("missing api key")
+	}
+
+	if provider.Model == "" {
+		provider.Model = openAIModel
+	}
+
+	return provider, nil
+}
+
+type chatRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// Complete sends the messages to the chat completions endpoint, retrying rate
+// limit, server and network errors with the same policy as the transcription
+// engines.
+func (o *OpenAI) Complete(ctx context.Context, messages []Message) (string, error) {
+
+	data, err := o.PostJSON(ctx, openAIChatEP, chatRequest{Model: o.Model, Messages: messages})
+	if err != nil {
+		return "", err
+	}
+
+	var chat chatResponse
+	if err := json.Unmarshal(data, &chat); err != nil {
+		return "", fmt.Errorf("failed to unmarshal json resp: %v", err)
+	}
+
+	if len(chat.Choices) == 0 {
+		return "", fmt.Errorf("chat response has no choices")
+	}
+
+	// A reply which was cut off by the max output tokens of the model would
+	// silently truncate the draft, so it is an error rather than a partial result.
+	choice := chat.Choices[0]
+	if choice.FinishReason == "length" {
+		return "", fmt.Errorf("reply was cut off at the max output tokens of model %v", o.Model)
+	}
+
+	return strings.TrimSpace(choice.Message.Content), nil
+}
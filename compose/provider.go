@@ -0,0 +1,81 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// Message is a single chat message, the role is one of system, user or assistant.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Provider is implemented by every chat completion provider. Implementations must
+// be safe for concurrent use since a single instance is shared by all of the
+// requests of a draft.
+type Provider interface {
+	// Complete returns the reply of the model to the messages.
+	Complete(ctx context.Context, messages []Message) (string, error)
+}
+
+// ProviderConfig holds the common settings which are passed to a provider
+// factory. Each provider is responsible for applying its own defaults to empty
+// values.
+type ProviderConfig struct {
+	BaseURL string        // api base url, eg: https://api.openai.com/v1
+	APIKey  string        // api key sent as a bearer token, if required
+	Model   string        // model name, eg: gpt-4o-mini
+	Timeout time.Duration // max time for a single request, 0 for none
+
+	MaxRetries        int // max retries of a failed request, 0 for none
+	RequestsPerMinute int // max requests started per minute, 0 for no limit
+}
+
+// ProviderFactory builds a new provider from the provider config.
+type ProviderFactory func(config ProviderConfig) (Provider, error)
+
+const (
+	ProviderOpenAI = "openai"
+)
+
+var providers = utils.NewRegistry(map[string]ProviderFactory{
+	ProviderOpenAI: func(config ProviderConfig) (Provider, error) {
+		return NewOpenAI(config)
+	},
+})
+
+// RegisterProvider makes a chat completion provider available by name, eg: one
+// with a different api than openai. Registering an existing name replaces the
+// previous factory.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providers.Register(name, factory)
+}
+
+func ProviderAllowed(provider string) bool {
+	_, ok := providers.Get(provider)
+	return ok
+}
+
+// Providers returns the sorted names of all registered chat providers.
+func Providers() []string {
+	return providers.Names()
+}
+
+// NewProvider builds the named provider from the config.
+func NewProvider(provider string, config ProviderConfig) (Provider, error) {
+	factory, ok := providers.Get(provider)
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %v", provider)
+	}
+	return factory(config)
+}
@@ -0,0 +1,125 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Template is the prompt of a kind of draft. The prompt is a text/template which
+// is given TemplateData and must include the .Material, since it is the only
+// part of the request which holds the transcripts.
+type Template struct {
+	Name   string // template name, eg: essay
+	Prompt string // text/template of the final request
+}
+
+// TemplateData is passed to the prompt of a template.
+type TemplateData struct {
+	Material     string   // transcripts, or the notes taken from them for long material
+	Notes        bool     // the material is notes rather than the transcripts themselves
+	Instructions string   // extra instructions from the user, eg: the audience
+	Sources      []string // names of the transcripts, eg: interview.mp3.json
+}
+
+const (
+	TemplateEssay   = "essay"
+	TemplateScript  = "script"
+	TemplateOutline = "outline"
+)
+
+// The shared parts of the built in prompts, the material always comes last so
+// that the instructions are not lost at the end of a long transcript.
+const (
+	materialIntro = `{{if .Notes}}notes which were taken from the transcripts of {{join .Sources ", "}}{{else}}transcripts of {{join .Sources ", "}}{{end}}`
+	promptFooter  = `{{if .Instructions}}
+
+Additional instructions: {{.Instructions}}{{end}}
+
+{{.Material}}`
+)
+
+var templates = map[string]Template{
+	TemplateEssay: {
+		Name: TemplateEssay,
+		Prompt: `Write an essay from the following ` + materialIntro + `.
+
+Give it a title, an introduction which sets up the main idea, a body which is organized by theme rather than by the order in which things were said, and a conclusion. Keep the voice, the arguments and the examples of the speakers, and drop the filler, the repetition and the asides which do not serve the essay. Write in markdown.` + promptFooter,
+	},
+	TemplateScript: {
+		Name: TemplateScript,
+		Prompt: `Write a video script from the following ` + materialIntro + `.
+
+Open with a hook in the first two sentences, then work through the main points in a clear order and close with a short summary and a call to action. Write the narration to be spoken aloud, with short sentences in the voice of the speakers, and put the visual cues and b-roll suggestions in square brackets on their own lines. Use markdown headings for the sections.` + promptFooter,
+	},
+	TemplateOutline: {
+		Name: TemplateOutline,
+		Prompt: `Write an article outline from the following ` + materialIntro + `.
+
+Start with a working title and a one sentence thesis. Then list the sections as markdown headings in the order which best builds the argument, each with bullet points of its key points and the quotes or examples from the material which support them. End with a list of the open questions or gaps which the article would need to fill.` + promptFooter,
+	},
+}
+
+// Templates returns the sorted names of the built in templates.
+func Templates() []string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadTemplate returns the built in template of the name, or else reads the
+// prompt from the file at that path. Custom prompts have the same data as the
+// built in ones, and a prompt without the {{.Material}} gets it appended.
+func LoadTemplate(name string) (Template, error) {
+
+	if tmpl, ok := templates[name]; ok {
+		return tmpl, nil
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return Template{}, fmt.Errorf("unknown template %v: use one of: %v, or a prompt file: %v", name, strings.Join(Templates(), ", "), err)
+	}
+
+	prompt := strings.TrimSpace(string(data))
+	if !strings.Contains(prompt, ".Material") {
+		prompt += "\n\n{{.Material}}"
+	}
+
+	tmpl := Template{Name: filepath.Base(name), Prompt: prompt}
+	if _, err := tmpl.parse(); err != nil {
+		return Template{}, err
+	}
+
+	return tmpl, nil
+}
+
+func (t Template) parse() (*template.Template, error) {
+	parsed, err := template.New(t.Name).Funcs(template.FuncMap{"join": strings.Join}).Parse(t.Prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %v: %w", t.Name, err)
+	}
+	return parsed, nil
+}
+
+// Render executes the prompt with the data.
+func (t Template) Render(data TemplateData) (string, error) {
+
+	parsed, err := t.parse()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := parsed.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render template %v: %w", t.Name, err)
+	}
+
+	return b.String(), nil
+}
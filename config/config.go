@@ -0,0 +1,576 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config values are layered with the following precedence, from lowest to
+// highest. Each layer only overrides the values which it explicitly sets.
+//
+//  1. Defaults
+//  2. System config files in $XDG_CONFIG_DIRS (eg: /etc/xdg/spiritor/config.json)
+//  3. User config file in $XDG_CONFIG_HOME (eg: ~/.config/spiritor/config.json)
+//  4. Env vars (eg: SPIRITOR_OPENAI_API_KEY)
+//  5. CLI flags, which are applied by the individual commands
+//
+// All leaf fields must have a json tag and omitempty so that partial config
+// files can be layered on top of each other. The json tags also define the keys used
+// by Get, Set and the env var names.
+
+const (
+	appName    = "spiritor"
+	fileName   = "config.json"
+	envPrefix  = "SPIRITOR_"
+	envPathVar = "SPIRITOR_CONFIG"
+)
+
+// envAliases maps legacy env vars onto config keys. These are applied before the
+// prefixed env vars, so the prefixed form wins if both are set.
+var envAliases = map[string]string{
+	"API_KEY_OPENAI": "openai.api_key",
+}
+
+type Config struct {
+	OpenAI     OpenAI     `json:"openai"`
+	Local      Local      `json:"local"`
+	Scribe     Scribe     `json:"scribe"`
+	Subtitles  Subtitles  `json:"subtitles"`
+	Paragraphs Paragraphs `json:"paragraphs"`
+	Document   Document   `json:"document"`
+	Diarize    Diarize    `json:"diarize"`
+	Clean      Clean      `json:"clean"`
+	Compose    Compose    `json:"compose"`
+	Speak      Speak      `json:"speak"`
+	Watch      Watch      `json:"watch"`
+	Serve      Serve      `json:"serve"`
+	Timeouts   Timeouts   `json:"timeouts"`
+}
+
+type OpenAI struct {
+	APIKey  string `json:"api_key,omitempty"`  // api key for all openai requests
+	BaseURL string `json:"base_url,omitempty"` // api base url, eg: https://api.openai.com/v1
+	Model   string `json:"model,omitempty"`    // transcription model, eg: whisper-1
+
+	MaxRetries        int `json:"max_retries,omitempty"`         // retries for rate limit, server and network errors
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"` // max requests per minute, 0 for no limit
+}
+
+// Local is a self-hosted server which implements the OpenAI transcriptions api,
+// used by the local engine.
+type Local struct {
+	BaseURL string `json:"base_url,omitempty"` // api base url, eg: http://localhost:8000/v1
+	Model   string `json:"model,omitempty"`    // model name as known to the server, eg: Systran/faster-whisper-small
+	APIKey  string `json:"api_key,omitempty"`  // optional api key, if the server requires one
+}
+
+type Scribe struct {
+	Engine               string   `json:"engine,omitempty"`                // transcription engine name
+	Language             string   `json:"language,omitempty"`              // ISO-639-1 language code, or auto to detect it
+	Prompt               string   `json:"prompt,omitempty"`                // optional text to guide the transcription style or vocabulary
+	AudioStream          string   `json:"audio_stream,omitempty"`          // audio stream index or language code for files with several audio tracks
+	Outputs              []string `json:"outputs,omitempty"`               // default output formats
+	DownsampleWorkers    int      `json:"downsample_workers,omitempty"`    // downsample worker pool size
+	TranscriptionWorkers int      `json:"transcription_workers,omitempty"` // transcription worker pool size
+	StateDir             string   `json:"state_dir,omitempty"`             // job state and artifacts dir, relative to the working dir
+	CacheDir             string   `json:"cache_dir,omitempty"`             // transcript cache dir, defaults to $XDG_CACHE_HOME/spiritor/transcripts
+}
+
+type Subtitles struct {
+	MaxLineLength  int     `json:"max_line_length,omitempty"`  // max characters per line
+	MaxLines       int     `json:"max_lines,omitempty"`        // max lines per cue
+	MaxCueDuration float64 `json:"max_cue_duration,omitempty"` // max seconds per cue
+}
+
+// Paragraphs are the paragraph breaks of the text outputs.
+type Paragraphs struct {
+	MaxSentences int     `json:"max_sentences,omitempty"` // max sentences per paragraph, 1 for one sentence per paragraph
+	PauseGap     float64 `json:"pause_gap,omitempty"`     // min seconds of pause which starts a new paragraph
+}
+
+// Document is the layout of the md and html outputs.
+type Document struct {
+	HeadingMinutes float64 `json:"heading_minutes,omitempty"` // timestamp headings every n minutes, 0 for none
+}
+
+type Diarize struct {
+	Enabled     bool   `json:"enabled,omitempty"`      // label the speakers of every transcript
+	Engine      string `json:"engine,omitempty"`       // diarization engine name
+	Speakers    int    `json:"speakers,omitempty"`     // expected number of speakers, 0 to estimate it
+	MaxSpeakers int    `json:"max_speakers,omitempty"` // upper bound when estimating the number of speakers
+	Names       string `json:"names,omitempty"`        // speaker mapping file, eg: {"SPEAKER_1": "Alice"}
+}
+
+// Clean is the cleanup of the clean read output, the repeats and false starts
+// are removed unless kept.
+type Clean struct {
+	Enabled         bool     `json:"enabled,omitempty"`           // write a clean.txt output along with the removed spans in the json output
+	Fillers         []string `json:"fillers,omitempty"`           // filler words and phrases to remove, eg: um, you know
+	KeepRepeats     bool     `json:"keep_repeats,omitempty"`      // keep repeated words and phrases, eg: "the the"
+	KeepFalseStarts bool     `json:"keep_false_starts,omitempty"` // keep words which were cut off, eg: "wh- what"
+}
+
+// Compose is the chat completion provider which drafts from transcripts. An
+// empty base url or api key falls back to the openai section.
+type Compose struct {
+	Provider    string `json:"provider,omitempty"`     // chat completion provider name
+	BaseURL     string `json:"base_url,omitempty"`     // api base url, eg: http://localhost:11434/v1
+	APIKey      string `json:"api_key,omitempty"`      // api key, if different from the openai one
+	Model       string `json:"model,omitempty"`        // chat model, eg: gpt-4o-mini
+	Template    string `json:"template,omitempty"`     // default template name or prompt file
+	ChunkTokens int    `json:"chunk_tokens,omitempty"` // max estimated tokens of transcript per request
+	Workers     int    `json:"workers,omitempty"`      // max concurrent requests
+}
+
+// Speak is the speech synthesis provider which reads texts out loud. An empty
+// base url or api key falls back to the openai section.
+type Speak struct {
+	Provider string  `json:"provider,omitempty"`  // speech synthesis provider name
+	BaseURL  string  `json:"base_url,omitempty"`  // api base url, eg: http://localhost:8880/v1
+	APIKey   string  `json:"api_key,omitempty"`   // api key, if different from the openai one
+	Model    string  `json:"model,omitempty"`     // speech model, eg: tts-1
+	Voice    string  `json:"voice,omitempty"`     // voice name, eg: alloy
+	Speed    float64 `json:"speed,omitempty"`     // speaking speed from 0.25 to 4, 0 for the provider default
+	MaxChars int     `json:"max_chars,omitempty"` // max characters of text per request
+	Workers  int     `json:"workers,omitempty"`   // max concurrent requests
+}
+
+// Watch is the detection of new files in the dirs watched by the watch command.
+// Times are in seconds.
+type Watch struct {
+	Recursive    bool    `json:"recursive,omitempty"`     // also watch the sub dirs
+	Poll         bool    `json:"poll,omitempty"`          // always poll instead of using filesystem notifications
+	PollInterval float64 `json:"poll_interval,omitempty"` // time between scans when polling
+	Settle       float64 `json:"settle,omitempty"`        // time a file must stay unchanged before it is transcribed
+}
+
+// Serve is the http api of the serve command.
+type Serve struct {
+	Addr        string   `json:"addr,omitempty"`          // listen address, eg: 127.0.0.1:8080, or :8080 for all interfaces
+	Token       string   `json:"token,omitempty"`         // optional bearer token which every request must send
+	UploadDir   string   `json:"upload_dir,omitempty"`    // uploaded files and their outputs, defaults to uploads within the state dir
+	AllowedDirs []string `json:"allowed_dirs,omitempty"`  // dirs of the server-local files which may be transcribed, none by default
+	QueueSize   int      `json:"queue_size,omitempty"`    // max jobs waiting for a worker before new jobs are refused
+	MaxUploadMB int      `json:"max_upload_mb,omitempty"` // max size of an uploaded file, at least 1
+	MaxJobs     int      `json:"max_jobs,omitempty"`      // finished jobs which are kept, the oldest are removed first, 0 for no limit
+	JobTTL      float64  `json:"job_ttl,omitempty"`       // seconds that finished jobs are kept, 0 for no limit
+}
+
+// Timeouts are in seconds, 0 disables the timeout.
+type Timeouts struct {
+	Downsample float64 `json:"downsample,omitempty"` // max time to downsample or split a single file
+	Transcribe float64 `json:"transcribe,omitempty"` // max time for a single transcription request
+	Diarize    float64 `json:"diarize,omitempty"`    // max time to decode a file for diarization
+	Render     float64 `json:"render,omitempty"`     // max time to render an edited file
+	Compose    float64 `json:"compose,omitempty"`    // max time for a single chat completion request
+	Speak      float64 `json:"speak,omitempty"`      // max time for a single speech synthesis request
+}
+
+// Defaults returns the base layer of the config.
+func Defaults() Config {
+	return Config{
+		OpenAI: OpenAI{
+			BaseURL: "https://api.openai.com/v1",
+			Model:   "whisper-1",
+
+			MaxRetries: 5,
+		},
+		Local: Local{
+			BaseURL: "http://localhost:8000/v1",
+			Model:   "whisper-1",
+		},
+		Scribe: Scribe{
+			Engine:               "openai",
+			Language:             "en",
+			Outputs:              []string{"txt"},
+			DownsampleWorkers:    4,
+			TranscriptionWorkers: 6,
+			StateDir:             ".spiritor",
+		},
+		Subtitles: Subtitles{
+			MaxLineLength:  42,
+			MaxLines:       2,
+			MaxCueDuration: 7,
+		},
+		Paragraphs: Paragraphs{
+			MaxSentences: 5,
+			PauseGap:     2,
+		},
+		Diarize: Diarize{
+			Engine:      "energy",
+			MaxSpeakers: 4,
+		},
+		Clean: Clean{
+			Fillers: []string{"um", "umm", "uh", "uhh", "uhm", "er", "erm", "ah", "hmm", "mm", "mhm", "you know", "I mean"},
+		},
+		Compose: Compose{
+			Provider:    "openai",
+			Model:       "gpt-4o-mini",
+			Template:    "essay",
+			ChunkTokens: 8000,
+			Workers:     4,
+		},
+		Speak: Speak{
+			Provider: "openai",
+			Model:    "tts-1",
+			Voice:    "alloy",
+			MaxChars: 4096,
+			Workers:  4,
+		},
+		Watch: Watch{
+			PollInterval: 10,
+			Settle:       5,
+		},
+		Serve: Serve{
+			Addr:        "127.0.0.1:8080",
+			QueueSize:   100,
+			MaxUploadMB: 2048,
+			MaxJobs:     1000,
+			JobTTL:      7 * 24 * 3600,
+		},
+		Timeouts: Timeouts{
+			Downsample: 3600,
+			Transcribe: 900,
+			Diarize:    1800,
+			Render:     3600,
+			Compose:    600,
+			Speak:      300,
+		},
+	}
+}
+
+// Path returns the user config file path. If the SPIRITOR_CONFIG env var is set
+// then it is used as is, otherwise the path is based on $XDG_CONFIG_HOME, with
+// the os specific user config dir as the fallback.
+func Path() (string, error) {
+	if path := os.Getenv(envPathVar); path != "" {
+		return path, nil
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		userDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find user config dir: %w", err)
+		}
+		dir = userDir
+	}
+
+	return filepath.Join(dir, appName, fileName), nil
+}
+
+// systemPaths returns the system config file paths from $XDG_CONFIG_DIRS ordered
+// from lowest to highest precedence.
+func systemPaths() []string {
+	dirs := os.Getenv("XDG_CONFIG_DIRS")
+	if dirs == "" {
+		dirs = "/etc/xdg"
+	}
+
+	var paths []string
+	list := filepath.SplitList(dirs)
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i] == "" {
+			continue
+		}
+		paths = append(paths, filepath.Join(list[i], appName, fileName))
+	}
+	return paths
+}
+
+// Load builds the config from the defaults, config files and env vars. If path
+// is empty then the user config path is used. Missing config files are not an
+// error, but unreadable or invalid files are.
+func Load(path string) (Config, error) {
+
+	conf := Defaults()
+
+	if path == "" {
+		userPath, err := Path()
+		if err != nil {
+			return conf, err
+		}
+		path = userPath
+	}
+
+	for _, p := range append(systemPaths(), path) {
+		if err := overlayFile(&conf, p); err != nil {
+			return conf, err
+		}
+	}
+
+	if err := overlayEnv(&conf); err != nil {
+		return conf, err
+	}
+
+	return conf, nil
+}
+
+// SetFile sets a single key in the config file at path, creating the file and
+// its parent dirs as needed. Only that key is changed and the rest of the file is
+// kept as is. The value is written even if it is the zero value, eg: 0 to turn
+// off the retries, which would otherwise be dropped by omitempty.
+func SetFile(path, key, value string) error {
+
+	var conf Config
+	if err := conf.Set(key, value); err != nil {
+		return err
+	}
+	field, err := lookup(reflect.ValueOf(&conf).Elem(), key)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]any{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read config file %v: %w", path, err)
+	}
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("failed to parse config file %v: %w", path, err)
+		}
+	}
+
+	setPath(raw, key, field.Interface())
+
+	return writeFile(path, raw)
+}
+
+// writeFile writes the config file, creating the parent dirs as needed. The file
+// may contain secrets so it is only readable by the owner.
+func writeFile(path string, raw map[string]any) error {
+
+	data, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config dir: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return nil
+}
+
+func overlayFile(conf *Config, path string) error {
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file %v: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, conf); err != nil {
+		return fmt.Errorf("failed to parse config file %v: %w", path, err)
+	}
+
+	return nil
+}
+
+func overlayEnv(conf *Config) error {
+
+	for env, key := range envAliases {
+		if value, ok := os.LookupEnv(env); ok && value != "" {
+			if err := conf.Set(key, value); err != nil {
+				return fmt.Errorf("invalid env var %v: %w", env, err)
+			}
+		}
+	}
+
+	for _, key := range Keys() {
+		env := EnvName(key)
+		if value, ok := os.LookupEnv(env); ok && value != "" {
+			if err := conf.Set(key, value); err != nil {
+				return fmt.Errorf("invalid env var %v: %w", env, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnvName returns the env var which overrides the config key, eg: the key
+// openai.api_key is overridden by SPIRITOR_OPENAI_API_KEY.
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Keys returns the sorted list of all config keys, eg: openai.api_key
+func Keys() []string {
+	var keys []string
+	walk(reflect.ValueOf(&Config{}).Elem(), "", func(key string, _ reflect.Value) {
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the string representation of the config value for key. Slices are
+// joined with commas.
+func (conf Config) Get(key string) (string, error) {
+	field, err := lookup(reflect.ValueOf(&conf).Elem(), key)
+	if err != nil {
+		return "", err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		return field.String(), nil
+	case reflect.Int:
+		return strconv.FormatInt(field.Int(), 10), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'f', -1, 64), nil
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool()), nil
+	case reflect.Slice:
+		return strings.Join(field.Interface().([]string), ","), nil
+	default:
+		return "", fmt.Errorf("unsupported config type for %v: %v", key, field.Kind())
+	}
+}
+
+// Set parses value and assigns it to the config key. Slices are parsed from
+// comma separated values.
+func (conf *Config) Set(key, value string) error {
+	field, err := lookup(reflect.ValueOf(conf).Elem(), key)
+	if err != nil {
+		return err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %v: %v: must be an integer", key, value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for %v: %v: must be a number", key, value)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %v: %v: must be true or false", key, value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		list := []string{} // an empty list is kept as such rather than as unset
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported config type for %v: %v", key, field.Kind())
+	}
+
+	return nil
+}
+
+// Map returns the config as nested maps by json key. Unlike the json encoding it
+// includes the zero values, eg: to show that a value is set to 0.
+func (conf Config) Map() map[string]any {
+	root := map[string]any{}
+	walk(reflect.ValueOf(&conf).Elem(), "", func(key string, field reflect.Value) {
+		setPath(root, key, field.Interface())
+	})
+	return root
+}
+
+// Redacted returns a copy of the config with secrets masked so that it is safe
+// to print.
+func (conf Config) Redacted() Config {
+	conf.OpenAI.APIKey = redact(conf.OpenAI.APIKey)
+	conf.Local.APIKey = redact(conf.Local.APIKey)
+	conf.Compose.APIKey = redact(conf.Compose.APIKey)
+	conf.Speak.APIKey = redact(conf.Speak.APIKey)
+	conf.Serve.Token = redact(conf.Serve.Token)
+	return conf
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return "********"
+	}
+	return secret[:3] + "..." + secret[len(secret)-4:]
+}
+
+// jsonName returns the json key of a struct field without any tag options.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// walk calls fn for every leaf field of the struct value with its dotted key.
+func walk(v reflect.Value, prefix string, fn func(key string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if v.Field(i).Kind() == reflect.Struct {
+			walk(v.Field(i), name, fn)
+			continue
+		}
+		fn(name, v.Field(i))
+	}
+}
+
+// setPath assigns the value to the dotted key in the nested maps, creating the
+// maps of the sections as needed.
+func setPath(root map[string]any, key string, value any) {
+	section := root
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := section[part].(map[string]any)
+		if !ok {
+			child = map[string]any{}
+			section[part] = child
+		}
+		section = child
+	}
+	section[parts[len(parts)-1]] = value
+}
+
+func lookup(v reflect.Value, key string) (reflect.Value, error) {
+	var found reflect.Value
+	walk(v, "", func(k string, field reflect.Value) {
+		if k == key {
+			found = field
+		}
+	})
+	if !found.IsValid() {
+		return found, fmt.Errorf("unknown config key: %v", key)
+	}
+	return found, nil
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSetFileRoundTrip(t *testing.T) {
+
+	// Only the test file is layered on top of the defaults.
+	t.Setenv("XDG_CONFIG_DIRS", t.TempDir())
+	for _, key := range Keys() {
+		t.Setenv(EnvName(key), "")
+	}
+
+	path := filepath.Join(t.TempDir(), "spiritor", "config.json")
+
+	// Zero values are saved rather than dropped by omitempty, so that they
+	// override the non-zero defaults.
+	values := map[string]string{
+		"openai.max_retries":  "0",
+		"timeouts.transcribe": "0",
+		"diarize.enabled":     "false",
+		"clean.fillers":       "",
+		"scribe.outputs":      "txt, srt",
+		"openai.model":        "whisper-test",
+	}
+	for key, value := range values {
+		if err := SetFile(path, key, value); err != nil {
+			t.Fatalf("SetFile(%v, %q) failed: %v", key, value, err)
+		}
+	}
+
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if conf.OpenAI.MaxRetries != 0 {
+		t.Errorf("openai.max_retries = %v, want 0", conf.OpenAI.MaxRetries)
+	}
+	if conf.Timeouts.Transcribe != 0 {
+		t.Errorf("timeouts.transcribe = %v, want 0", conf.Timeouts.Transcribe)
+	}
+	if conf.Clean.Fillers == nil || len(conf.Clean.Fillers) != 0 {
+		t.Errorf("clean.fillers = %#v, want an empty list", conf.Clean.Fillers)
+	}
+	if want := []string{"txt", "srt"}; !reflect.DeepEqual(conf.Scribe.Outputs, want) {
+		t.Errorf("scribe.outputs = %v, want %v", conf.Scribe.Outputs, want)
+	}
+	if conf.OpenAI.Model != "whisper-test" {
+		t.Errorf("openai.model = %v, want whisper-test", conf.OpenAI.Model)
+	}
+
+	// The values which were not set keep their defaults.
+	defaults := Defaults()
+	if conf.Timeouts.Downsample != defaults.Timeouts.Downsample || conf.OpenAI.BaseURL != defaults.OpenAI.BaseURL {
+		t.Errorf("unset values changed: timeouts.downsample = %v, openai.base_url = %v", conf.Timeouts.Downsample, conf.OpenAI.BaseURL)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("file mode = %v, want 0600", perm)
+	}
+}
+
+func TestSetFileKeepsOtherKeys(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	existing := `{"openai": {"api_key": "sk-test", "requests_per_minute": 50}, "extra": {"note": "kept"}}`
+	if err := os.WriteFile(path, []byte(existing), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetFile(path, "openai.max_retries", "0"); err != nil {
+		t.Fatalf("SetFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("invalid json written: %v: %s", err, data)
+	}
+
+	want := map[string]map[string]any{
+		"openai": {"api_key": "sk-test", "requests_per_minute": 50.0, "max_retries": 0.0},
+		"extra":  {"note": "kept"},
+	}
+	if !reflect.DeepEqual(raw, want) {
+		t.Errorf("file = %v, want %v", raw, want)
+	}
+}
+
+func TestSetFileInvalid(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := SetFile(path, "openai.unknown", "1"); err == nil {
+		t.Errorf("expected an error for an unknown key")
+	}
+	if err := SetFile(path, "openai.max_retries", "many"); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file was written for invalid values: %v", err)
+	}
+}
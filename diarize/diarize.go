@@ -0,0 +1,96 @@
+package diarize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/transcribe"
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// Diarizer is implemented by every diarization engine. Implementations must be
+// safe for concurrent use since a single instance is shared by all of the
+// diarization workers.
+type Diarizer interface {
+	// Diarize returns the speaker turns of the selected audio stream ordered by
+	// start time. Speakers are labeled SPEAKER_1, SPEAKER_2, etc in the order in
+	// which they first speak.
+	Diarize(ctx context.Context, media avmedia.Media) ([]transcribe.SpeakerTurn, error)
+}
+
+// Config holds the common settings which are passed to a diarizer factory.
+type Config struct {
+	Speakers    int           // expected number of speakers, 0 to estimate it
+	MaxSpeakers int           // upper bound when estimating the number of speakers
+	Timeout     time.Duration // max time to decode a file, 0 for none
+}
+
+// Factory builds a new diarizer from the config.
+type Factory func(config Config) (Diarizer, error)
+
+const (
+	EngineEnergy = "energy"
+)
+
+var engines = utils.NewRegistry(map[string]Factory{
+	EngineEnergy: func(config Config) (Diarizer, error) {
+		return NewEnergy(config), nil
+	},
+})
+
+// RegisterEngine makes a diarization engine available by name, eg: one backed by
+// a hosted api or a local neural model. Registering an existing name replaces the
+// previous factory.
+func RegisterEngine(name string, factory Factory) {
+	engines.Register(name, factory)
+}
+
+func EngineAllowed(engine string) bool {
+	_, ok := engines.Get(engine)
+	return ok
+}
+
+// Engines returns the sorted names of all registered engines.
+func Engines() []string {
+	return engines.Names()
+}
+
+// New builds the named engine from the config.
+func New(engine string, config Config) (Diarizer, error) {
+	factory, ok := engines.Get(engine)
+	if !ok {
+		return nil, fmt.Errorf("unsupported diarization engine: %v", engine)
+	}
+	return factory(config)
+}
+
+// SpeakerLabel returns the label of the nth speaker, starting from 0.
+func SpeakerLabel(n int) string {
+	return fmt.Sprintf("SPEAKER_%d", n+1)
+}
+
+// LoadSpeakerNames reads a speaker mapping file, which is a json object of the
+// speaker labels to names, eg: {"SPEAKER_1": "Alice", "SPEAKER_2": "Bob"}. A
+// missing file is not an error and returns no names.
+func LoadSpeakerNames(path string) (map[string]string, error) {
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read speaker names: %w", err)
+	}
+
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("failed to parse speaker names %v: %w", path, err)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,411 @@
+package diarize
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/ffmpeg"
+	"github.com/spiritorai/spiritor/logging"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+const (
+	energySampleRate = 8000 // speech is band limited so this keeps the decode small
+	frameSamples     = 200  // 25ms analysis frames
+	windowFrames     = 40   // 1s windows are the unit of speaker assignment
+
+	noisePercentile = 0.1   // frame energy percentile taken as the noise floor
+	voicedMargin    = 10.0  // min dB above the noise floor for a voiced frame
+	voicedMinEnergy = -55.0 // min dB for a voiced frame, so noise in digital silence is ignored
+	minVoicedRatio  = 0.5   // min ratio of voiced frames for a voiced window
+	bandFloor       = 1e-3  // min band power relative to the frame power, ie: 30dB of range
+
+	maxTurnGap         = 1.5 // max seconds of pause within a single turn
+	kmeansIterations   = 25  // max refinement passes of the clustering
+	minClusterGain     = 0.3 // min relative drop in error to accept another speaker when estimating
+	defaultMaxSpeakers = 4   // upper bound when estimating the number of speakers
+)
+
+// bandFreqs are the centers of the log spaced bands of the spectral features.
+var bandFreqs = []float64{150, 250, 400, 650, 1000, 1600, 2500, 3400}
+
+// featureSize is the band shape plus the zero crossing rate.
+var featureSize = len(bandFreqs) + 1
+
+// Energy is a local diarizer which needs no models or network access. It finds
+// voiced windows by their energy above the noise floor, describes each by the
+// shape of its spectrum, and clusters the windows into speakers. It is a stand-in
+// which works well for a few speakers with distinct voices on a clean recording,
+// and is easily confused by crosstalk, music or similar voices.
+type Energy struct {
+	Speakers    int           // expected number of speakers, 0 to estimate it
+	MaxSpeakers int           // upper bound when estimating the number of speakers
+	Timeout     time.Duration // max time to decode the audio, 0 for none
+}
+
+func NewEnergy(config Config) *Energy {
+	energy := &Energy{
+		Speakers:    config.Speakers,
+		MaxSpeakers: config.MaxSpeakers,
+		Timeout:     config.Timeout,
+	}
+	if energy.MaxSpeakers <= 0 {
+		energy.MaxSpeakers = defaultMaxSpeakers
+	}
+	return energy
+}
+
+func (e *Energy) Diarize(ctx context.Context, media avmedia.Media) ([]transcribe.SpeakerTurn, error) {
+
+	workDir, err := os.MkdirTemp("", "spiritor")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	decodeCtx := ctx
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		decodeCtx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	pcmPath := filepath.Join(workDir, "audio.pcm")
+	if err := ffmpeg.DecodePCM(decodeCtx, media.GetPath(), pcmPath, media.GetAudioStream(), energySampleRate); err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+
+	file, err := os.Open(pcmPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open decoded audio: %w", err)
+	}
+	defer file.Close()
+
+	frames, err := readFrames(ctx, bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+
+	windows := voicedWindows(frames)
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	normalize(windows)
+
+	k := e.Speakers
+	if k <= 0 {
+		k = estimateSpeakers(windows, e.MaxSpeakers)
+	}
+	labels, _ := kmeans(windows, k)
+	labels = smooth(windows, labels)
+
+	turns := buildTurns(windows, labels)
+
+	logging.FromContext(ctx).Debug("diarized", "file", media.GetName(), "windows", len(windows), "speakers", k, "turns", len(turns))
+
+	return turns, nil
+}
+
+// frame is the analysis of a single 25ms frame.
+type frame struct {
+	energy   float64   // dB
+	features []float64 // band shape and zero crossing rate
+}
+
+// readFrames analyzes the raw pcm samples frame by frame, a trailing partial
+// frame is dropped.
+func readFrames(ctx context.Context, r io.Reader) ([]frame, error) {
+
+	buf := make([]byte, frameSamples*2)
+	samples := make([]float64, frameSamples)
+
+	var frames []frame
+	for {
+		if len(frames)%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := io.ReadFull(r, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return frames, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read decoded audio: %w", err)
+		}
+
+		for i := range samples {
+			samples[i] = float64(int16(binary.LittleEndian.Uint16(buf[i*2:]))) / 32768
+		}
+
+		frames = append(frames, analyzeFrame(samples))
+	}
+}
+
+func analyzeFrame(samples []float64) frame {
+
+	var power float64
+	var crossings int
+	for i, s := range samples {
+		power += s * s
+		if i > 0 && (s >= 0) != (samples[i-1] >= 0) {
+			crossings++
+		}
+	}
+	power /= float64(len(samples))
+
+	// The band energies are relative to their mean so that the shape of the
+	// spectrum is compared rather than the loudness. They are floored relative to
+	// the frame power so that near empty bands, which are mostly noise, do not
+	// dominate the comparison.
+	features := make([]float64, featureSize)
+	var mean float64
+	for i, freq := range bandFreqs {
+		features[i] = math.Log10(goertzel(samples, freq, energySampleRate) + power*bandFloor + 1e-10)
+		mean += features[i]
+	}
+	mean /= float64(len(bandFreqs))
+	for i := range bandFreqs {
+		features[i] -= mean
+	}
+	features[len(bandFreqs)] = float64(crossings) / float64(len(samples))
+
+	return frame{
+		energy:   10 * math.Log10(power+1e-10),
+		features: features,
+	}
+}
+
+// goertzel returns the power of a single frequency in the samples.
+func goertzel(samples []float64, freq float64, sampleRate int) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/float64(sampleRate))
+	var s1, s2 float64
+	for _, x := range samples {
+		s0 := x + coeff*s1 - s2
+		s2 = s1
+		s1 = s0
+	}
+	return (s1*s1 + s2*s2 - coeff*s1*s2) / float64(len(samples))
+}
+
+// window is a voiced 1s window with the mean features of its voiced frames.
+type window struct {
+	index    int // position in the audio, so the start is index seconds
+	features []float64
+}
+
+func (w window) start() float64 {
+	return float64(w.index*windowFrames*frameSamples) / energySampleRate
+}
+
+func (w window) end() float64 {
+	return float64((w.index+1)*windowFrames*frameSamples) / energySampleRate
+}
+
+func voicedWindows(frames []frame) []window {
+
+	if len(frames) == 0 {
+		return nil
+	}
+
+	energies := make([]float64, len(frames))
+	for i, f := range frames {
+		energies[i] = f.energy
+	}
+	sort.Float64s(energies)
+	threshold := max(energies[int(float64(len(energies)-1)*noisePercentile)]+voicedMargin, voicedMinEnergy)
+
+	var windows []window
+	for start := 0; start < len(frames); start += windowFrames {
+		end := min(start+windowFrames, len(frames))
+
+		features := make([]float64, featureSize)
+		voiced := 0
+		for _, f := range frames[start:end] {
+			if f.energy < threshold {
+				continue
+			}
+			voiced++
+			for i, v := range f.features {
+				features[i] += v
+			}
+		}
+
+		if float64(voiced) < float64(windowFrames)*minVoicedRatio {
+			continue
+		}
+
+		for i := range features {
+			features[i] /= float64(voiced)
+		}
+		windows = append(windows, window{index: start / windowFrames, features: features})
+	}
+
+	return windows
+}
+
+// normalize scales every feature to zero mean and unit variance so that they
+// carry equal weight in the clustering.
+func normalize(windows []window) {
+	for i := 0; i < featureSize; i++ {
+		var mean, variance float64
+		for _, w := range windows {
+			mean += w.features[i]
+		}
+		mean /= float64(len(windows))
+		for _, w := range windows {
+			variance += (w.features[i] - mean) * (w.features[i] - mean)
+		}
+		std := math.Sqrt(variance / float64(len(windows)))
+		for _, w := range windows {
+			w.features[i] -= mean
+			if std > 0 {
+				w.features[i] /= std
+			}
+		}
+	}
+}
+
+// estimateSpeakers adds speakers for as long as another cluster still explains a
+// large part of the remaining error.
+func estimateSpeakers(windows []window, maxSpeakers int) int {
+	_, prev := kmeans(windows, 1)
+	for k := 2; k <= maxSpeakers; k++ {
+		_, sse := kmeans(windows, k)
+		if prev <= 0 || (prev-sse)/prev < minClusterGain {
+			return k - 1
+		}
+		prev = sse
+	}
+	return maxSpeakers
+}
+
+// kmeans clusters the windows into k groups and returns the label of each
+// window along with the sum of squared errors. The centroids are seeded with the
+// farthest first traversal so that the result is deterministic.
+func kmeans(windows []window, k int) ([]int, float64) {
+
+	k = max(min(k, len(windows)), 1)
+
+	centroids := [][]float64{append([]float64(nil), windows[0].features...)}
+	for len(centroids) < k {
+		best, bestDist := 0, -1.0
+		for i, w := range windows {
+			if d := nearest(centroids, w.features); d.dist > bestDist {
+				best, bestDist = i, d.dist
+			}
+		}
+		centroids = append(centroids, append([]float64(nil), windows[best].features...))
+	}
+
+	labels := make([]int, len(windows))
+	var sse float64
+	for iter := 0; iter < kmeansIterations; iter++ {
+
+		changed := false
+		sse = 0
+		for i, w := range windows {
+			d := nearest(centroids, w.features)
+			if d.index != labels[i] {
+				labels[i] = d.index
+				changed = true
+			}
+			sse += d.dist
+		}
+		if !changed && iter > 0 {
+			break
+		}
+
+		counts := make([]int, k)
+		sums := make([][]float64, k)
+		for c := range sums {
+			sums[c] = make([]float64, featureSize)
+		}
+		for i, w := range windows {
+			counts[labels[i]]++
+			for j, v := range w.features {
+				sums[labels[i]][j] += v
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range centroids[c] {
+				centroids[c][j] = sums[c][j] / float64(counts[c])
+			}
+		}
+	}
+
+	return labels, sse
+}
+
+type distance struct {
+	index int
+	dist  float64 // squared euclidean distance
+}
+
+func nearest(centroids [][]float64, features []float64) distance {
+	best := distance{index: -1}
+	for c, centroid := range centroids {
+		var dist float64
+		for j, v := range features {
+			dist += (v - centroid[j]) * (v - centroid[j])
+		}
+		if best.index < 0 || dist < best.dist {
+			best = distance{index: c, dist: dist}
+		}
+	}
+	return best
+}
+
+// smooth replaces isolated labels with the label of both neighbors, since a
+// single window is too short for a real speaker change.
+func smooth(windows []window, labels []int) []int {
+	smoothed := append([]int(nil), labels...)
+	for i := 1; i < len(windows)-1; i++ {
+		adjacent := windows[i-1].index == windows[i].index-1 && windows[i+1].index == windows[i].index+1
+		if adjacent && labels[i-1] == labels[i+1] && labels[i] != labels[i-1] {
+			smoothed[i] = labels[i-1]
+		}
+	}
+	return smoothed
+}
+
+// buildTurns merges consecutive windows of the same speaker into turns and
+// labels the speakers in the order in which they first speak.
+func buildTurns(windows []window, labels []int) []transcribe.SpeakerTurn {
+
+	names := map[int]string{}
+	var turns []transcribe.SpeakerTurn
+	for i, w := range windows {
+
+		name, ok := names[labels[i]]
+		if !ok {
+			name = SpeakerLabel(len(names))
+			names[labels[i]] = name
+		}
+
+		if n := len(turns); n > 0 && turns[n-1].Speaker == name && w.start()-turns[n-1].End <= maxTurnGap {
+			turns[n-1].End = w.end()
+			continue
+		}
+
+		turns = append(turns, transcribe.SpeakerTurn{
+			Start:   w.start(),
+			End:     w.end(),
+			Speaker: name,
+		})
+	}
+
+	return turns
+}
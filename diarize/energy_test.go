@@ -0,0 +1,93 @@
+package diarize
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+// testWindow is a window at the index whose features are all set to v.
+func testWindow(index int, v float64) window {
+	features := make([]float64, featureSize)
+	for i := range features {
+		features[i] = v
+	}
+	return window{index: index, features: features}
+}
+
+func TestKmeans(t *testing.T) {
+
+	windows := []window{
+		testWindow(0, 0), testWindow(1, 0.1), testWindow(2, 5), testWindow(3, 5.1), testWindow(4, 0.2),
+	}
+
+	labels, sse := kmeans(windows, 2)
+	if want := []int{0, 0, 1, 1, 0}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("labels = %v, want %v", labels, want)
+	}
+	if sse <= 0 || sse > 1 {
+		t.Errorf("sse = %v, want the small spread within the clusters", sse)
+	}
+
+	// More clusters than windows are capped at one window each.
+	labels, sse = kmeans(windows[:2], 5)
+	if want := []int{0, 1}; !reflect.DeepEqual(labels, want) || sse != 0 {
+		t.Errorf("labels = %v with sse %v, want %v with 0", labels, sse, want)
+	}
+
+	// A third speaker would not explain any of the remaining error.
+	voices := []window{testWindow(0, 0), testWindow(1, 5), testWindow(2, 0), testWindow(3, 5)}
+	if got := estimateSpeakers(voices, 4); got != 2 {
+		t.Errorf("estimateSpeakers() = %v, want 2", got)
+	}
+}
+
+func TestSmooth(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		indexes []int
+		labels  []int
+		want    []int
+	}{
+		{"isolated label", []int{0, 1, 2, 3}, []int{0, 1, 0, 0}, []int{0, 0, 0, 0}},
+		{"two windows are a real change", []int{0, 1, 2, 3}, []int{0, 1, 1, 0}, []int{0, 1, 1, 0}},
+		{"gap between the windows", []int{0, 1, 5, 6}, []int{0, 1, 0, 0}, []int{0, 1, 0, 0}},
+		{"first and last are kept", []int{0, 1, 2}, []int{1, 0, 0}, []int{1, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows := make([]window, len(tt.indexes))
+			for i, index := range tt.indexes {
+				windows[i] = testWindow(index, 0)
+			}
+			if got := smooth(windows, tt.labels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("smooth() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTurns(t *testing.T) {
+
+	windows := []window{
+		testWindow(0, 0), testWindow(1, 0), // speaker a
+		testWindow(2, 0), // speaker b
+		testWindow(4, 0), // speaker b after a short pause
+		testWindow(8, 0), // speaker b after a long pause
+		testWindow(9, 0), // speaker a
+	}
+	labels := []int{3, 3, 1, 1, 1, 3}
+
+	want := []transcribe.SpeakerTurn{
+		{Start: 0, End: 2, Speaker: "SPEAKER_1"},
+		{Start: 2, End: 5, Speaker: "SPEAKER_2"},
+		{Start: 8, End: 9, Speaker: "SPEAKER_2"},
+		{Start: 9, End: 10, Speaker: "SPEAKER_1"},
+	}
+	if got := buildTurns(windows, labels); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildTurns() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package edit
+
+// matchWords returns which of the words of a are kept in b, where b is a copy of
+// a with words deleted, and possibly some inserted. It finds the longest common
+// subsequence with the linear space variant of the Myers diff algorithm, so that
+// long transcripts with many edits do not need a quadratic table.
+func matchWords(a, b []string) []bool {
+	m := matcher{a: a, b: b, kept: make([]bool, len(a))}
+	m.compare(0, len(a), 0, len(b))
+	return m.kept
+}
+
+type matcher struct {
+	a, b []string
+	kept []bool
+}
+
+// compare marks the common words of a[aLo:aHi] and b[bLo:bHi]. The common prefix
+// and suffix are matched directly and the rest is divided at the middle snake of
+// the shortest edit script, which halves the edits on each side.
+func (m *matcher) compare(aLo, aHi, bLo, bHi int) {
+
+	for aLo < aHi && bLo < bHi && m.a[aLo] == m.b[bLo] {
+		m.kept[aLo] = true
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && m.a[aHi-1] == m.b[bHi-1] {
+		m.kept[aHi-1] = true
+		aHi--
+		bHi--
+	}
+
+	// Once either side is empty the rest are all deletions or insertions.
+	if aLo == aHi || bLo == bHi {
+		return
+	}
+
+	x, y, u, v := m.middleSnake(aLo, aHi, bLo, bHi)
+	m.compare(aLo, x, bLo, y)
+	for i := x; i < u; i++ {
+		m.kept[i] = true
+	}
+	m.compare(u, aHi, v, bHi)
+}
+
+// middleSnake runs the forward and backward searches for the shortest edit script
+// at the same time until they overlap, and returns the start and end of the
+// diagonal where they meet.
+func (m *matcher) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+
+	n, mm := aHi-aLo, bHi-bLo
+	delta := n - mm
+	odd := delta%2 != 0
+	limit := (n + mm + 1) / 2
+
+	// The furthest x on each diagonal k, indexed by k + offset. The backward search
+	// measures x from the end of both sides.
+	offset := limit + 1
+	forward := make([]int, 2*limit+3)
+	backward := make([]int, 2*limit+3)
+
+	for d := 0; d <= limit; d++ {
+
+		for k := -d; k <= d; k += 2 {
+			var xs int
+			if k == -d || (k != d && forward[k-1+offset] < forward[k+1+offset]) {
+				xs = forward[k+1+offset]
+			} else {
+				xs = forward[k-1+offset] + 1
+			}
+			ys := xs - k
+			xe, ye := xs, ys
+			for xe < n && ye < mm && m.a[aLo+xe] == m.b[bLo+ye] {
+				xe++
+				ye++
+			}
+			forward[k+offset] = xe
+
+			// The backward diagonal which meets the forward diagonal k.
+			kr := delta - k
+			if odd && kr >= -(d-1) && kr <= d-1 && xe+backward[kr+offset] >= n {
+				return aLo + xs, bLo + ys, aLo + xe, bLo + ye
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var xs int
+			if k == -d || (k != d && backward[k-1+offset] < backward[k+1+offset]) {
+				xs = backward[k+1+offset]
+			} else {
+				xs = backward[k-1+offset] + 1
+			}
+			ys := xs - k
+			xe, ye := xs, ys
+			for xe < n && ye < mm && m.a[aHi-1-xe] == m.b[bHi-1-ye] {
+				xe++
+				ye++
+			}
+			backward[k+offset] = xe
+
+			kf := delta - k
+			if !odd && kf >= -d && kf <= d && xe+forward[kf+offset] >= n {
+				return aLo + n - xe, bLo + mm - ye, aLo + n - xs, bLo + mm - ys
+			}
+		}
+	}
+
+	// The searches always meet by the limit, this is only reached on a bug.
+	panic("edit: middle snake not found")
+}
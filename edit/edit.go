@@ -0,0 +1,159 @@
+// Package edit cuts media files by editing their transcripts. The words which are
+// deleted from a copy of the transcript text are located in the timed words of
+// the original transcript, and the time ranges of the remaining words are kept.
+package edit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+const (
+	DefaultPadding   = 80 * time.Millisecond // word timestamps are approximate so cuts are not made right at the edges
+	DefaultCrossfade = 50 * time.Millisecond // long enough to hide clicks at the cut points
+)
+
+// minRangeLength is the shortest range which is rendered, so that words without
+// a duration are not cut to nothing when there is no padding and no crossfade.
+const minRangeLength = 10 * time.Millisecond
+
+type Options struct {
+	Padding   time.Duration // time kept before and after each kept word, up to the neighboring deleted words
+	Crossfade time.Duration // length of the fade at each cut point
+}
+
+// Plan is the result of comparing the edited text with the transcript.
+type Plan struct {
+	Ranges  []avmedia.TimeRange // sections of the media to keep, in order
+	Kept    int                 // number of transcript words which were kept
+	Deleted int                 // number of transcript words which were deleted
+	Ignored int                 // number of edited words which are not in the transcript
+}
+
+// Duration is the length of the media after the cut, without the crossfades.
+func (p Plan) Duration() time.Duration {
+	var total time.Duration
+	for _, r := range p.Ranges {
+		total += r.End - r.Start
+	}
+	return total
+}
+
+// NewPlan compares the edited text with the timed words of the transcript and
+// returns the time ranges of the kept words. Words are compared ignoring case and
+// punctuation, so the text may be re-punctuated freely, but words added to the
+// text cannot be rendered and are ignored. The pauses between kept words are kept
+// as they are, as is any leading or trailing audio when the first or last words
+// are kept. The duration is the length of the source media.
+func NewPlan(words []transcribe.Word, edited string, duration time.Duration, opts Options) (Plan, error) {
+
+	var plan Plan
+
+	if len(words) == 0 {
+		return plan, fmt.Errorf("transcript has no word timestamps")
+	}
+
+	// Tokens which are only punctuation, eg: a dash, are not words in either text.
+	var source []string
+	var sourceWords []transcribe.Word
+	for _, word := range words {
+		if token := transcribe.NormalizeWord(word.Word); token != "" {
+			source = append(source, token)
+			sourceWords = append(sourceWords, word)
+		}
+	}
+
+	var target []string
+	for _, field := range strings.Fields(edited) {
+		if token := transcribe.NormalizeWord(field); token != "" {
+			target = append(target, token)
+		}
+	}
+
+	kept := matchWords(source, target)
+	for _, k := range kept {
+		if k {
+			plan.Kept++
+		}
+	}
+	plan.Deleted = len(source) - plan.Kept
+	plan.Ignored = len(target) - plan.Kept
+
+	if plan.Kept == 0 {
+		return plan, fmt.Errorf("edited text has no words of the transcript")
+	}
+
+	plan.Ranges = keptRanges(sourceWords, kept, duration.Seconds(), opts)
+
+	return plan, nil
+}
+
+// keptRanges merges each run of kept words into a range, padded into the gaps
+// around it but never over a deleted word.
+func keptRanges(words []transcribe.Word, kept []bool, duration float64, opts Options) []avmedia.TimeRange {
+
+	padding := opts.Padding.Seconds()
+
+	type span struct{ start, end float64 }
+	var spans []span
+	for i := 0; i < len(words); i++ {
+		if !kept[i] {
+			continue
+		}
+		first := i
+		for i+1 < len(words) && kept[i+1] {
+			i++
+		}
+		last := i
+
+		start := 0.0
+		if first > 0 {
+			start = min(max(words[first].Start-padding, words[first-1].End), words[first].Start)
+		}
+		end := words[last].End + padding
+		if last < len(words)-1 {
+			end = max(min(end, words[last+1].Start), words[last].End)
+		} else if duration > 0 {
+			end = duration
+		}
+		if duration > 0 {
+			end = min(end, duration)
+		}
+
+		spans = append(spans, span{start: max(start, 0), end: end})
+	}
+
+	// A range must be longer than the crossfades at both of its ends, and not
+	// empty without them, eg: a word with no duration, so short ones are widened
+	// around their center. Ranges which then touch are merged, which
+	// also drops a cut where only a tiny section was deleted.
+	minLength := max(2*opts.Crossfade.Seconds()+padding, minRangeLength.Seconds())
+	var ranges []avmedia.TimeRange
+	for _, s := range spans {
+		if length := s.end - s.start; length < minLength {
+			grow := (minLength - length) / 2
+			s.start = max(s.start-grow, 0)
+			s.end += grow
+			if duration > 0 {
+				s.end = min(s.end, duration)
+			}
+		}
+
+		r := avmedia.TimeRange{Start: seconds(s.start), End: seconds(s.end)}
+		if n := len(ranges); n > 0 && r.Start-ranges[n-1].End <= 2*opts.Crossfade {
+			ranges[n-1].End = max(ranges[n-1].End, r.End)
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,198 @@
+package edit
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+func TestMatchWords(t *testing.T) {
+
+	tests := []struct {
+		name string
+		a, b string
+		want []bool
+	}{
+		{"unchanged", "a b c", "a b c", []bool{true, true, true}},
+		{"deleted in the middle", "a b c d", "a d", []bool{true, false, false, true}},
+		{"deleted at both ends", "a b c d", "b c", []bool{false, true, true, false}},
+		{"inserted words", "a b c", "a x b y c", []bool{true, true, true}},
+		{"all deleted", "a b", "", []bool{false, false}},
+		{"repeated words", "the cat the dog the end", "the dog the end", []bool{true, false, false, true, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchWords(strings.Fields(tt.a), strings.Fields(tt.b)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchWords() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatchWordsLCS checks the diff against the quadratic longest common
+// subsequence on random edits.
+func TestMatchWordsLCS(t *testing.T) {
+
+	rng := rand.New(rand.NewSource(1))
+	vocab := []string{"a", "b", "c", "d"}
+	random := func(n int) []string {
+		words := make([]string, n)
+		for i := range words {
+			words[i] = vocab[rng.Intn(len(vocab))]
+		}
+		return words
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := random(rng.Intn(30)), random(rng.Intn(30))
+		kept := matchWords(a, b)
+
+		var common []string
+		for j, k := range kept {
+			if k {
+				common = append(common, a[j])
+			}
+		}
+		if len(common) != lcsLength(a, b) || !isSubsequence(common, b) {
+			t.Fatalf("matchWords(%v, %v) kept %v, which is not a longest common subsequence", a, b, common)
+		}
+	}
+}
+
+func lcsLength(a, b []string) int {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else {
+				table[i][j] = max(table[i+1][j], table[i][j+1])
+			}
+		}
+	}
+	return table[0][0]
+}
+
+func isSubsequence(s, of []string) bool {
+	i := 0
+	for _, word := range of {
+		if i < len(s) && s[i] == word {
+			i++
+		}
+	}
+	return i == len(s)
+}
+
+// testWords are words of 0.75s which start on every second, times are exact in
+// binary so that the ranges can be compared as is.
+func testWords(text string) []transcribe.Word {
+	var words []transcribe.Word
+	for i, field := range strings.Fields(text) {
+		words = append(words, transcribe.Word{Word: field, Start: float64(i), End: float64(i) + 0.75})
+	}
+	return words
+}
+
+func ms(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
+func TestNewPlan(t *testing.T) {
+
+	words := testWords("So, the plan is, um, simple.")
+	duration := 6 * time.Second
+
+	tests := []struct {
+		name   string
+		edited string
+		opts   Options
+		want   Plan
+	}{
+		{
+			name:   "unchanged",
+			edited: "So, the plan is, um, simple.",
+			opts:   Options{Padding: ms(125)},
+			want:   Plan{Ranges: []avmedia.TimeRange{{Start: 0, End: duration}}, Kept: 6},
+		},
+		{
+			name:   "word deleted and re-punctuated",
+			edited: "so the plan is simple!",
+			opts:   Options{Padding: ms(125)},
+			want: Plan{
+				Ranges:  []avmedia.TimeRange{{Start: 0, End: ms(3875)}, {Start: ms(4875), End: duration}},
+				Kept:    5,
+				Deleted: 1,
+			},
+		},
+		{
+			name:   "padding stops at the deleted words",
+			edited: "the plan",
+			opts:   Options{Padding: 2 * time.Second},
+			want: Plan{
+				Ranges:  []avmedia.TimeRange{{Start: ms(750), End: ms(3000)}},
+				Kept:    2,
+				Deleted: 4,
+			},
+		},
+		{
+			name:   "added words are ignored",
+			edited: "So the new plan is simple",
+			opts:   Options{Padding: ms(125)},
+			want: Plan{
+				Ranges:  []avmedia.TimeRange{{Start: 0, End: ms(3875)}, {Start: ms(4875), End: duration}},
+				Kept:    5,
+				Deleted: 1,
+				Ignored: 1,
+			},
+		},
+		{
+			name:   "short cut is merged by the crossfade",
+			edited: "so the plan is simple",
+			opts:   Options{Padding: ms(125), Crossfade: ms(500)},
+			want: Plan{
+				Ranges:  []avmedia.TimeRange{{Start: 0, End: duration}},
+				Kept:    5,
+				Deleted: 1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := NewPlan(words, tt.edited, duration, tt.opts)
+			if err != nil {
+				t.Fatalf("NewPlan() error = %v", err)
+			}
+			if !reflect.DeepEqual(plan, tt.want) {
+				t.Errorf("NewPlan() = %+v, want %+v", plan, tt.want)
+			}
+		})
+	}
+
+	// Words may have no duration, which must not leave an empty range without
+	// the padding and crossfade to widen it.
+	instant := []transcribe.Word{{Word: "a", Start: 0, End: 0.75}, {Word: "b", Start: 1, End: 1}, {Word: "c", Start: 2, End: 2.75}}
+	plan, err := NewPlan(instant, "b", 3*time.Second, Options{})
+	if err != nil {
+		t.Fatalf("NewPlan() error = %v", err)
+	}
+	if len(plan.Ranges) != 1 || plan.Ranges[0].End-plan.Ranges[0].Start < minRangeLength-time.Microsecond {
+		t.Errorf("NewPlan() ranges = %v, want one of %v around 1s", plan.Ranges, minRangeLength)
+	}
+
+	if _, err := NewPlan(nil, "text", duration, Options{}); err == nil {
+		t.Errorf("NewPlan() without words error = nil, want error")
+	}
+	if _, err := NewPlan(words, "something else entirely", duration, Options{}); err == nil {
+		t.Errorf("NewPlan() without kept words error = nil, want error")
+	}
+}
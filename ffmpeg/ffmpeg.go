@@ -1,67 +1,128 @@
 package ffmpeg
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
+	"math"
 	"os"
 	"os/exec"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/spiritorai/spiritor/logging"
 )
 
+// execWaitDelay is how long to wait for the output pipes to close after the
+// process has been killed by a canceled ctx.
+const execWaitDelay = 5 * time.Second
+
 func execCmd(ctx context.Context, app string, args []string) (string, error) {
 	if app == "" {
 		return "", fmt.Errorf("exec error: app cannot be empty")
 	}
 
-	cmd := exec.Command(app, args...)
+	// The process is killed if the ctx is canceled or times out before it exits. The
+	// wait delay ensures that we do not block forever on the output pipes if the
+	// process has spawned children which keep them open.
+	cmd := exec.CommandContext(ctx, app, args...)
 	cmd.Env = os.Environ()
+	cmd.WaitDelay = execWaitDelay
 
 	// Note: This enables interactive term proxying!!
 	// When this is disabled then interactive prompts trigger
 	// an automatic command error.
 	// cmd.Stdin = os.Stdin
 
-	//var stdout bytes.Buffer
-	//cmd.Stdout = &stdout //>> stderr.String()
-	//cmd.Stdout = os.Stderr
+	// Note: Both stdout and stderr are captured together because ffmpeg seems to use
+	// the stderr channel explicitely even for successful ops. The same writer is used
+	// for both so that exec serializes the writes. Each line is also streamed to the
+	// logger at debug level as it is written.
+	output := &logWriter{
+		ctx:    ctx,
+		logger: logging.FromContext(ctx).With("app", app),
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	// Encodes which report their progress write it to stdout, where it is parsed
+	// rather than logged or returned with the output.
+	if slices.Contains(args, "-progress") {
+		output.progress = &progressWriter{ctx: ctx}
+		cmd.Stdout = output.progress
+	}
+
+	output.logger.Debug("exec", "args", strings.Join(args, " "))
+
+	err := cmd.Run()
+	output.flush()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return output.buf.String(), fmt.Errorf("%v: %w", err, ctxErr)
+		}
+		return output.buf.String(), err
+	}
 
-	//var stderr bytes.Buffer
-	// cmd.Stderr = &stderr //>> stderr.String()
-	//cmd.Stderr = os.Stderr
+	return strings.TrimSpace(output.buf.String()), nil
+}
 
-	/*if err := cmd.Run(); err != nil {
-		// TODO: Include detailed stderr output
-		return fmt.Errorf("cmd run error: %v", err)
-	}*/
+// logWriter collects all of the output of a command and logs each line at debug
+// level in real time. Carriage returns are treated as line breaks since ffmpeg
+// uses them to redraw its progress line.
+type logWriter struct {
+	buf      bytes.Buffer // not embedded, or io.Copy would bypass Write via ReadFrom
+	ctx      context.Context
+	logger   *slog.Logger
+	pending  []byte          // partial line waiting for a line break
+	progress *progressWriter // set if the command reports its progress, which needs the input duration
+}
 
-	// TODO: Execute command async and listen for ctx canceled or timeout and kill op
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
 
-	// TODO: I would prefer to have outputs flow to console in realtime when debugging
-	// is enabled but I would have to implement my own pipeline for that so for now this
-	// will have to hack it.
+	if w.progress == nil && !w.logger.Enabled(w.ctx, slog.LevelDebug) {
+		return len(p), nil
+	}
 
-	// Note: Currently this uses CombinedOutput because ffmpeg seems to use the stderr
-	// channel explicitely even for successful ops, so this method is just easier. I do
-	// not know if the behavior will change on different os.
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return string(output), err
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexAny(w.pending, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.logLine(w.pending[:i])
+		w.pending = w.pending[i+1:]
 	}
 
-	// TODO: only when ctx debug = true
-	//fmt.Printf("Exec Output: %v\n", strings.TrimSpace(string(output)))
+	return len(p), nil
+}
+
+func (w *logWriter) flush() {
+	w.logLine(w.pending)
+	w.pending = nil
+}
 
-	return strings.TrimSpace(string(output)), nil
+func (w *logWriter) logLine(line []byte) {
+	if text := strings.TrimSpace(string(line)); text != "" {
+		if w.progress != nil {
+			w.progress.parseDuration(text)
+		}
+		w.logger.Debug(text)
+	}
 }
 
-// DownsampleOpus will convert the source file to the target file using the libopus
-// codec at the target bitrate. The target file path must have an ogg extension and
-// must not exist, or else an error will be thrown. The input file must also be
-// supported by the underlying ffmpeg operation, or an error will be thrown. Bitrate
-// calculations are the responsibility of the caller, unintentional upsample may occur.
-func DownsampleOpus(sourceFilePath, targetFilePath, targetBitrate string) error {
+// DownsampleOpus will convert the audio stream at the stream index of the source
+// file to the target file using the libopus codec at the target bitrate. Any other
+// streams, eg: video or subtitles, are dropped. The target file path must have an
+// ogg extension and must not exist, or else an error will be thrown. The input file
+// must also be supported by the underlying ffmpeg operation, or an error will be
+// thrown. Bitrate calculations are the responsibility of the caller, unintentional
+// upsample may occur.
+func DownsampleOpus(ctx context.Context, sourceFilePath, targetFilePath, targetBitrate string, streamIndex int) error {
 
 	// From: https://community.openai.com/t/whisper-api-increase-file-limit-25-mb/566754
 	// ffmpeg -i audio.mp3 -vn -map_metadata -1 -ac 1 -c:a libopus -b:a 12k -application voip audio.ogg
@@ -87,6 +148,8 @@ func DownsampleOpus(sourceFilePath, targetFilePath, targetBitrate string) error
 	args := []string{
 		"-i",
 		sourceFilePath,
+		"-map",
+		fmt.Sprintf("0:%v", streamIndex),
 		"-vn",
 		"-map_metadata",
 		"-1",
@@ -102,8 +165,9 @@ func DownsampleOpus(sourceFilePath, targetFilePath, targetBitrate string) error
 		"4",
 		targetFilePath,
 	}
+	args = withProgress(ctx, args)
 
-	output, err := execCmd(context.TODO(), app, args)
+	output, err := execCmd(ctx, app, args)
 	if err != nil {
 		return fmt.Errorf("downsample opus error: %v: %v", err, output)
 	}
@@ -111,93 +175,380 @@ func DownsampleOpus(sourceFilePath, targetFilePath, targetBitrate string) error
 	return nil
 }
 
-func ProbeBitrate(filePath string) (int, error) {
+// AudioDecoders returns the names of all audio codecs which the installed ffmpeg
+// can decode. The codec list is used rather than the decoder list since its names
+// match the codec names reported by ffprobe, eg: the mp3 codec is decoded by the
+// mp3float decoder.
+func AudioDecoders(ctx context.Context) ([]string, error) {
 
-	// From https://stackoverflow.com/questions/47087802/ffmpeg-how-to-convert-audio-to-aac-but-keep-bit-rate-at-what-the-old-file-used
-	// #!/usr/bin/env bash
-	// AUDIO_BITRATE=`ffprobe -v error -select_streams a:0  -show_entries stream=bit_rate -of default=noprint_wrappers=1:nokey=1 $1`
-	// if [[ $AUDIO_BITRATE < 128000 ]]; then
-	//   ffmpeg -i $1 -acodec aac -ab ${AUDIO_BITRATE}k -vcodec copy new-$1
-	// else
-	//   ffmpeg -i $1 -acodec aac -vcodec copy new-$1
-	// fi
-
-	app := "ffprobe"
+	app := "ffmpeg"
 	args := []string{
-		"-v",
-		"error",
-		"-select_streams",
-		"a:0",
-		"-show_entries",
-		"stream=bit_rate",
-		"-of",
-		"default=noprint_wrappers=1:nokey=1",
-		filePath,
+		"-hide_banner",
+		"-codecs",
+	}
+
+	output, err := execCmd(ctx, app, args)
+	if err != nil {
+		return nil, fmt.Errorf("codecs error: %w: %v", err, output)
 	}
 
-	var bitrate int
+	// The list follows a legend and a separator line, each entry starts with the
+	// capability flags, eg: " DEA.L. aac    AAC (Advanced Audio Coding)" where D
+	// is decode and A is audio.
+	var codecs []string
+	listed := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if !listed {
+			listed = len(fields) == 1 && strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) < 2 || len(fields[0]) < 3 {
+			continue
+		}
+		if flags := fields[0]; flags[0] == 'D' && flags[2] == 'A' {
+			codecs = append(codecs, fields[1])
+		}
+	}
+
+	if len(codecs) == 0 {
+		return nil, fmt.Errorf("codecs error: no audio decoders found")
+	}
+
+	return codecs, nil
+}
+
+// DecodePCM decodes the audio stream at the stream index of the source file into
+// the target file as raw signed 16 bit little endian mono samples at the sample
+// rate, for analysis which needs the waveform itself. The target file must not
+// exist.
+func DecodePCM(ctx context.Context, sourceFilePath, targetFilePath string, streamIndex, sampleRate int) error {
+
+	app := "ffmpeg"
+	args := []string{
+		"-nostats",
+		"-i",
+		sourceFilePath,
+		"-map",
+		fmt.Sprintf("0:%v", streamIndex),
+		"-vn",
+		"-ac",
+		"1",
+		"-ar",
+		strconv.Itoa(sampleRate),
+		"-f",
+		"s16le",
+		"-c:a",
+		"pcm_s16le",
+		targetFilePath,
+	}
 
-	output, err := execCmd(context.TODO(), app, args)
+	output, err := execCmd(ctx, app, args)
 	if err != nil {
-		return bitrate, fmt.Errorf("probe error: %v: %v", err, output)
+		return fmt.Errorf("decode pcm error: %v: %v", err, output)
 	}
 
-	// If the output is "N/A" then return the default bitrate of 0 without error.
-	if output == "N/A" {
-		return bitrate, nil
+	return nil
+}
+
+// Silence is a period of silence detected in a media file, in seconds from the
+// start of the file.
+type Silence struct {
+	Start float64
+	End   float64
+}
+
+var (
+	silenceStartRe = regexp.MustCompile(`silence_start:\s*(-?[0-9.]+)`)
+	silenceEndRe   = regexp.MustCompile(`silence_end:\s*(-?[0-9.]+)`)
+)
+
+// DetectSilence runs the silencedetect filter over the source file and returns all
+// periods where the volume stays below the noise threshold (eg: "-35dB") for at
+// least the min duration (eg: "0.5"). A trailing silence which lasts until the end
+// of the file will not have an end and is therefore ignored.
+func DetectSilence(ctx context.Context, sourceFilePath, noise, minDuration string) ([]Silence, error) {
+
+	app := "ffmpeg"
+	args := []string{
+		"-nostats",
+		"-i",
+		sourceFilePath,
+		"-af",
+		fmt.Sprintf("silencedetect=noise=%v:d=%v", noise, minDuration),
+		"-f",
+		"null",
+		"-",
 	}
 
-	// Only return an error if ffprobe outputs something unexpected.
-	bitrate, err = strconv.Atoi(output)
+	output, err := execCmd(ctx, app, args)
 	if err != nil {
-		return bitrate, fmt.Errorf("could not covert [%v] to int: %v", output, err)
+		return nil, fmt.Errorf("silence detect error: %v: %v", err, output)
+	}
+
+	var silences []Silence
+	var start float64
+	var open bool
+
+	for _, line := range strings.Split(output, "\n") {
+		if m := silenceStartRe.FindStringSubmatch(line); m != nil {
+			start, err = strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("could not convert silence start [%v] to float: %v", m[1], err)
+			}
+			open = true
+			continue
+		}
+		if m := silenceEndRe.FindStringSubmatch(line); m != nil && open {
+			end, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("could not convert silence end [%v] to float: %v", m[1], err)
+			}
+			silences = append(silences, Silence{Start: math.Max(start, 0), End: end})
+			open = false
+		}
 	}
 
-	return bitrate, nil
+	return silences, nil
 }
 
-func ProbeDuration(filePath string) (time.Duration, error) {
+// ExtractSegment copies the section of the source file starting at start seconds
+// and lasting for duration seconds into the target file without re-encoding. The
+// target file must not exist and must have the same container format as the source.
+func ExtractSegment(ctx context.Context, sourceFilePath, targetFilePath string, start, duration float64) error {
 
-	app := "ffprobe"
+	app := "ffmpeg"
 	args := []string{
-		"-v",
-		"error",
-		"-select_streams",
-		"a:0",
-		"-show_entries",
-		"stream=duration",
-		"-of",
-		"default=noprint_wrappers=1:nokey=1",
-		filePath,
+		"-ss",
+		strconv.FormatFloat(start, 'f', 3, 64),
+		"-i",
+		sourceFilePath,
+		"-t",
+		strconv.FormatFloat(duration, 'f', 3, 64),
+		"-map_metadata",
+		"-1",
+		"-c",
+		"copy",
+		targetFilePath,
+	}
+
+	output, err := execCmd(ctx, app, args)
+	if err != nil {
+		return fmt.Errorf("extract segment error: %v: %v", err, output)
+	}
+
+	return nil
+}
+
+// Range is a section of a media file in seconds.
+type Range struct {
+	Start float64
+	End   float64
+}
+
+// ConcatRanges renders the ranges of the source file back to back into the target
+// file, with crossfades of the given seconds at each join. Only the audio stream at
+// the audio index is kept, along with the video stream at the video index unless it
+// is negative. The crossfade must be shorter than every range. The streams are
+// re-encoded with the defaults for the target file extension.
+func ConcatRanges(ctx context.Context, sourceFilePath, targetFilePath string, ranges []Range, audioIndex, videoIndex int, crossfade float64) error {
+
+	if len(ranges) == 0 {
+		return fmt.Errorf("concat ranges error: no ranges")
+	}
+
+	// Each range is a separate seeking input rather than a trim of a single input,
+	// since the split filter would have to buffer everything between the ranges.
+	var args []string
+	for _, r := range ranges {
+		args = append(args,
+			"-ss", formatSeconds(r.Start),
+			"-t", formatSeconds(r.End-r.Start),
+			"-i", sourceFilePath,
+		)
+	}
+
+	var graph strings.Builder
+	for i := range ranges {
+		fmt.Fprintf(&graph, "[%d:%d]asetpts=PTS-STARTPTS[a%d];\n", i, audioIndex, i)
+		if videoIndex >= 0 {
+			fmt.Fprintf(&graph, "[%d:%d]setpts=PTS-STARTPTS[v%d];\n", i, videoIndex, i)
+		}
 	}
 
-	var duration time.Duration
+	joinRanges(&graph, ranges, "a", "aout", crossfade, func(prev, next string, offset float64) string {
+		return fmt.Sprintf("[%v][%v]acrossfade=d=%v:c1=tri:c2=tri", prev, next, formatSeconds(crossfade))
+	})
+	if videoIndex >= 0 {
+		graph.WriteString(";\n")
+		joinRanges(&graph, ranges, "v", "vout", crossfade, func(prev, next string, offset float64) string {
+			return fmt.Sprintf("[%v][%v]xfade=transition=fade:duration=%v:offset=%v", prev, next, formatSeconds(crossfade), formatSeconds(offset))
+		})
+	}
 
-	output, err := execCmd(context.TODO(), app, args)
+	// The graph grows with every cut so it is passed as a script file rather than
+	// as an argument, which could exceed the os limits.
+	script, err := os.CreateTemp("", "spiritor-*.filter")
 	if err != nil {
-		return duration, fmt.Errorf("probe error: %v: %v", err, output)
+		return fmt.Errorf("concat ranges error: failed to create filter script: %v", err)
+	}
+	defer os.Remove(script.Name())
+
+	if _, err := script.WriteString(graph.String()); err != nil {
+		script.Close()
+		return fmt.Errorf("concat ranges error: failed to write filter script: %v", err)
+	}
+	if err := script.Close(); err != nil {
+		return fmt.Errorf("concat ranges error: failed to write filter script: %v", err)
 	}
 
-	// Only return an error if ffprobe outputs something unexpected.
-	duration, err = time.ParseDuration(fmt.Sprintf("%vs", output))
+	args = append(args, "-filter_complex_script", script.Name(), "-map", "[aout]")
+	if videoIndex >= 0 {
+		args = append(args, "-map", "[vout]")
+	}
+	args = append(args, "-map_metadata", "-1", targetFilePath)
+
+	output, err := execCmd(ctx, "ffmpeg", args)
 	if err != nil {
-		return duration, fmt.Errorf("could not covert [%v]s to duration: %v", output, err)
+		return fmt.Errorf("concat ranges error: %v: %v", err, output)
+	}
+
+	return nil
+}
+
+// joinRanges writes the filters which join the labeled streams of every range, eg:
+// a0, a1, into the output label. Without a crossfade they are concatenated,
+// otherwise each is faded into the running result where the offset is the time in
+// the result at which the fade starts.
+func joinRanges(graph *strings.Builder, ranges []Range, label, output string, crossfade float64, fade func(prev, next string, offset float64) string) {
+
+	if len(ranges) == 1 || crossfade <= 0 {
+		for i := range ranges {
+			fmt.Fprintf(graph, "[%v%d]", label, i)
+		}
+		kind := "v=0:a=1"
+		if label == "v" {
+			kind = "v=1:a=0"
+		}
+		fmt.Fprintf(graph, "concat=n=%d:%v[%v]", len(ranges), kind, output)
+		return
 	}
 
-	return duration, nil
+	prev := label + "0"
+	length := ranges[0].End - ranges[0].Start
+	for i := 1; i < len(ranges); i++ {
+		next := fmt.Sprintf("%v%d", label, i)
+		result := fmt.Sprintf("%vx%d", label, i)
+		if i == len(ranges)-1 {
+			result = output
+		}
+		graph.WriteString(fade(prev, next, length-crossfade))
+		fmt.Fprintf(graph, "[%v]", result)
+		if i < len(ranges)-1 {
+			graph.WriteString(";\n")
+		}
+		length += ranges[i].End - ranges[i].Start - crossfade
+		prev = result
+	}
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
 }
 
-func ProbeDump(filePath string) (string, error) {
+// ConcatAudio joins the audio of the source files back to back into the target
+// file, encoded with the codec at the bitrate. The sources must share the same
+// codec and parameters, eg: the chunks of a single speech synthesis. The chapters
+// are written as chapter markers of the target, which is left without any other
+// metadata.
+func ConcatAudio(ctx context.Context, sourceFilePaths []string, targetFilePath, codec, bitrate string, chapters []Chapter) error {
+
+	if len(sourceFilePaths) == 0 {
+		return fmt.Errorf("concat audio error: no source files")
+	}
+
+	// The sources are read with the concat demuxer from a list file, so that any
+	// number of them can be joined without an input and a filter for each.
+	var list strings.Builder
+	for _, path := range sourceFilePaths {
+		fmt.Fprintf(&list, "file '%v'\n", strings.ReplaceAll(path, "'", `'\''`))
+	}
+
+	listFile, err := writeTempFile("spiritor-*.concat", list.String())
+	if err != nil {
+		return fmt.Errorf("concat audio error: failed to write list file: %v", err)
+	}
+	defer os.Remove(listFile)
 
-	app := "ffprobe"
 	args := []string{
-		filePath,
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listFile,
+	}
+
+	if len(chapters) > 0 {
+		metadata := strings.Builder{}
+		metadata.WriteString(";FFMETADATA1\n")
+		for _, chapter := range chapters {
+			fmt.Fprintf(&metadata, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%v\n", chapter.Start.Milliseconds(), chapter.End.Milliseconds(), escapeMetadata(chapter.Title))
+		}
+
+		metadataFile, err := writeTempFile("spiritor-*.ffmetadata", metadata.String())
+		if err != nil {
+			return fmt.Errorf("concat audio error: failed to write metadata file: %v", err)
+		}
+		defer os.Remove(metadataFile)
+
+		args = append(args, "-i", metadataFile, "-map_metadata", "1", "-map_chapters", "1")
+	} else {
+		args = append(args, "-map_metadata", "-1")
+	}
+
+	args = append(args, "-map", "0:a", "-c:a", codec)
+	if bitrate != "" {
+		args = append(args, "-b:a", bitrate)
 	}
+	args = append(args, targetFilePath)
 
-	output, err := execCmd(context.TODO(), app, args)
+	output, err := execCmd(ctx, "ffmpeg", args)
 	if err != nil {
-		return output, fmt.Errorf("probe error: %v: %v", err, output)
+		return fmt.Errorf("concat audio error: %v: %v", err, output)
+	}
+
+	return nil
+}
+
+// escapeMetadata escapes the characters which are special in an ffmetadata file.
+func escapeMetadata(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune("=;#\\\n", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// writeTempFile writes the content to a new temp file and returns its path, it
+// is the callers responsibility to remove it.
+func writeTempFile(pattern, content string) (string, error) {
+
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
 	}
 
-	return output, nil
+	return file.Name(), nil
 }
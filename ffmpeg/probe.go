@@ -0,0 +1,185 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeResult holds all of the metadata of a media file from a single ffprobe run.
+type ProbeResult struct {
+	Format   Format
+	Streams  []Stream // ordered by index
+	Chapters []Chapter
+}
+
+// Format is the container level metadata.
+type Format struct {
+	Name     string            // format names, eg: mov,mp4,m4a,3gp,3g2,mj2
+	LongName string            // eg: QuickTime / MOV
+	Duration time.Duration     // 0 if unknown
+	Size     int64             // file size in bytes
+	Bitrate  int               // overall bitrate of all streams, 0 if unknown
+	Tags     map[string]string // eg: title, artist, creation_time
+}
+
+// Stream is an entry of the stream inventory of a media file.
+type Stream struct {
+	Index         int               // absolute stream index within the file
+	Type          string            // codec type, eg: audio, video, subtitle
+	Codec         string            // codec name, eg: aac
+	CodecLongName string            // eg: AAC (Advanced Audio Coding)
+	Profile       string            // codec profile, eg: LC
+	SampleRate    int               // audio sample rate in Hz
+	Channels      int               // audio channel count
+	ChannelLayout string            // eg: stereo
+	Width         int               // video width in pixels
+	Height        int               // video height in pixels
+	Bitrate       int               // encoded bitrate, 0 if unknown
+	Duration      time.Duration     // 0 if unknown, eg: mkv only has a format duration
+	Language      string            // language tag, usually ISO-639-2, eg: eng
+	Title         string            // title tag, eg: Director's Commentary
+	Default       bool              // stream is flagged as the default of its type
+	AttachedPic   bool              // video stream is cover art rather than a video
+	Tags          map[string]string // all stream tags
+}
+
+// Chapter is a named section of a media file, eg: from an audiobook.
+type Chapter struct {
+	ID    int64
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+// probeOutput mirrors the ffprobe json output, which sends most numbers as
+// strings which may also be missing or N/A.
+type probeOutput struct {
+	Format struct {
+		FormatName     string            `json:"format_name"`
+		FormatLongName string            `json:"format_long_name"`
+		Duration       string            `json:"duration"`
+		Size           string            `json:"size"`
+		BitRate        string            `json:"bit_rate"`
+		Tags           map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index         int               `json:"index"`
+		CodecType     string            `json:"codec_type"`
+		CodecName     string            `json:"codec_name"`
+		CodecLongName string            `json:"codec_long_name"`
+		Profile       string            `json:"profile"`
+		SampleRate    string            `json:"sample_rate"`
+		Channels      int               `json:"channels"`
+		ChannelLayout string            `json:"channel_layout"`
+		Width         int               `json:"width"`
+		Height        int               `json:"height"`
+		BitRate       string            `json:"bit_rate"`
+		Duration      string            `json:"duration"`
+		Tags          map[string]string `json:"tags"`
+		Disposition   map[string]int    `json:"disposition"`
+	} `json:"streams"`
+	Chapters []struct {
+		ID        int64             `json:"id"`
+		StartTime string            `json:"start_time"`
+		EndTime   string            `json:"end_time"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"chapters"`
+}
+
+// Probe runs ffprobe once and returns the format, streams and chapters of the
+// file. It fails if the file is not a media file that ffprobe can read.
+func Probe(ctx context.Context, filePath string) (ProbeResult, error) {
+
+	app := "ffprobe"
+	args := []string{
+		"-v",
+		"error",
+		"-print_format",
+		"json",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		filePath,
+	}
+
+	var result ProbeResult
+
+	output, err := execCmd(ctx, app, args)
+	if err != nil {
+		return result, fmt.Errorf("probe error: %w: %v", err, strings.TrimSpace(output))
+	}
+
+	var probe probeOutput
+	if err := json.Unmarshal([]byte(output), &probe); err != nil {
+		return result, fmt.Errorf("could not parse probe output: %v", err)
+	}
+
+	if probe.Format.FormatName == "" {
+		return result, fmt.Errorf("probe error: no format found")
+	}
+
+	result.Format = Format{
+		Name:     probe.Format.FormatName,
+		LongName: probe.Format.FormatLongName,
+		Duration: parseSeconds(probe.Format.Duration),
+		Size:     int64(parseInt(probe.Format.Size)),
+		Bitrate:  parseInt(probe.Format.BitRate),
+		Tags:     probe.Format.Tags,
+	}
+
+	for _, s := range probe.Streams {
+		result.Streams = append(result.Streams, Stream{
+			Index:         s.Index,
+			Type:          s.CodecType,
+			Codec:         s.CodecName,
+			CodecLongName: s.CodecLongName,
+			Profile:       s.Profile,
+			SampleRate:    parseInt(s.SampleRate),
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+			Width:         s.Width,
+			Height:        s.Height,
+			Bitrate:       parseInt(s.BitRate),
+			Duration:      parseSeconds(s.Duration),
+			Language:      s.Tags["language"],
+			Title:         s.Tags["title"],
+			Default:       s.Disposition["default"] == 1,
+			AttachedPic:   s.Disposition["attached_pic"] == 1,
+			Tags:          s.Tags,
+		})
+	}
+
+	for _, c := range probe.Chapters {
+		result.Chapters = append(result.Chapters, Chapter{
+			ID:    c.ID,
+			Start: parseSeconds(c.StartTime),
+			End:   parseSeconds(c.EndTime),
+			Title: c.Tags["title"],
+		})
+	}
+
+	return result, nil
+}
+
+// parseInt returns 0 for missing or N/A values.
+func parseInt(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// parseSeconds converts decimal seconds, eg: "12.500000", to a duration and
+// returns 0 for missing or N/A values.
+func parseSeconds(s string) time.Duration {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return time.Duration(f * float64(time.Second))
+}
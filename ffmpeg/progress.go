@@ -0,0 +1,84 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/spiritorai/spiritor/progress"
+)
+
+// durationLine matches the duration of an input in the stderr output, eg:
+//
+//	Duration: 00:50:12.34, start: 0.000000, bitrate: 101 kb/s
+var durationLine = regexp.MustCompile(`^Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// withProgress adds the args which make ffmpeg write its progress to stdout, if
+// the ctx carries a progress func. The progress replaces the stats line.
+func withProgress(ctx context.Context, args []string) []string {
+	if !progress.Enabled(ctx) {
+		return args
+	}
+	return append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+}
+
+// progressWriter parses the key=value blocks which ffmpeg writes with -progress,
+// and reports the media time encoded so far out of the duration of the first
+// input. The duration is parsed from stderr by the log writer, from another
+// goroutine.
+type progressWriter struct {
+	ctx     context.Context
+	total   atomic.Int64 // duration of the first input in microseconds, 0 until known
+	pending []byte       // partial line waiting for a line break
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.parseLine(strings.TrimSpace(string(w.pending[:i])))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) parseLine(line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+
+	total := w.total.Load()
+	switch key {
+	case "out_time_us":
+		if done, err := strconv.ParseInt(value, 10, 64); err == nil && done >= 0 {
+			progress.Report(w.ctx, progress.Encode, done, total)
+		}
+	case "progress":
+		if value == "end" && total > 0 {
+			progress.Report(w.ctx, progress.Encode, total, total)
+		}
+	}
+}
+
+func (w *progressWriter) parseDuration(line string) {
+	if w.total.Load() > 0 {
+		return
+	}
+
+	match := durationLine.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+
+	hours, _ := strconv.ParseInt(match[1], 10, 64)
+	minutes, _ := strconv.ParseInt(match[2], 10, 64)
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+	w.total.Store((hours*3600+minutes*60)*1e6 + int64(seconds*1e6))
+}
@@ -0,0 +1,202 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Based on the slog handler guide: https://github.com/golang/example/tree/master/slog-handler-guide
+
+const (
+	ansiReset   = "\033[0m"
+	ansiBold    = "\033[1m"
+	ansiDim     = "\033[2m"
+	ansiRed     = "\033[31m"
+	ansiGreen   = "\033[32m"
+	ansiYellow  = "\033[33m"
+	ansiMagenta = "\033[35m"
+)
+
+type ConsoleHandlerOptions struct {
+	Level slog.Leveler // min level to write, defaults to info
+	Color bool         // write ansi color codes
+}
+
+// ConsoleHandler is a slog handler which writes short human friendly lines, eg:
+//
+//	15:04:05 INF transcribing file=zoom.mp3 stage=transcribe
+//
+// It is intended for interactive use, the json handler should be used for
+// anything that needs to be parsed.
+type ConsoleHandler struct {
+	opts     ConsoleHandlerOptions
+	preAttrs []byte   // attrs from WithAttrs, already formatted
+	groups   []string // groups from WithGroup, used as key prefixes
+	mu       *sync.Mutex
+	w        io.Writer
+}
+
+func NewConsoleHandler(w io.Writer, opts *ConsoleHandlerOptions) *ConsoleHandler {
+	h := &ConsoleHandler{mu: &sync.Mutex{}, w: w}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	return h
+}
+
+func (h *ConsoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+func (h *ConsoleHandler) Handle(_ context.Context, r slog.Record) error {
+
+	var buf bytes.Buffer
+
+	if !r.Time.IsZero() {
+		h.colorize(&buf, ansiDim, r.Time.Format(time.TimeOnly))
+		buf.WriteByte(' ')
+	}
+
+	h.writeLevel(&buf, r.Level)
+	buf.WriteByte(' ')
+
+	if r.Level >= slog.LevelWarn {
+		h.colorize(&buf, ansiBold, r.Message)
+	} else {
+		buf.WriteString(r.Message)
+	}
+
+	buf.Write(h.preAttrs)
+
+	prefix := h.keyPrefix()
+	r.Attrs(func(a slog.Attr) bool {
+		h.appendAttr(&buf, prefix, a)
+		return true
+	})
+
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func (h *ConsoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	h2 := *h
+	buf := bytes.NewBuffer(append([]byte(nil), h.preAttrs...))
+	prefix := h.keyPrefix()
+	for _, a := range attrs {
+		h.appendAttr(buf, prefix, a)
+	}
+	h2.preAttrs = buf.Bytes()
+	return &h2
+}
+
+func (h *ConsoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := *h
+	h2.groups = append(append([]string(nil), h.groups...), name)
+	return &h2
+}
+
+func (h *ConsoleHandler) keyPrefix() string {
+	if len(h.groups) == 0 {
+		return ""
+	}
+	return strings.Join(h.groups, ".") + "."
+}
+
+func (h *ConsoleHandler) writeLevel(buf *bytes.Buffer, level slog.Level) {
+	switch {
+	case level >= slog.LevelError:
+		h.colorize(buf, ansiRed, "ERR")
+	case level >= slog.LevelWarn:
+		h.colorize(buf, ansiYellow, "WRN")
+	case level >= slog.LevelInfo:
+		h.colorize(buf, ansiGreen, "INF")
+	default:
+		h.colorize(buf, ansiMagenta, "DBG")
+	}
+}
+
+func (h *ConsoleHandler) appendAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range attrs {
+			h.appendAttr(buf, prefix, ga)
+		}
+		return
+	}
+
+	buf.WriteByte(' ')
+	h.colorize(buf, ansiDim, prefix+a.Key+"=")
+
+	var value string
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		value = a.Value.Duration().Round(time.Millisecond).String()
+	case slog.KindTime:
+		value = a.Value.Time().Format(time.RFC3339)
+	default:
+		value = a.Value.String()
+	}
+
+	if a.Key == "err" || a.Key == "error" {
+		h.colorize(buf, ansiRed, quote(value))
+		return
+	}
+	buf.WriteString(quote(value))
+}
+
+func (h *ConsoleHandler) colorize(buf *bytes.Buffer, color, s string) {
+	if !h.opts.Color {
+		buf.WriteString(s)
+		return
+	}
+	fmt.Fprintf(buf, "%v%v%v", color, s, ansiReset)
+}
+
+// quote wraps the value in quotes only if it is empty or contains spaces or other
+// characters which would make the line ambiguous.
+func quote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
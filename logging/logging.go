@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+var supportedFormat = map[string]struct{}{
+	FormatConsole: {},
+	FormatJSON:    {},
+}
+
+func FormatAllowed(format string) bool {
+	if _, ok := supportedFormat[format]; !ok {
+		return false
+	}
+	return true
+}
+
+// New creates a logger which writes to w in the given format. The console format
+// is colored when w is a terminal and the NO_COLOR env var is not set.
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	switch format {
+	case FormatConsole:
+		return slog.New(NewConsoleHandler(w, &ConsoleHandlerOptions{
+			Level: level,
+			Color: IsTerminal(w) && os.Getenv("NO_COLOR") == "",
+		})), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: level,
+		})), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format: %v", format)
+	}
+}
+
+type ctxKey struct{}
+
+// WithLogger returns a copy of the ctx which carries the logger. This is how the
+// logger is passed down to the packages which do the actual work.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext returns the logger carried by the ctx. If there is none then a
+// logger which discards everything is returned, so library callers which do not
+// set up logging get no output.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return discard
+}
+
+var discard = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// IsTerminal reports whether w is a character device such as a terminal.
+func IsTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
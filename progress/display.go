@@ -0,0 +1,312 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redrawInterval is how often the block of a display is redrawn, which also
+	// limits how often the progress of the tasks is shown to change.
+	redrawInterval = 200 * time.Millisecond
+
+	// maxActiveLines is the max number of active tasks shown in the block, the
+	// rest are only counted.
+	maxActiveLines = 8
+
+	lineWidth = 79
+	barWidth  = 20
+	nameWidth = 24
+)
+
+const (
+	ansiReset = "\033[0m"
+	ansiRed   = "\033[31m"
+	ansiGreen = "\033[32m"
+)
+
+// Display shows the progress of a set of tasks on a terminal, as a block of lines
+// which is redrawn in place, eg:
+//
+//	downsampling lecture.mp4              [=========           ]  45% 11:20/25:00
+//	uploading    zoom.mp3                 [=============       ]  63% 4.2/11.8 MB
+//	1 queued, 2 active, 3 done, 0 failed
+//	overall                               [=======             ]  38% eta 4m10s
+//
+// Lines written to the display, eg: by a logger, are printed above the block, as
+// are the tasks once they finish. If the output is not a terminal then the
+// display only passes writes through, and keeps track of the tasks for Overall.
+type Display struct {
+	w     io.Writer
+	tty   bool
+	color bool
+	start time.Time
+
+	mu    sync.Mutex
+	tasks []*Task
+	lines int // lines of the block which are currently drawn
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Task is the progress of a single piece of work, eg: a file. Tasks are queued
+// until their first update.
+type Task struct {
+	d        *Display
+	name     string
+	weight   float64 // share of the total work, eg: the media duration
+	status   string
+	detail   string
+	fraction float64
+	active   bool
+	finished bool
+	failed   bool
+}
+
+// Overall is the progress of all the tasks of a display.
+type Overall struct {
+	Finished int
+	Failed   int
+	Total    int
+	Fraction float64       // of the total weight of the tasks
+	ETA      time.Duration // 0 until there is any progress
+}
+
+// NewDisplay returns a display which draws on w if tty is set. Color adds ansi
+// color codes to the finished tasks.
+func NewDisplay(w io.Writer, tty, color bool) *Display {
+	return &Display{
+		w:     w,
+		tty:   tty,
+		color: color,
+		start: time.Now(),
+	}
+}
+
+// Add adds a queued task. The weight is its share of the total work which the
+// ETA is based on, tasks without any weight are only counted.
+func (d *Display) Add(name string, weight float64) *Task {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	task := &Task{d: d, name: name, weight: max(weight, 0), status: "queued"}
+	d.tasks = append(d.tasks, task)
+	return task
+}
+
+// Update makes the task active with the status, the fraction of it which is
+// done, and a short detail such as the amount of data sent. The fraction never
+// goes back, so that a retried step does not undo the progress shown.
+func (t *Task) Update(status string, fraction float64, detail string) {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+
+	if t.finished {
+		return
+	}
+	t.active = true
+	t.status = status
+	t.detail = detail
+	t.fraction = max(t.fraction, min(fraction, 1))
+}
+
+// Finish marks the task as done, or failed, and prints its final line above the
+// block.
+func (t *Task) Finish(status, detail string, failed bool) {
+	d := t.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t.finished {
+		return
+	}
+	t.active = false
+	t.finished = true
+	t.failed = failed
+	t.status = status
+	t.detail = detail
+	t.fraction = 1
+
+	if !d.tty {
+		return
+	}
+
+	color := ansiGreen
+	if failed {
+		color = ansiRed
+	}
+	line := fit(fmt.Sprintf("  %-12s %-*s %s", status, nameWidth, fit(t.name, nameWidth), detail), lineWidth)
+	if d.color {
+		line = color + line + ansiReset
+	}
+
+	d.clear()
+	fmt.Fprintln(d.w, line)
+	d.draw()
+}
+
+// Write prints p above the block, so that the display can be used as the output
+// of a logger. Writes are expected to be whole lines.
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.tty {
+		return d.w.Write(p)
+	}
+
+	d.clear()
+	n, err := d.w.Write(p)
+	d.draw()
+	return n, err
+}
+
+// Start redraws the block in the background until Stop is called. It does
+// nothing if the output is not a terminal.
+func (d *Display) Start() {
+	if !d.tty {
+		return
+	}
+
+	d.stop = make(chan struct{})
+	d.stopped = make(chan struct{})
+	go func() {
+		defer close(d.stopped)
+
+		ticker := time.NewTicker(redrawInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.mu.Lock()
+				d.clear()
+				d.draw()
+				d.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop stops redrawing and removes the block, the lines printed above it stay.
+func (d *Display) Stop() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.stopped
+	d.stop = nil
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+}
+
+// Overall returns the progress of all the tasks. The ETA assumes that the rest
+// of the work goes at the average rate so far.
+func (d *Display) Overall() Overall {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.overall()
+}
+
+func (d *Display) overall() Overall {
+
+	var o Overall
+	var done, total float64
+	for _, task := range d.tasks {
+		o.Total++
+		if task.finished {
+			o.Finished++
+		}
+		if task.failed {
+			o.Failed++
+		}
+		done += task.weight * task.fraction
+		total += task.weight
+	}
+
+	// Without any weights every task counts the same.
+	if total == 0 {
+		for _, task := range d.tasks {
+			done += task.fraction
+			total++
+		}
+	}
+
+	if total > 0 {
+		o.Fraction = done / total
+	}
+	if o.Fraction > 0 && o.Fraction < 1 {
+		elapsed := time.Since(d.start)
+		o.ETA = time.Duration(float64(elapsed) * (1 - o.Fraction) / o.Fraction)
+	}
+	return o
+}
+
+// clear moves the cursor back to the start of the block and erases it.
+func (d *Display) clear() {
+	if d.lines > 0 {
+		fmt.Fprintf(d.w, "\033[%dF\033[J", d.lines)
+		d.lines = 0
+	}
+}
+
+// draw writes the block below the cursor.
+func (d *Display) draw() {
+
+	var lines []string
+	var queued, active int
+	for _, task := range d.tasks {
+		switch {
+		case task.active:
+			active++
+			if active <= maxActiveLines {
+				lines = append(lines, fmt.Sprintf("  %-12s %-*s %s %3.0f%% %s", task.status, nameWidth, fit(task.name, nameWidth), bar(task.fraction), task.fraction*100, task.detail))
+			}
+		case !task.finished:
+			queued++
+		}
+	}
+	if active > maxActiveLines {
+		lines = append(lines, fmt.Sprintf("  ... %d more", active-maxActiveLines))
+	}
+
+	o := d.overall()
+	lines = append(lines, fmt.Sprintf("  %d queued, %d active, %d done, %d failed", queued, active, o.Finished-o.Failed, o.Failed))
+
+	eta := "-"
+	if o.ETA > 0 {
+		eta = o.ETA.Round(time.Second).String()
+	}
+	lines = append(lines, fmt.Sprintf("  %-*s %s %3.0f%% eta %s", 12+1+nameWidth, "overall", bar(o.Fraction), o.Fraction*100, eta))
+
+	var buf strings.Builder
+	for _, line := range lines {
+		buf.WriteString(fit(line, lineWidth))
+		buf.WriteByte('\n')
+	}
+	io.WriteString(d.w, buf.String())
+	d.lines = len(lines)
+}
+
+// bar draws the fraction as a bar of fixed width.
+func bar(fraction float64) string {
+	n := int(min(max(fraction, 0), 1) * barWidth)
+	return "[" + strings.Repeat("=", n) + strings.Repeat(" ", barWidth-n) + "]"
+}
+
+// fit cuts s to the width, eg: so that lines never wrap and the block can be
+// erased by its line count.
+func fit(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
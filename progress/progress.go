@@ -0,0 +1,67 @@
+package progress
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+// Kind is the kind of work which is reported.
+type Kind string
+
+const (
+	Encode Kind = "encode" // media encoded by ffmpeg, in microseconds of media time
+	Upload Kind = "upload" // bytes of a request body sent
+)
+
+// Func receives the amount of work done so far out of the total, which is 0 if
+// it is not known. It may be called from any goroutine.
+type Func func(kind Kind, done, total int64)
+
+type ctxKey struct{}
+
+// WithFunc returns a copy of the ctx which carries the progress func. This is
+// how the progress of the packages which do the actual work is passed back up,
+// the same way the logger is passed down.
+func WithFunc(ctx context.Context, fn Func) context.Context {
+	return context.WithValue(ctx, ctxKey{}, fn)
+}
+
+// Report passes the progress to the func of the ctx, if there is one.
+func Report(ctx context.Context, kind Kind, done, total int64) {
+	if fn, ok := ctx.Value(ctxKey{}).(Func); ok {
+		fn(kind, done, total)
+	}
+}
+
+// Enabled reports whether the ctx carries a progress func, so that callers can
+// skip the work of measuring progress which nobody receives.
+func Enabled(ctx context.Context) bool {
+	_, ok := ctx.Value(ctxKey{}).(Func)
+	return ok
+}
+
+// NewReader returns a reader which reports the bytes read from r out of the
+// total, eg: to report the upload of a request body as the client reads it.
+func NewReader(ctx context.Context, kind Kind, r io.Reader, total int64) io.Reader {
+	if !Enabled(ctx) {
+		return r
+	}
+	return &reader{ctx: ctx, kind: kind, r: r, total: total}
+}
+
+type reader struct {
+	ctx   context.Context
+	kind  Kind
+	r     io.Reader
+	total int64
+	done  atomic.Int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		Report(r.ctx, r.kind, r.done.Add(int64(n)), r.total)
+	}
+	return n, err
+}
@@ -0,0 +1,69 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReaderReports(t *testing.T) {
+
+	var done, total int64
+	ctx := WithFunc(context.Background(), func(kind Kind, d, n int64) {
+		if kind != Upload {
+			n = -1
+		}
+		done, total = d, n
+	})
+
+	data := strings.Repeat("x", 1000)
+	r := NewReader(ctx, Upload, strings.NewReader(data), int64(len(data)))
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if done != 1000 || total != 1000 {
+		t.Errorf("reported %v/%v, want 1000/1000", done, total)
+	}
+
+	// Without a progress func the reader is passed through as is.
+	plain := strings.NewReader(data)
+	if r := NewReader(context.Background(), Upload, plain, 1000); r != plain {
+		t.Errorf("reader was wrapped without a progress func")
+	}
+}
+
+func TestDisplayOverall(t *testing.T) {
+
+	var out bytes.Buffer
+	d := NewDisplay(&out, false, false)
+
+	short := d.Add("short.mp3", 10)
+	long := d.Add("long.mp3", 30)
+	d.Add("notes.txt", 0).Finish("skipped", "unsupported file", false)
+
+	short.Update("transcribing", 0.5, "")
+	short.Update("downsampling", 0.2, "") // never goes back
+	long.Update("downsampling", 0.5, "")
+
+	o := d.Overall()
+	if o.Total != 3 || o.Finished != 1 || o.Failed != 0 {
+		t.Errorf("counts = %+v, want 3 total and 1 finished", o)
+	}
+	if want := (10*0.5 + 30*0.5) / 40; o.Fraction != want {
+		t.Errorf("fraction = %v, want %v", o.Fraction, want)
+	}
+
+	short.Finish("done", "", false)
+	long.Finish("failed", "", true)
+	o = d.Overall()
+	if o.Finished != 3 || o.Failed != 1 || o.Fraction != 1 || o.ETA != 0 {
+		t.Errorf("overall = %+v, want all finished", o)
+	}
+
+	// Without a terminal nothing is drawn.
+	if out.Len() != 0 {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
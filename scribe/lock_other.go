@@ -0,0 +1,25 @@
+//go:build !unix
+
+package scribe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// lockFile creates the file exclusively, as there is no flock. A process which
+// dies without closing the state leaves the file behind, so the error names it.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%w, or it crashed: remove %v if no other process is running", errStateLocked, path)
+	}
+	return f, err
+}
+
+// unlockFile releases the lock by removing the file.
+func unlockFile(f *os.File) error {
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package scribe
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on the file without waiting for it. The
+// lock is released by the os if the process dies, so it is never left stale.
+func lockFile(path string) (*os.File, error) {
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		f.Close()
+		return nil, errStateLocked
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// unlockFile releases the lock by closing the file. The file is kept, since
+// removing it could race with another process which is taking the lock.
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
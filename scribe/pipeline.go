@@ -0,0 +1,113 @@
+package scribe
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spiritorai/spiritor/diarize"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+// PipelineConfig configures the workers of a Pipeline.
+type PipelineConfig struct {
+	Workdir              string                 // workdir for the intermediate files, unless a state is given
+	State                *State                 // optional job state, see the workers
+	Transcriber          transcribe.Transcriber // shared by all transcription workers
+	Diarizer             diarize.Diarizer       // optional, labels the speakers of every job
+	DownsampleWorkers    int                    // downsample and diarization worker pool size
+	TranscriptionWorkers int                    // transcription worker pool size
+	DownsampleTimeout    time.Duration          // max time to downsample or split a single file, 0 for none
+}
+
+// Pipeline connects the workers of every stage so that jobs can be submitted one
+// at a time for as long as it runs, eg: by a batch, a watched dir or a server.
+// Every submitted job is passed to the handler once, either when it has been
+// transcribed (and diarized) or when it failed at any stage, so the handler must
+// check the job error. The handler is called from a single goroutine.
+type Pipeline struct {
+	downsampleJobs    chan Job
+	transcriptionJobs chan Job
+	transcribedJobs   chan Job // the diarize jobs if enabled, else the results
+	diarizeJobs       chan Job
+	results           chan Job
+
+	pending sync.WaitGroup // submitted jobs which have not been handled yet
+	done    chan struct{}  // closed once the handler has returned for the last time
+}
+
+// StartPipeline starts the workers and the handler. The workers stop once the
+// pipeline is closed, if the ctx is canceled then the remaining jobs still reach
+// the handler but no further work is started for them.
+func StartPipeline(ctx context.Context, config PipelineConfig, handle func(job Job)) *Pipeline {
+
+	p := &Pipeline{
+		downsampleJobs:    make(chan Job),
+		transcriptionJobs: make(chan Job),
+		results:           make(chan Job),
+		done:              make(chan struct{}),
+	}
+
+	// Transcribed jobs go through diarization first if it is enabled. It is a
+	// local cpu bound step like the downsampling so it uses the same pool size.
+	p.transcribedJobs = p.results
+	if config.Diarizer != nil {
+		p.diarizeJobs = make(chan Job)
+		p.transcribedJobs = p.diarizeJobs
+
+		for w := 1; w <= config.DownsampleWorkers; w++ {
+			go DiarizeWorker(ctx, config.Diarizer, config.State, p.diarizeJobs, p.results, p.results)
+		}
+	}
+
+	for w := 1; w <= config.DownsampleWorkers; w++ {
+		go DownsampleWorker(ctx, config.Workdir, config.DownsampleTimeout, config.State, p.downsampleJobs, p.transcriptionJobs, p.results)
+	}
+
+	for w := 1; w <= config.TranscriptionWorkers; w++ {
+		go TranscriptionWorker(ctx, config.Workdir, config.Transcriber, config.State, p.transcriptionJobs, p.transcribedJobs, p.results)
+	}
+
+	go func() {
+		defer close(p.done)
+		for job := range p.results {
+			handle(job)
+			p.pending.Done()
+		}
+	}()
+
+	return p
+}
+
+// Submit enters the job into the pipeline after its last completed stage. It
+// blocks until a worker of that stage is free, so it must not be called from
+// the handler.
+func (p *Pipeline) Submit(job Job) {
+	p.pending.Add(1)
+	switch job.Stage {
+	case StageTranscribed:
+		p.transcribedJobs <- job
+	case StageDownsampled:
+		p.transcriptionJobs <- job
+	default:
+		p.downsampleJobs <- job
+	}
+}
+
+// Wait blocks until every submitted job has been handled.
+func (p *Pipeline) Wait() {
+	p.pending.Wait()
+}
+
+// Close waits for every submitted job to be handled and then stops the workers.
+// No jobs may be submitted once it has been called.
+func (p *Pipeline) Close() {
+	p.pending.Wait()
+	close(p.downsampleJobs)
+	close(p.transcriptionJobs)
+	if p.diarizeJobs != nil {
+		close(p.diarizeJobs)
+	}
+	close(p.results)
+	<-p.done
+}
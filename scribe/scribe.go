@@ -2,70 +2,406 @@ package scribe
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/diarize"
+	"github.com/spiritorai/spiritor/logging"
+	"github.com/spiritorai/spiritor/progress"
 	"github.com/spiritorai/spiritor/transcribe"
 )
 
 // Pattern: https://go.dev/play/p/bqisBD1y2hI
 
+const (
+	// chunkOverlap is the length of audio shared by consecutive chunks when a file
+	// has to be split to fit the upload size cap.
+	chunkOverlap time.Duration = 5 * time.Second
+
+	// chunkConcurrency is the max number of chunks of a single job which are
+	// transcribed at the same time.
+	chunkConcurrency int = 3
+)
+
 type Job struct {
+	Stage       Stage // last completed stage
 	SourceMedia avmedia.Media
 	TargetMedia avmedia.Media
-	Transcript  transcribe.Transcript
+	Chunks      []avmedia.Chunk          // set when the target media had to be split
+	Transcript  transcribe.Transcript    // raw transcript from the engine
+	Speakers    []transcribe.SpeakerTurn // set by diarization, applied when writing outputs
 	Err         error
+
+	// Ctx optionally scopes the work on this job, eg: so that it can be canceled
+	// on its own. The workers stop working on the job once either it or their
+	// own ctx is canceled.
+	Ctx context.Context
 }
 
+// Step is the work which a worker starts on a job.
+type Step string
+
+const (
+	StepDownsample Step = "downsample"
+	StepTranscribe Step = "transcribe"
+	StepDiarize    Step = "diarize"
+)
+
+type stepFuncKey struct{}
+
+// WithStepFunc returns a ctx which makes the workers report every step they
+// start on a job to fn, eg: to show the progress of the jobs. It is called from
+// the workers, so it must be safe for concurrent use.
+func WithStepFunc(ctx context.Context, fn func(job Job, step Step)) context.Context {
+	return context.WithValue(ctx, stepFuncKey{}, fn)
+}
+
+// startStep reports the step to the step func of the ctx, if any, and returns
+// the logger from the ctx with the job attributes attached.
+func startStep(ctx context.Context, job Job, step Step) *slog.Logger {
+	if fn, ok := ctx.Value(stepFuncKey{}).(func(job Job, step Step)); ok {
+		fn(job, step)
+	}
+	return logging.FromContext(ctx).With("file", job.SourceMedia.GetName(), "stage", step)
+}
+
+type progressFuncKey struct{}
+
+// WithProgressFunc returns a ctx which makes the workers report the progress
+// within the steps of a job to fn, eg: the encode of the downsample or the upload
+// of the transcribe step. Like the step func, it must be safe for concurrent use.
+func WithProgressFunc(ctx context.Context, fn func(job Job, kind progress.Kind, done, total int64)) context.Context {
+	return context.WithValue(ctx, progressFuncKey{}, fn)
+}
+
+// jobContext returns the ctx of the worker for the job, which is also canceled
+// along with the ctx of the job if it has one, and which reports the progress of
+// the work on the job to the progress func, if any.
+func jobContext(ctx context.Context, job Job) (context.Context, context.CancelFunc) {
+	if fn, ok := ctx.Value(progressFuncKey{}).(func(job Job, kind progress.Kind, done, total int64)); ok {
+		ctx = progress.WithFunc(ctx, func(kind progress.Kind, done, total int64) {
+			fn(job, kind, done, total)
+		})
+	}
+
+	if job.Ctx == nil {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(job.Ctx, func() {
+		cancel(context.Cause(job.Ctx))
+	})
+
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// Workers keep draining their jobs after the ctx is canceled so that every job
+// still reaches the failed channel, but no further work is started for them.
+//
+// If a state is given then the workers record the progress of each job in it and
+// use its job dirs instead of the workdir, so that the batch can be resumed.
+
 func DownsampleWorker(
 	ctx context.Context,
-	debug bool,
 	workdir string,
+	timeout time.Duration,
+	state *State,
 	jobs <-chan Job,
 	success chan<- Job,
 	failed chan<- Job,
 ) {
 	for job := range jobs {
+		jobCtx, cancel := jobContext(ctx, job)
+		job = downsample(jobCtx, workdir, timeout, state, job)
+		cancel()
+
+		if job.Err != nil {
+			failed <- job
+			continue
+		}
+		success <- job
+	}
+}
 
-		fmt.Printf("downsampling: %v...\n", job.SourceMedia.GetName())
+func downsample(ctx context.Context, workdir string, timeout time.Duration, state *State, job Job) Job {
 
-		targetMedia, err := job.SourceMedia.DownsampleOGG(ctx, debug, avmedia.DownsampleOGGConfig{
-			OutputBasePath: workdir,
+	if err := ctx.Err(); err != nil {
+		job.Err = err
+		return job
+	}
+
+	log := startStep(ctx, job, StepDownsample)
+	log.Info("downsampling")
+	start := time.Now()
+
+	jobdir := workdir
+	if state != nil {
+		dir, err := state.JobDir(job.SourceMedia.GetPath())
+		if err != nil {
+			job.Err = err
+			recordJob(log, state, job)
+			return job
+		}
+		jobdir = dir
+	}
+
+	targetMedia, err := job.SourceMedia.DownsampleOGG(ctx, avmedia.DownsampleOGGConfig{
+		OutputBasePath: jobdir,
+		SizeCap:        transcribe.MaxUploadSize(),
+		Strategy:       avmedia.DownsampleStrategyAutoBest,
+		Timeout:        timeout,
+	})
+
+	// Files which are still too large after downsampling are split into chunks
+	// which are transcribed separately and then stitched back together.
+	var errSizeCap avmedia.ErrSizeCapExceeded
+	if errors.As(err, &errSizeCap) {
+
+		log.Info("splitting", "size", errSizeCap.FileSize, "size_cap", errSizeCap.SizeCap)
+
+		job.Chunks, err = targetMedia.SplitOGG(ctx, avmedia.SplitOGGConfig{
+			OutputBasePath: jobdir,
 			SizeCap:        transcribe.MaxUploadSize(),
-			Strategy:       avmedia.DownsampleStrategyAutoBest,
+			Overlap:        chunkOverlap,
+			Timeout:        timeout,
 		})
 		if err != nil {
-			// TODO: Handle size cap error type and skip instead of exit
-			job.Err = fmt.Errorf("media transform failed: %v", err)
-			failed <- job
-			continue
+			err = fmt.Errorf("split failed: %w", err)
 		}
+	}
 
-		job.TargetMedia = targetMedia
-		success <- job
+	if err != nil {
+		job.Err = fmt.Errorf("media transform failed: %w", err)
+		recordJob(log, state, job)
+		return job
 	}
+
+	log.Info("downsampled", "duration", time.Since(start), "size", targetMedia.GetSize(), "chunks", len(job.Chunks))
+
+	job.TargetMedia = targetMedia
+	job.Stage = StageDownsampled
+	recordJob(log, state, job)
+	return job
 }
 
 func TranscriptionWorker(
 	ctx context.Context,
-	debug bool,
 	workdir string,
+	transcriber transcribe.Transcriber,
+	state *State,
 	jobs <-chan Job,
 	success chan<- Job,
 	failed chan<- Job,
 ) {
 	for job := range jobs {
+		jobCtx, cancel := jobContext(ctx, job)
+		job = transcribeJob(jobCtx, transcriber, state, job)
+		cancel()
 
-		fmt.Printf("transcribing: %v...\n", job.SourceMedia.GetName())
-
-		transcript, err := transcribe.Transcribe(ctx, job.TargetMedia.GetPath())
-		if err != nil {
-			job.Err = fmt.Errorf("transcribe failed: %v", err)
+		if job.Err != nil {
 			failed <- job
 			continue
 		}
+		success <- job
+	}
+}
+
+func transcribeJob(ctx context.Context, transcriber transcribe.Transcriber, state *State, job Job) Job {
+
+	if err := ctx.Err(); err != nil {
+		job.Err = err
+		return job
+	}
+
+	log := startStep(ctx, job, StepTranscribe)
+	log.Info("transcribing")
+	start := time.Now()
 
-		job.Transcript = transcript
+	var transcript transcribe.Transcript
+	var err error
+	if len(job.Chunks) > 0 {
+		transcript, err = transcribeChunks(ctx, transcriber, job.Chunks)
+	} else {
+		transcript, err = transcriber.Transcribe(ctx, job.TargetMedia)
+	}
+	if err != nil {
+		job.Err = fmt.Errorf("transcribe failed: %w", err)
+		recordJob(log, state, job)
+		return job
+	}
+
+	log.Info("transcribed", "duration", time.Since(start))
+
+	job.Transcript = transcript
+	job.Stage = StageTranscribed
+	recordJob(log, state, job)
+	return job
+}
+
+// DiarizeWorker labels the speakers of transcribed jobs. The turns are kept apart
+// from the raw transcript, which is what gets cached and recorded, since they
+// only depend on the audio and are cheap to redo.
+func DiarizeWorker(
+	ctx context.Context,
+	diarizer diarize.Diarizer,
+	state *State,
+	jobs <-chan Job,
+	success chan<- Job,
+	failed chan<- Job,
+) {
+	for job := range jobs {
+		jobCtx, cancel := jobContext(ctx, job)
+		job = diarizeJob(jobCtx, diarizer, state, job)
+		cancel()
+
+		if job.Err != nil {
+			failed <- job
+			continue
+		}
 		success <- job
 	}
 }
+
+func diarizeJob(ctx context.Context, diarizer diarize.Diarizer, state *State, job Job) Job {
+
+	if err := ctx.Err(); err != nil {
+		job.Err = err
+		return job
+	}
+
+	log := startStep(ctx, job, StepDiarize)
+	log.Info("diarizing")
+	start := time.Now()
+
+	speakers, err := diarizer.Diarize(ctx, job.SourceMedia)
+	if err != nil {
+		job.Err = fmt.Errorf("diarize failed: %w", err)
+		recordJob(log, state, job)
+		return job
+	}
+
+	log.Info("diarized", "duration", time.Since(start), "turns", len(speakers))
+
+	job.Speakers = speakers
+	return job
+}
+
+// recordJob saves the job progress if a state is in use. A failure to record is
+// logged but does not fail the job, it only means the job cannot be resumed.
+func recordJob(log *slog.Logger, state *State, job Job) {
+	if state == nil {
+		return
+	}
+	if err := state.Record(job); err != nil {
+		log.Warn("failed to record job state", "err", err)
+	}
+}
+
+// transcribeChunks transcribes the chunks concurrently and stitches the results
+// back into a single transcript. If any chunk fails then the first error is
+// returned since a partial transcript would have gaps.
+func transcribeChunks(ctx context.Context, transcriber transcribe.Transcriber, chunks []avmedia.Chunk) (transcribe.Transcript, error) {
+
+	results := make([]transcribe.ChunkTranscript, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, chunkConcurrency)
+	uploads := newChunkProgress(ctx, chunks)
+
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk avmedia.Chunk) {
+			defer wg.Done()
+
+			// Chunks which are still waiting for a slot once the ctx is canceled
+			// are not started.
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = fmt.Errorf("chunk %v: %w", i+1, ctx.Err())
+				return
+			}
+			defer func() { <-sem }()
+
+			transcript, err := transcriber.Transcribe(uploads.context(i), chunk.Media)
+			if err != nil {
+				errs[i] = fmt.Errorf("chunk %v: %w", i+1, err)
+				return
+			}
+
+			results[i] = transcribe.ChunkTranscript{
+				Transcript: transcript,
+				Offset:     chunk.Offset.Seconds(),
+				Duration:   chunk.Media.GetDuration().Seconds(),
+			}
+
+			logging.FromContext(ctx).Debug("chunk transcribed", "file", chunk.Media.GetName(), "offset", chunk.Offset)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return transcribe.Transcript{}, err
+		}
+	}
+
+	return transcribe.Stitch(results), nil
+}
+
+// chunkProgress sums up the uploads of the chunks of a job, so that they are
+// reported as the upload of the job as a whole.
+type chunkProgress struct {
+	ctx   context.Context
+	mu    sync.Mutex
+	done  []int64
+	total []int64 // the size of the chunk until the upload reports its own total
+}
+
+func newChunkProgress(ctx context.Context, chunks []avmedia.Chunk) *chunkProgress {
+	p := &chunkProgress{
+		ctx:   ctx,
+		done:  make([]int64, len(chunks)),
+		total: make([]int64, len(chunks)),
+	}
+	for i, chunk := range chunks {
+		p.total[i] = chunk.Media.GetSize()
+	}
+	return p
+}
+
+// context returns the ctx for the transcription of chunk i.
+func (p *chunkProgress) context(i int) context.Context {
+	if !progress.Enabled(p.ctx) {
+		return p.ctx
+	}
+
+	return progress.WithFunc(p.ctx, func(kind progress.Kind, done, total int64) {
+		if kind != progress.Upload {
+			progress.Report(p.ctx, kind, done, total)
+			return
+		}
+
+		p.mu.Lock()
+		p.done[i] = done
+		if total > 0 {
+			p.total[i] = total
+		}
+		var sumDone, sumTotal int64
+		for j := range p.done {
+			sumDone += p.done[j]
+			sumTotal += p.total[j]
+		}
+		p.mu.Unlock()
+
+		progress.Report(p.ctx, progress.Upload, sumDone, sumTotal)
+	})
+}
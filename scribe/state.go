@@ -0,0 +1,374 @@
+package scribe
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spiritorai/spiritor/avmedia"
+)
+
+// Stage is the last completed stage of a job.
+type Stage string
+
+const (
+	StageQueued      Stage = "queued"      // nothing done yet
+	StageDownsampled Stage = "downsampled" // target media (and chunks) are ready
+	StageTranscribed Stage = "transcribed" // raw transcript is saved
+	StageCompleted   Stage = "completed"   // outputs are written and artifacts removed
+)
+
+const (
+	manifestVersion  = 1
+	manifestFileName = "manifest.json"
+	lockFileName     = "lock"
+	artifactsDirName = "artifacts"
+)
+
+// errStateLocked is returned by OpenState if another process has the state dir
+// open, since their writes to the manifest would overwrite each other.
+var errStateLocked = errors.New("in use by another process")
+
+// JobRecord is the persisted state of a single job. If Err is set then the job
+// failed while working on the stage after Stage.
+type JobRecord struct {
+	Source     string        `json:"source"`               // absolute source media path
+	Stage      Stage         `json:"stage"`                // last completed stage
+	Target     string        `json:"target,omitempty"`     // downsampled media path
+	Chunks     []ChunkRecord `json:"chunks,omitempty"`     // chunk media paths if the target was split
+	Transcript string        `json:"transcript,omitempty"` // raw transcript json path
+	Outputs    []string      `json:"outputs,omitempty"`    // output files written on completion
+	Err        string        `json:"error,omitempty"`      // last error
+	UpdatedAt  time.Time     `json:"updated_at"`
+}
+
+type ChunkRecord struct {
+	Path   string        `json:"path"`
+	Offset time.Duration `json:"offset"`
+}
+
+// Status is a short summary of the record, eg: failed after downsampled
+func (r JobRecord) Status() string {
+	if r.Err != "" {
+		return fmt.Sprintf("failed after %v", r.Stage)
+	}
+	return string(r.Stage)
+}
+
+type manifest struct {
+	Version int                   `json:"version"`
+	Jobs    map[string]*JobRecord `json:"jobs"`
+}
+
+// State is the journal of the scribe jobs run from a directory. It records the
+// stage and the artifacts of every job in a manifest file so that an interrupted
+// batch can be resumed from the last completed stage of each job. All methods
+// are safe for concurrent use by the workers. The state dir is locked while it
+// is open, so that only a single process at a time writes to it.
+type State struct {
+	dir      string
+	lock     *os.File
+	mu       sync.Mutex
+	manifest manifest
+}
+
+// OpenState locks the state dir and loads the state from it, or creates a new
+// empty state there. It fails right away if the dir is locked by another
+// process. The state must be closed to release the lock.
+func OpenState(dir string) (*State, error) {
+
+	state := &State{dir: dir}
+
+	if err := os.MkdirAll(state.ArtifactsDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %w", err)
+	}
+
+	lock, err := lockFile(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock state dir %v: %w", dir, err)
+	}
+	state.lock = lock
+
+	if state.manifest, err = readManifest(state.manifestPath()); err != nil {
+		state.Close()
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// ReadRecords returns the records of the state in dir sorted by source path,
+// without locking it, eg: to list the jobs while a batch is running.
+func ReadRecords(dir string) ([]JobRecord, error) {
+	m, err := readManifest(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	return sortedRecords(m), nil
+}
+
+// Close releases the lock of the state dir.
+func (s *State) Close() error {
+	return unlockFile(s.lock)
+}
+
+// readManifest reads the manifest file, which is empty if it does not exist yet.
+func readManifest(path string) (manifest, error) {
+
+	m := manifest{
+		Version: manifestVersion,
+		Jobs:    map[string]*JobRecord{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("failed to parse manifest %v: %w", path, err)
+	}
+
+	if m.Version != manifestVersion {
+		return m, fmt.Errorf("unsupported manifest version: %v", m.Version)
+	}
+
+	if m.Jobs == nil {
+		m.Jobs = map[string]*JobRecord{}
+	}
+
+	return m, nil
+}
+
+func (s *State) manifestPath() string {
+	return filepath.Join(s.dir, manifestFileName)
+}
+
+// ArtifactsDir is the parent dir of the job dirs.
+func (s *State) ArtifactsDir() string {
+	return filepath.Join(s.dir, artifactsDirName)
+}
+
+// JobDir returns the workdir for the intermediate files of a single job, eg: the
+// downsampled media, creating it if needed. Each job has its own dir so that
+// sources with the same file name do not collide.
+func (s *State) JobDir(source string) (string, error) {
+	dir := filepath.Join(s.ArtifactsDir(), jobID(source))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create job dir: %w", err)
+	}
+	return dir, nil
+}
+
+// Lookup returns a copy of the record for the source media path.
+func (s *State) Lookup(source string) (JobRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.manifest.Jobs[source]
+	if !ok {
+		return JobRecord{}, false
+	}
+	return *record, true
+}
+
+// Records returns copies of all records sorted by source path.
+func (s *State) Records() []JobRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedRecords(s.manifest)
+}
+
+func sortedRecords(m manifest) []JobRecord {
+	records := make([]JobRecord, 0, len(m.Jobs))
+	for _, record := range m.Jobs {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Source < records[j].Source
+	})
+	return records
+}
+
+// Queue resets the record for the source media and removes any artifacts left
+// over from a previous run.
+func (s *State) Queue(source string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeArtifacts(source)
+
+	s.manifest.Jobs[source] = &JobRecord{
+		Source:    source,
+		Stage:     StageQueued,
+		UpdatedAt: time.Now(),
+	}
+
+	return s.save()
+}
+
+// Record saves the progress of the job. If the job has reached the transcribed
+// stage then the raw transcript is saved as an artifact as well.
+func (s *State) Record(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source := job.SourceMedia.GetPath()
+	record, ok := s.manifest.Jobs[source]
+	if !ok {
+		record = &JobRecord{Source: source}
+		s.manifest.Jobs[source] = record
+	}
+
+	record.Stage = job.Stage
+	record.UpdatedAt = time.Now()
+	record.Err = ""
+	if job.Err != nil {
+		record.Err = job.Err.Error()
+	}
+
+	if job.Stage == StageDownsampled {
+		record.Target = job.TargetMedia.GetPath()
+		record.Chunks = nil
+		for _, chunk := range job.Chunks {
+			record.Chunks = append(record.Chunks, ChunkRecord{
+				Path:   chunk.Media.GetPath(),
+				Offset: chunk.Offset,
+			})
+		}
+	}
+
+	if job.Stage == StageTranscribed && job.Err == nil {
+		record.Transcript = filepath.Join(s.ArtifactsDir(), jobID(source), "transcript.json")
+		data, err := json.Marshal(job.Transcript)
+		if err != nil {
+			return fmt.Errorf("failed to marshal transcript: %w", err)
+		}
+		if err := os.WriteFile(record.Transcript, data, 0644); err != nil {
+			return fmt.Errorf("failed to write transcript artifact: %w", err)
+		}
+	}
+
+	return s.save()
+}
+
+// Complete marks the job as completed with the written outputs and removes its
+// artifacts since they are no longer needed to resume.
+func (s *State) Complete(source string, outputs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.manifest.Jobs[source]
+	if !ok {
+		record = &JobRecord{Source: source}
+		s.manifest.Jobs[source] = record
+	}
+
+	s.removeArtifacts(source)
+
+	*record = JobRecord{
+		Source:    source,
+		Stage:     StageCompleted,
+		Outputs:   outputs,
+		UpdatedAt: time.Now(),
+	}
+
+	return s.save()
+}
+
+// Restore rebuilds a job from the record so that it can continue after its last
+// completed stage. If the artifacts of that stage are missing then the job is
+// restored at an earlier stage instead.
+func (s *State) Restore(ctx context.Context, sourceMedia avmedia.Media) Job {
+
+	job := Job{SourceMedia: sourceMedia, Stage: StageQueued}
+
+	record, ok := s.Lookup(sourceMedia.GetPath())
+	if !ok {
+		return job
+	}
+
+	if record.Stage == StageTranscribed && record.Transcript != "" {
+		if data, err := os.ReadFile(record.Transcript); err == nil {
+			if err := json.Unmarshal(data, &job.Transcript); err == nil {
+				job.Stage = StageTranscribed
+				return job
+			}
+		}
+	}
+
+	if (record.Stage == StageDownsampled || record.Stage == StageTranscribed) && record.Target != "" {
+		targetMedia, err := avmedia.NewMedia(ctx, record.Target)
+		if err != nil {
+			return job
+		}
+
+		var chunks []avmedia.Chunk
+		for _, chunk := range record.Chunks {
+			chunkMedia, err := avmedia.NewMedia(ctx, chunk.Path)
+			if err != nil {
+				return job
+			}
+			chunks = append(chunks, avmedia.Chunk{Media: chunkMedia, Offset: chunk.Offset})
+		}
+
+		job.TargetMedia = targetMedia
+		job.Chunks = chunks
+		job.Stage = StageDownsampled
+	}
+
+	return job
+}
+
+// save writes the manifest atomically so that a crash mid write cannot corrupt
+// it. The caller must hold the lock.
+func (s *State) save() error {
+
+	data, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, manifestFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.manifestPath()); err != nil {
+		return fmt.Errorf("failed to replace manifest: %w", err)
+	}
+
+	return nil
+}
+
+// removeArtifacts deletes the job dir of the source, errors are ignored since
+// the dir may already be gone.
+func (s *State) removeArtifacts(source string) {
+	os.RemoveAll(filepath.Join(s.ArtifactsDir(), jobID(source)))
+}
+
+// jobID is a short stable id for the source media path, used for artifact names.
+func jobID(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:6])
+}
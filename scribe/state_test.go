@@ -0,0 +1,66 @@
+package scribe
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenStateLocked(t *testing.T) {
+
+	dir := t.TempDir()
+	state, err := OpenState(dir)
+	if err != nil {
+		t.Fatalf("OpenState() error = %v", err)
+	}
+
+	if _, err := OpenState(dir); !errors.Is(err, errStateLocked) {
+		t.Fatalf("second OpenState() error = %v, want %v", err, errStateLocked)
+	}
+
+	if err := state.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	state, err = OpenState(dir)
+	if err != nil {
+		t.Fatalf("OpenState() after Close error = %v", err)
+	}
+	state.Close()
+}
+
+func TestStateSave(t *testing.T) {
+
+	dir := t.TempDir()
+	state, err := OpenState(dir)
+	if err != nil {
+		t.Fatalf("OpenState() error = %v", err)
+	}
+	defer state.Close()
+
+	for _, source := range []string{"/media/b.mp3", "/media/a.mp3"} {
+		if err := state.Queue(source); err != nil {
+			t.Fatalf("Queue() error = %v", err)
+		}
+	}
+
+	// The records can be read while the state is locked, and no temp files are
+	// left behind by the writes.
+	records, err := ReadRecords(dir)
+	if err != nil {
+		t.Fatalf("ReadRecords() error = %v", err)
+	}
+	if len(records) != 2 || records[0].Source != "/media/a.mp3" || records[1].Stage != StageQueued {
+		t.Errorf("records = %+v, want the 2 queued jobs in order", records)
+	}
+
+	tmps, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil || len(tmps) != 0 {
+		t.Errorf("temp files = %v, want none", tmps)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, manifestFileName)); err != nil {
+		t.Errorf("manifest was not written: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiritorai/spiritor/progress"
+	"github.com/spiritorai/spiritor/scribe"
+)
+
+// The share of a job which is done once each step is over. The transcription
+// only reports its upload, the wait for the engine after it is not measured.
+const (
+	downsampledFraction = 0.3
+	uploadedFraction    = 0.6
+	transcribedFraction = 0.9
+)
+
+// scribeProgress shows the progress of the scribe jobs. On a terminal it is a
+// live block with a line per active job, otherwise a log line is written as
+// each job finishes.
+type scribeProgress struct {
+	display *progress.Display
+	log     *slog.Logger
+	tty     bool
+
+	mu    sync.Mutex
+	tasks map[string]*progress.Task // by source path
+}
+
+func newScribeProgress(display *progress.Display, log *slog.Logger, tty bool) *scribeProgress {
+	return &scribeProgress{
+		display: display,
+		log:     log,
+		tty:     tty,
+		tasks:   make(map[string]*progress.Task),
+	}
+}
+
+// context returns the ctx for the pipeline, which reports the steps of the jobs
+// and, on a terminal, the progress within the steps.
+func (p *scribeProgress) context(ctx context.Context) context.Context {
+	ctx = scribe.WithStepFunc(ctx, p.step)
+	if p.tty {
+		ctx = scribe.WithProgressFunc(ctx, p.progress)
+	}
+	return ctx
+}
+
+// add queues a job, weighted by its duration so that the ETA follows the amount
+// of media rather than the number of files.
+func (p *scribeProgress) add(job scribe.Job) {
+	task := p.display.Add(job.SourceMedia.GetName(), max(job.SourceMedia.GetDuration().Seconds(), 1))
+
+	p.mu.Lock()
+	p.tasks[job.SourceMedia.GetPath()] = task
+	p.mu.Unlock()
+}
+
+// skip shows a file which is skipped as a finished task.
+func (p *scribeProgress) skip(fpath, reason string) {
+	p.display.Add(filepath.Base(fpath), 0).Finish("skipped", reason, false)
+}
+
+func (p *scribeProgress) task(job scribe.Job) *progress.Task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tasks[job.SourceMedia.GetPath()]
+}
+
+func (p *scribeProgress) step(job scribe.Job, step scribe.Step) {
+	task := p.task(job)
+	if task == nil {
+		return
+	}
+
+	switch step {
+	case scribe.StepDownsample:
+		task.Update("downsampling", 0, "")
+	case scribe.StepTranscribe:
+		task.Update("transcribing", downsampledFraction, "")
+	case scribe.StepDiarize:
+		task.Update("diarizing", transcribedFraction, "")
+	}
+}
+
+func (p *scribeProgress) progress(job scribe.Job, kind progress.Kind, done, total int64) {
+	task := p.task(job)
+	if task == nil || total <= 0 {
+		return
+	}
+	fraction := min(float64(done)/float64(total), 1)
+
+	switch kind {
+	case progress.Encode:
+		detail := fmt.Sprintf("%v/%v", formatMediaTime(done), formatMediaTime(total))
+		task.Update("downsampling", fraction*downsampledFraction, detail)
+	case progress.Upload:
+		if fraction < 1 {
+			detail := fmt.Sprintf("%.1f/%.1f MB", float64(done)/1e6, float64(total)/1e6)
+			task.Update("uploading", downsampledFraction+fraction*(uploadedFraction-downsampledFraction), detail)
+			return
+		}
+		task.Update("transcribing", uploadedFraction, "")
+	}
+}
+
+// done finishes the task of a job which came out of the pipeline.
+func (p *scribeProgress) done(job scribe.Job, outputs []string, err error) {
+	if task := p.task(job); task != nil {
+		switch {
+		case errors.Is(err, context.Canceled) || (job.Ctx != nil && job.Ctx.Err() != nil):
+			task.Finish("canceled", "", true)
+		case err != nil:
+			task.Finish("failed", err.Error(), true)
+		default:
+			names := make([]string, len(outputs))
+			for i, output := range outputs {
+				names[i] = filepath.Base(output)
+			}
+			task.Finish("done", strings.Join(names, ", "), false)
+		}
+	}
+
+	if p.tty {
+		return
+	}
+
+	overall := p.display.Overall()
+	p.log.Info("progress",
+		"files", fmt.Sprintf("%d/%d", overall.Finished, overall.Total),
+		"percent", int(overall.Fraction*100),
+		"eta", overall.ETA.Round(time.Second),
+	)
+}
+
+// formatMediaTime formats microseconds of media time as [h:]mm:ss.
+func formatMediaTime(us int64) string {
+	s := us / 1e6
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
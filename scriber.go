@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/config"
+	"github.com/spiritorai/spiritor/diarize"
+	"github.com/spiritorai/spiritor/logging"
+	"github.com/spiritorai/spiritor/scribe"
+	"github.com/spiritorai/spiritor/transcribe"
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// scriber takes source files through the scribe pipeline and writes their
+// outputs next to them. It is shared by the commands which transcribe files, so
+// that a file is handled the same way whether it was given to scribe, found in a
+// watched dir or uploaded to the server.
+type scriber struct {
+	conf         config.Config
+	outputs      []string
+	transcriber  transcribe.Transcriber
+	diarizer     diarize.Diarizer
+	speakerNames map[string]string
+	state        *scribe.State
+	cache        *transcribe.Cache
+	formatOpts   transcribe.FormatOptions
+
+	mu        sync.Mutex
+	cacheKeys map[string]string // cache key by source path, for the jobs in progress
+}
+
+// newScriber validates the config, which must already have the flags applied,
+// and sets up everything which the jobs share.
+func newScriber(conf config.Config, noCache bool) (*scriber, error) {
+
+	outputs := conf.Scribe.Outputs
+	if conf.Clean.Enabled && !slices.Contains(outputs, "clean.txt") {
+		outputs = append(outputs[:len(outputs):len(outputs)], "clean.txt")
+	}
+
+	if !transcribe.EngineAllowed(conf.Scribe.Engine) {
+		return nil, fmt.Errorf("unsupported engine: %v: available engines: %v", conf.Scribe.Engine, strings.Join(transcribe.Engines(), ", "))
+	}
+
+	if !transcribe.LanguageAllowed(conf.Scribe.Language) {
+		return nil, fmt.Errorf("unsupported language: %v: use auto or one of: %v", conf.Scribe.Language, strings.Join(transcribe.Languages(), ", "))
+	}
+
+	if conf.Scribe.DownsampleWorkers < 1 || conf.Scribe.TranscriptionWorkers < 1 {
+		return nil, fmt.Errorf("worker counts must be greater than 0")
+	}
+
+	// Quit now if any unsupported output formats have been given
+	for _, output := range outputs {
+		if !transcribe.OutputAllowed(output) {
+			return nil, fmt.Errorf("unsupported output: %v", output)
+		}
+	}
+
+	s := &scriber{
+		conf:      conf,
+		outputs:   outputs,
+		cacheKeys: map[string]string{},
+	}
+
+	var err error
+	if s.transcriber, err = newTranscriber(conf); err != nil {
+		return nil, err
+	}
+
+	if conf.Diarize.Enabled {
+		s.diarizer, err = diarize.New(conf.Diarize.Engine, diarize.Config{
+			Speakers:    conf.Diarize.Speakers,
+			MaxSpeakers: conf.Diarize.MaxSpeakers,
+			Timeout:     seconds(conf.Timeouts.Diarize),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%v: available engines: %v", err, strings.Join(diarize.Engines(), ", "))
+		}
+
+		if conf.Diarize.Names != "" {
+			if s.speakerNames, err = diarize.LoadSpeakerNames(conf.Diarize.Names); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The cache is keyed by the source audio rather than the downsampled target,
+	// so a hit skips the downsampling as well.
+	if !noCache {
+		cacheDir := conf.Scribe.CacheDir
+		if cacheDir == "" {
+			if cacheDir, err = transcribe.CacheDir(); err != nil {
+				return nil, err
+			}
+		}
+		if s.cache, err = transcribe.NewCache(cacheDir); err != nil {
+			return nil, err
+		}
+	}
+
+	// The state holds the intermediate files of every job so that an interrupted
+	// batch can be resumed, they are only removed once the job has completed. The
+	// temp dirs used while downsampling are still removed on interrupt, since the
+	// root ctx is canceled instead of the process exiting and every job is drained
+	// before returning. It is opened last, since it holds a lock which must be
+	// released by close.
+	if s.state, err = scribe.OpenState(conf.Scribe.StateDir); err != nil {
+		return nil, fmt.Errorf("failed to open job state: %v", err)
+	}
+
+	s.formatOpts = transcribe.FormatOptions{
+		Subtitles: transcribe.SubtitleOptions{
+			MaxLineLength:  conf.Subtitles.MaxLineLength,
+			MaxLines:       conf.Subtitles.MaxLines,
+			MaxCueDuration: seconds(conf.Subtitles.MaxCueDuration),
+		},
+		Paragraphs: transcribe.ParagraphOptions{
+			MaxSentences: conf.Paragraphs.MaxSentences,
+			PauseGap:     seconds(conf.Paragraphs.PauseGap),
+		},
+		Document: transcribe.DocumentOptions{
+			HeadingInterval: seconds(conf.Document.HeadingMinutes * 60),
+		},
+	}
+	if conf.Clean.Enabled || slices.Contains(outputs, "clean.txt") {
+		s.formatOpts.Clean = &transcribe.CleanOptions{
+			Fillers:         conf.Clean.Fillers,
+			KeepRepeats:     conf.Clean.KeepRepeats,
+			KeepFalseStarts: conf.Clean.KeepFalseStarts,
+		}
+	}
+
+	return s, nil
+}
+
+// close releases the job state, so that another process can use it.
+func (s *scriber) close() {
+	s.state.Close()
+}
+
+// skipError is returned by prepare for the files which are skipped, eg:
+// unsupported files or files which already have their outputs. The reason has
+// already been logged.
+type skipError struct {
+	reason string
+}
+
+func (e skipError) Error() string {
+	return "skipped: " + e.reason
+}
+
+func (s *scriber) pipelineConfig() scribe.PipelineConfig {
+	return scribe.PipelineConfig{
+		Workdir:              s.state.ArtifactsDir(),
+		State:                s.state,
+		Transcriber:          s.transcriber,
+		Diarizer:             s.diarizer,
+		DownsampleWorkers:    s.conf.Scribe.DownsampleWorkers,
+		TranscriptionWorkers: s.conf.Scribe.TranscriptionWorkers,
+		DownsampleTimeout:    seconds(s.conf.Timeouts.Downsample),
+	}
+}
+
+// start starts the pipeline, the outputs of every job are written as it comes
+// out and then passed to done, if given, along with the job error.
+func (s *scriber) start(ctx context.Context, done func(job scribe.Job, outputs []string, err error)) *scribe.Pipeline {
+	return scribe.StartPipeline(ctx, s.pipelineConfig(), func(job scribe.Job) {
+		outputs, err := s.finish(ctx, job)
+		if done != nil {
+			done(job, outputs, err)
+		}
+	})
+}
+
+// prepare builds the job for a source file. It returns a skipError for the
+// files which are skipped.
+func (s *scriber) prepare(ctx context.Context, fpath string, resume, force bool) (scribe.Job, error) {
+
+	var job scribe.Job
+	log := logging.FromContext(ctx)
+
+	if err := ctx.Err(); err != nil {
+		return job, fmt.Errorf("interrupted: %v", err)
+	}
+
+	// Files are classified by probing their contents, so globs such as *.* may
+	// include existing transcripts or other files which are skipped here.
+	sourceMedia, err := avmedia.NewMedia(ctx, fpath)
+	var errUnsupported avmedia.ErrUnsupported
+	if errors.As(err, &errUnsupported) {
+		log.Info("skipped: unsupported file", "file", fpath, "reason", errUnsupported.Err)
+		return job, skipError{fmt.Sprintf("unsupported file: %v", errUnsupported.Err)}
+	}
+	if err != nil {
+		return job, fmt.Errorf("new media wrapper failed: %v", err)
+	}
+
+	sourceMedia, err = selectAudioStream(sourceMedia, s.conf.Scribe.AudioStream, s.conf.Scribe.Language)
+	if err != nil {
+		log.Error("skipped: audio stream selection failed", "file", fpath, "err", err)
+		return job, skipError{fmt.Sprintf("audio stream selection failed: %v", err)}
+	}
+
+	// When resuming, jobs continue from their last completed stage and completed
+	// jobs are skipped unless forced. Otherwise test for outputs now so we can
+	// skip early if they already exist. If force flag has been set or at least
+	// one specified output does not exist then do not skip the file.
+	record, ok := s.state.Lookup(sourceMedia.GetPath())
+	resume = resume && ok
+	if resume && record.Stage == scribe.StageCompleted && !force {
+		log.Info("skipped: job already completed", "file", fpath)
+		return job, skipError{"job already completed"}
+	}
+	if resume && record.Stage != scribe.StageCompleted {
+		job = s.state.Restore(ctx, sourceMedia)
+	} else {
+		if !force {
+			if len(probeOutputs(sourceMedia, s.outputs)) == len(s.outputs) {
+				log.Info("skipped: outputs already exist", "file", fpath)
+				return job, skipError{"outputs already exist"}
+			}
+		}
+		if err := s.state.Queue(sourceMedia.GetPath()); err != nil {
+			return job, fmt.Errorf("failed to queue job: %v", err)
+		}
+		job = scribe.Job{SourceMedia: sourceMedia, Stage: scribe.StageQueued}
+	}
+
+	if s.cache != nil {
+		key, err := transcribe.CacheKey(sourceMedia, s.transcriber.Info())
+		if err != nil {
+			return job, fmt.Errorf("cache key failed: %v", err)
+		}
+		s.mu.Lock()
+		s.cacheKeys[sourceMedia.GetPath()] = key
+		s.mu.Unlock()
+
+		if job.Stage != scribe.StageTranscribed {
+			transcript, ok, err := s.cache.Get(key)
+			if err != nil {
+				log.Warn("cache read failed", "file", fpath, "err", err)
+			}
+			if ok {
+				log.Info("using cached transcript", "file", fpath)
+				job.Transcript = transcript
+				job.Stage = scribe.StageTranscribed
+			}
+		}
+	}
+
+	log.Info("processing", "file", fpath, "duration", sourceMedia.GetDuration(), "stage", job.Stage)
+
+	return job, nil
+}
+
+// finish writes the outputs of a job which has come out of the pipeline and
+// records it as completed. It returns the output paths, or the error of the job
+// if it failed.
+func (s *scriber) finish(ctx context.Context, job scribe.Job) ([]string, error) {
+
+	log := logging.FromContext(ctx)
+
+	s.mu.Lock()
+	key, cached := s.cacheKeys[job.SourceMedia.GetPath()]
+	delete(s.cacheKeys, job.SourceMedia.GetPath())
+	s.mu.Unlock()
+
+	if job.Err != nil {
+		canceled := errors.Is(job.Err, context.Canceled) || (job.Ctx != nil && job.Ctx.Err() != nil)
+		if !canceled {
+			log.Error("failed", "file", job.SourceMedia.GetName(), "err", job.Err)
+		}
+		return nil, job.Err
+	}
+
+	if cached {
+		if err := s.cache.Put(key, job.Transcript); err != nil {
+			log.Warn("cache write failed", "file", job.SourceMedia.GetName(), "err", err)
+		}
+	}
+
+	transcript := s.transcript(ctx, job)
+
+	if !utils.SentenceLanguageSupported(transcribe.NormalizeLanguage(transcript.Language)) {
+		log.Debug("no sentence data for the language, splitting by punctuation", "file", job.SourceMedia.GetName(), "language", transcript.Language)
+	}
+
+	var outputPaths []string
+	var outputErr error
+	for _, output := range s.outputs {
+		body, err := s.format(job, transcript, output)
+		if err != nil {
+			log.Error("failed: transcript format error", "file", job.SourceMedia.GetName(), "output", output, "err", err)
+			outputErr = fmt.Errorf("format %v failed: %w", output, err)
+			continue
+		}
+
+		outputPath := buildOutputPath(job.SourceMedia, output)
+		if err := os.WriteFile(outputPath, body, 0666); err != nil {
+			log.Error("failed: file write error", "file", job.SourceMedia.GetName(), "output", output, "err", err)
+			outputErr = fmt.Errorf("write %v failed: %w", output, err)
+			continue
+		}
+
+		outputPaths = append(outputPaths, outputPath)
+		log.Info("succeeded", "file", job.SourceMedia.GetName(), "path", outputPath)
+	}
+
+	// A job with failed outputs keeps its transcript so that --resume only
+	// needs to write the outputs again.
+	if outputErr != nil {
+		job.Err = outputErr
+		if err := s.state.Record(job); err != nil {
+			log.Warn("failed to record job state", "file", job.SourceMedia.GetName(), "err", err)
+		}
+		return outputPaths, outputErr
+	}
+
+	if err := s.state.Complete(job.SourceMedia.GetPath(), outputPaths); err != nil {
+		log.Warn("failed to record job state", "file", job.SourceMedia.GetName(), "err", err)
+	}
+
+	return outputPaths, nil
+}
+
+// transcript returns the transcript of a finished job with the speaker names
+// applied.
+func (s *scriber) transcript(ctx context.Context, job scribe.Job) transcribe.Transcript {
+
+	if len(job.Speakers) == 0 {
+		return job.Transcript
+	}
+
+	names, err := diarize.LoadSpeakerNames(buildOutputPath(job.SourceMedia, speakerNamesExt))
+	if err != nil {
+		logging.FromContext(ctx).Warn("speaker names ignored", "file", job.SourceMedia.GetName(), "err", err)
+	}
+	if names == nil {
+		names = s.speakerNames
+	}
+
+	return job.Transcript.AssignSpeakers(job.Speakers).RenameSpeakers(names)
+}
+
+// format formats the transcript of a job as the output.
+func (s *scriber) format(job scribe.Job, transcript transcribe.Transcript, output string) ([]byte, error) {
+	formatOpts := s.formatOpts
+	formatOpts.SourceMedia = &job.SourceMedia
+	return transcript.Format(output, formatOpts)
+}
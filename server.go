@@ -0,0 +1,731 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiritorai/spiritor/config"
+	"github.com/spiritorai/spiritor/logging"
+	"github.com/spiritorai/spiritor/scribe"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+// jobStatus is the status of a server job. The pipeline steps are reported as
+// the workers start them, and formatting while the outputs are written.
+type jobStatus string
+
+const (
+	statusQueued       jobStatus = "queued"
+	statusDownsampling jobStatus = "downsampling"
+	statusTranscribing jobStatus = "transcribing"
+	statusDiarizing    jobStatus = "diarizing"
+	statusFormatting   jobStatus = "formatting"
+	statusCompleted    jobStatus = "completed"
+	statusFailed       jobStatus = "failed"
+	statusCanceled     jobStatus = "canceled"
+)
+
+var stepStatus = map[scribe.Step]jobStatus{
+	scribe.StepDownsample: statusDownsampling,
+	scribe.StepTranscribe: statusTranscribing,
+	scribe.StepDiarize:    statusDiarizing,
+}
+
+// serverJob is a scribe job which was submitted to the server. The fields after
+// mu are guarded by it.
+type serverJob struct {
+	id      string
+	source  string // absolute source file path
+	upload  bool   // the source was uploaded into the upload dir
+	created time.Time
+	ctx     context.Context // canceled to cancel the job
+	cancel  context.CancelFunc
+
+	mu         sync.Mutex
+	status     jobStatus
+	err        error
+	updated    time.Time
+	duration   time.Duration
+	outputs    []string              // output formats written next to the source
+	job        scribe.Job            // the finished job, to format the transcript on request
+	transcript transcribe.Transcript // set once completed
+}
+
+// jobView is the json representation of a server job.
+type jobView struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path,omitempty"` // only for server-local files
+	Status    jobStatus `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  float64   `json:"duration,omitempty"` // seconds of audio
+	Outputs   []string  `json:"outputs,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (j *serverJob) view() jobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	v := jobView{
+		ID:        j.id,
+		Name:      filepath.Base(j.source),
+		Status:    j.status,
+		Duration:  j.duration.Seconds(),
+		Outputs:   j.outputs,
+		CreatedAt: j.created,
+		UpdatedAt: j.updated,
+	}
+	if !j.upload {
+		v.Path = j.source
+	}
+	if j.err != nil {
+		v.Error = j.err.Error()
+	}
+
+	return v
+}
+
+func (j *serverJob) setStatus(status jobStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	j.updated = time.Now()
+}
+
+// finishedAt returns when the job finished, which is its last update.
+func (j *serverJob) finishedAt() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.updated
+}
+
+// done reports whether the job has finished, successfully or not.
+func (j *serverJob) done() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status == statusCompleted || j.status == statusFailed || j.status == statusCanceled
+}
+
+// pruneInterval is how often the finished jobs are checked against the job TTL.
+const pruneInterval = time.Minute
+
+// server runs scribe jobs which are submitted over http. Jobs wait in a bounded
+// queue until the pipeline takes them, new jobs are refused while it is full.
+// Finished jobs are kept up to serve.max_jobs and for serve.job_ttl, after which
+// they are removed along with their uploads.
+type server struct {
+	ctx         context.Context // root ctx of the jobs
+	conf        config.Serve
+	scriber     *scriber
+	uploadDir   string
+	allowedDirs []string
+
+	queue      chan *serverJob
+	pipeline   *scribe.Pipeline
+	dispatched chan struct{} // closed once the queue has been drained
+
+	mu     sync.Mutex
+	closed bool                  // set once the queue is closed
+	jobs   map[string]*serverJob // by id
+	active map[string]*serverJob // unfinished jobs by source path
+}
+
+// newServer checks the serve config and creates the upload dir.
+func newServer(ctx context.Context, conf config.Config, scriber *scriber) (*server, error) {
+
+	if conf.Serve.QueueSize < 1 {
+		return nil, fmt.Errorf("queue size must be greater than 0")
+	}
+	if conf.Serve.MaxUploadMB < 1 {
+		return nil, fmt.Errorf("max upload size must be greater than 0")
+	}
+	if conf.Serve.MaxJobs < 0 || conf.Serve.JobTTL < 0 {
+		return nil, fmt.Errorf("max jobs and job ttl must not be negative")
+	}
+
+	uploadDir := conf.Serve.UploadDir
+	if uploadDir == "" {
+		uploadDir = filepath.Join(conf.Scribe.StateDir, "uploads")
+	}
+	uploadDir, err := filepath.Abs(uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload dir: %v", err)
+	}
+
+	// Symlinks are resolved on both sides so that a link within an allowed dir
+	// cannot point outside of it.
+	var allowedDirs []string
+	for _, dir := range conf.Serve.AllowedDirs {
+		dir, err := filepath.Abs(dir)
+		if err == nil {
+			dir, err = filepath.EvalSymlinks(dir)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed dir: %v", err)
+		}
+		allowedDirs = append(allowedDirs, dir)
+	}
+
+	return &server{
+		ctx:         ctx,
+		conf:        conf.Serve,
+		scriber:     scriber,
+		uploadDir:   uploadDir,
+		allowedDirs: allowedDirs,
+		queue:       make(chan *serverJob, conf.Serve.QueueSize),
+		dispatched:  make(chan struct{}),
+		jobs:        map[string]*serverJob{},
+		active:      map[string]*serverJob{},
+	}, nil
+}
+
+// start starts the pipeline and the dispatcher, which prepares the queued jobs
+// and submits them one at a time as the workers become free.
+func (s *server) start() {
+
+	ctx := scribe.WithStepFunc(s.ctx, func(job scribe.Job, step scribe.Step) {
+		if j := s.activeJob(job.SourceMedia.GetPath()); j != nil {
+			j.setStatus(stepStatus[step])
+		}
+	})
+
+	s.pipeline = scribe.StartPipeline(ctx, s.scriber.pipelineConfig(), func(job scribe.Job) {
+		j := s.activeJob(job.SourceMedia.GetPath())
+		if j == nil {
+			return
+		}
+		if job.Err == nil {
+			j.setStatus(statusFormatting)
+		}
+
+		outputs, err := s.scriber.finish(s.ctx, job)
+		var transcript transcribe.Transcript
+		if err == nil {
+			transcript = s.scriber.transcript(s.ctx, job)
+		}
+		s.finish(j, job, transcript, outputs, err)
+	})
+
+	go func() {
+		defer close(s.dispatched)
+		for j := range s.queue {
+			job, err := s.scriber.prepare(j.ctx, j.source, false, true)
+			if err == nil {
+				err = j.ctx.Err()
+			}
+			if err != nil {
+				s.finish(j, job, transcribe.Transcript{}, nil, err)
+				continue
+			}
+
+			j.mu.Lock()
+			j.duration = job.SourceMedia.GetDuration()
+			j.mu.Unlock()
+
+			job.Ctx = j.ctx
+			s.pipeline.Submit(job)
+		}
+	}()
+
+	if s.conf.JobTTL > 0 {
+		go func() {
+			ticker := time.NewTicker(pruneInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.ctx.Done():
+					return
+				case now := <-ticker.C:
+					s.prune(now)
+				}
+			}
+		}()
+	}
+}
+
+// close refuses new jobs, waits for the queued jobs to be dispatched and then
+// for the pipeline to drain. Once the root ctx is canceled the remaining jobs
+// fail fast, so close only blocks for the jobs in progress otherwise.
+func (s *server) close() {
+	// Handlers may outlive the http server shutdown, eg: a slow upload, so they
+	// must not send on the queue once it is closed.
+	s.mu.Lock()
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	<-s.dispatched
+	s.pipeline.Close()
+}
+
+func (s *server) activeJob(source string) *serverJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active[source]
+}
+
+// finish records the result of a job, which is canceled rather than failed if
+// its ctx has been canceled, including when the server shuts down.
+func (s *server) finish(j *serverJob, job scribe.Job, transcript transcribe.Transcript, outputs []string, err error) {
+
+	canceled := j.ctx.Err() != nil
+	j.cancel()
+
+	s.mu.Lock()
+	delete(s.active, j.source)
+	s.mu.Unlock()
+
+	j.mu.Lock()
+	j.updated = time.Now()
+	for _, output := range outputs {
+		j.outputs = append(j.outputs, strings.TrimPrefix(output, j.source+"."))
+	}
+
+	switch {
+	case err != nil && canceled:
+		j.status = statusCanceled
+	case err != nil:
+		j.status = statusFailed
+		j.err = err
+	default:
+		j.status = statusCompleted
+		j.job = job
+		j.transcript = transcript
+	}
+	j.mu.Unlock()
+
+	s.prune(time.Now())
+}
+
+// prune removes the finished jobs which are past the job TTL, and then the
+// oldest finished jobs over the max jobs. The uploads of the removed jobs are
+// deleted along with their outputs.
+func (s *server) prune(now time.Time) {
+
+	s.mu.Lock()
+	var finished []*serverJob
+	for _, j := range s.jobs {
+		if j.done() {
+			finished = append(finished, j)
+		}
+	}
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].finishedAt().Before(finished[b].finishedAt())
+	})
+
+	var removed []*serverJob
+	ttl := seconds(s.conf.JobTTL)
+	for i, j := range finished {
+		overLimit := s.conf.MaxJobs > 0 && len(finished)-i > s.conf.MaxJobs
+		expired := ttl > 0 && now.Sub(j.finishedAt()) >= ttl
+		if !overLimit && !expired {
+			continue
+		}
+		delete(s.jobs, j.id)
+		removed = append(removed, j)
+	}
+	s.mu.Unlock()
+
+	log := logging.FromContext(s.ctx)
+	for _, j := range removed {
+		log.Debug("job removed", "id", j.id, "file", j.source)
+		if !j.upload {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Dir(j.source)); err != nil {
+			log.Warn("failed to remove upload", "id", j.id, "file", j.source, "err", err)
+		}
+	}
+}
+
+// submit queues a new job for the source file, it fails if the source already
+// has a job in progress, the queue is full or the server is shutting down.
+func (s *server) submit(source string, upload bool) (*serverJob, int, error) {
+
+	id, err := newServerJobID()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &serverJob{
+		id:      id,
+		source:  source,
+		upload:  upload,
+		created: time.Now(),
+		ctx:     ctx,
+		cancel:  cancel,
+		status:  statusQueued,
+		updated: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		cancel()
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("the server is shutting down")
+	}
+	if _, ok := s.active[source]; ok {
+		cancel()
+		return nil, http.StatusConflict, fmt.Errorf("a job for %v is already in progress", filepath.Base(source))
+	}
+
+	select {
+	case s.queue <- j:
+	default:
+		cancel()
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("the queue is full, try again later")
+	}
+
+	s.jobs[id] = j
+	s.active[source] = j
+
+	return j, http.StatusAccepted, nil
+}
+
+func newServerJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handler routes the requests of the api:
+//
+//	POST /jobs                        upload a file (multipart form field "file") or reference a local file ({"path": "..."})
+//	GET  /jobs                        list the jobs
+//	GET  /jobs/{id}                   job status
+//	POST /jobs/{id}/cancel            cancel a queued or running job
+//	GET  /jobs/{id}/outputs/{format}  transcript in any supported output format, eg: txt, srt, json
+func (s *server) handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleListJobs(w, r)
+		case http.MethodPost:
+			s.handleCreateJob(w, r)
+		default:
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	})
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+
+		s.mu.Lock()
+		j, ok := s.jobs[parts[0]]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+			return
+		}
+
+		switch {
+		case len(parts) == 1:
+			if r.Method != http.MethodGet {
+				writeMethodNotAllowed(w, http.MethodGet)
+				return
+			}
+			writeJSON(w, http.StatusOK, j.view())
+
+		case len(parts) == 2 && parts[1] == "cancel":
+			if r.Method != http.MethodPost {
+				writeMethodNotAllowed(w, http.MethodPost)
+				return
+			}
+			s.handleCancelJob(w, r, j)
+
+		case len(parts) == 3 && parts[1] == "outputs":
+			if r.Method != http.MethodGet {
+				writeMethodNotAllowed(w, http.MethodGet)
+				return
+			}
+			s.handleGetOutput(w, r, j, parts[2])
+
+		default:
+			writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		}
+	})
+
+	return s.authorize(mux)
+}
+
+// authorize requires the bearer token for every request if one is configured.
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Debug("request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+
+		if s.conf.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid token"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	jobs := make([]*serverJob, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].created.Before(jobs[b].created)
+	})
+
+	views := make([]jobView, 0, len(jobs))
+	for _, j := range jobs {
+		views = append(views, j.view())
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var source string
+	var upload bool
+	var err error
+	status := http.StatusBadRequest
+
+	switch mediaType {
+	case "multipart/form-data":
+		// Refuse early rather than after the upload if the queue is already full.
+		if len(s.queue) == cap(s.queue) {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("the queue is full, try again later"))
+			return
+		}
+		source, status, err = s.saveUpload(w, r)
+		upload = true
+
+	case "application/json":
+		var body struct {
+			Path string `json:"path"`
+		}
+		if err = json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			err = fmt.Errorf("invalid json: %v", err)
+			break
+		}
+		source, status, err = s.localSource(body.Path)
+
+	default:
+		status = http.StatusUnsupportedMediaType
+		err = fmt.Errorf("expected a multipart/form-data upload or an application/json body")
+	}
+
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	j, status, err := s.submit(source, upload)
+	if err != nil {
+		if upload {
+			os.RemoveAll(filepath.Dir(source))
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("job queued", "id", j.id, "file", source)
+
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, status, j.view())
+}
+
+// saveUpload streams the file of a multipart upload into its own dir within the
+// upload dir, so that its outputs are written next to it without clashing with
+// the uploads of the same name.
+func (s *server) saveUpload(w http.ResponseWriter, r *http.Request) (string, int, error) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.conf.MaxUploadMB)<<20)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", http.StatusBadRequest, fmt.Errorf("missing file field")
+		}
+		if err != nil {
+			return "", uploadErrorStatus(err), fmt.Errorf("failed to read upload: %v", err)
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		name := filepath.Base(part.FileName())
+		if name == "." || name == string(filepath.Separator) || strings.HasPrefix(name, ".") {
+			return "", http.StatusBadRequest, fmt.Errorf("invalid file name: %q", part.FileName())
+		}
+
+		dir, err := os.MkdirTemp(s.uploadDir, "")
+		if err != nil {
+			return "", http.StatusInternalServerError, fmt.Errorf("failed to create upload dir: %v", err)
+		}
+		path := filepath.Join(dir, name)
+
+		f, err := os.Create(path)
+		if err == nil {
+			_, err = io.Copy(f, part)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", uploadErrorStatus(err), fmt.Errorf("failed to save upload: %v", err)
+		}
+
+		return path, http.StatusOK, nil
+	}
+}
+
+func uploadErrorStatus(err error) int {
+	var errMaxBytes *http.MaxBytesError
+	if errors.As(err, &errMaxBytes) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// localSource resolves the path of a server-local file, which must be within
+// one of the allowed dirs.
+func (s *server) localSource(path string) (string, int, error) {
+
+	if len(s.allowedDirs) == 0 {
+		return "", http.StatusForbidden, fmt.Errorf("server-local files are not allowed, see serve.allowed_dirs")
+	}
+	if !filepath.IsAbs(path) {
+		return "", http.StatusBadRequest, fmt.Errorf("path must be absolute: %q", path)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", http.StatusNotFound, fmt.Errorf("file not found: %v", path)
+	}
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+
+	allowed := false
+	for _, dir := range s.allowedDirs {
+		if rel, err := filepath.Rel(dir, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", http.StatusForbidden, fmt.Errorf("path is not within an allowed dir: %v", path)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	if !info.Mode().IsRegular() {
+		return "", http.StatusBadRequest, fmt.Errorf("not a file: %v", path)
+	}
+
+	return resolved, http.StatusOK, nil
+}
+
+func (s *server) handleCancelJob(w http.ResponseWriter, r *http.Request, j *serverJob) {
+
+	if j.done() {
+		writeError(w, http.StatusConflict, fmt.Errorf("job has already finished"))
+		return
+	}
+
+	// The job shows as canceled once the worker which has it stops, or when the
+	// dispatcher reaches it if it is still queued.
+	j.cancel()
+	logging.FromContext(r.Context()).Info("job canceled", "id", j.id, "file", j.source)
+
+	writeJSON(w, http.StatusAccepted, j.view())
+}
+
+func (s *server) handleGetOutput(w http.ResponseWriter, r *http.Request, j *serverJob, output string) {
+
+	if !transcribe.OutputAllowed(output) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unsupported output: %v", output))
+		return
+	}
+
+	j.mu.Lock()
+	status, job, transcript := j.status, j.job, j.transcript
+	j.mu.Unlock()
+
+	if status != statusCompleted {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %v", status))
+		return
+	}
+
+	body, err := s.scriber.format(job, transcript, output)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("format %v failed: %v", output, err))
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext("." + output))
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+		"filename": fmt.Sprintf("%v.%v", filepath.Base(j.source), output),
+	}))
+	w.Write(body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
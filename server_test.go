@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiritorai/spiritor/config"
+	"github.com/spiritorai/spiritor/scribe"
+	"github.com/spiritorai/spiritor/transcribe"
+)
+
+// newTestServer returns a server which is not started, so that the submitted
+// jobs stay queued until the test finishes them.
+func newTestServer(t *testing.T, update func(conf *config.Config)) *server {
+	t.Helper()
+
+	conf := config.Defaults()
+	conf.Scribe.StateDir = t.TempDir()
+	conf.Serve.UploadDir = t.TempDir()
+	if update != nil {
+		update(&conf)
+	}
+
+	s, err := newServer(context.Background(), conf, &scriber{conf: conf, outputs: conf.Scribe.Outputs})
+	if err != nil {
+		t.Fatalf("newServer failed: %v", err)
+	}
+	return s
+}
+
+func TestNewServerLimits(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		update func(conf *config.Serve)
+	}{
+		{"queue size", func(conf *config.Serve) { conf.QueueSize = 0 }},
+		{"max upload", func(conf *config.Serve) { conf.MaxUploadMB = 0 }},
+		{"max jobs", func(conf *config.Serve) { conf.MaxJobs = -1 }},
+		{"job ttl", func(conf *config.Serve) { conf.JobTTL = -1 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := config.Defaults()
+			conf.Scribe.StateDir = t.TempDir()
+			tt.update(&conf.Serve)
+			if _, err := newServer(context.Background(), conf, &scriber{conf: conf}); err == nil {
+				t.Errorf("newServer() error = nil, want error")
+			}
+		})
+	}
+}
+
+// writeTestFile writes a file and returns its path with the symlinks resolved,
+// as the server reports it.
+func writeTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte("audio"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolved
+}
+
+func serve(s *server, method, path string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, body)
+	for key, value := range header {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, r)
+	return w
+}
+
+func submitPath(s *server, path string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"path": path})
+	return serve(s, http.MethodPost, "/jobs", bytes.NewReader(body), map[string]string{"Content-Type": "application/json"})
+}
+
+func decodeView(t *testing.T, w *httptest.ResponseRecorder) jobView {
+	t.Helper()
+
+	var v jobView
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("invalid job json: %v: %s", err, w.Body)
+	}
+	return v
+}
+
+func TestServerToken(t *testing.T) {
+
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Serve.Token = "secret"
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong", "Bearer wrong", http.StatusUnauthorized},
+		{"not bearer", "secret", http.StatusUnauthorized},
+		{"valid", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s, http.MethodGet, "/jobs", nil, map[string]string{"Authorization": tt.header})
+			if w.Code != tt.want {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestServerLocalSource(t *testing.T) {
+
+	root := t.TempDir()
+	allowed := filepath.Join(root, "allowed")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{allowed, outside} {
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inside := writeTestFile(t, filepath.Join(allowed, "talk.mp3"))
+	secret := writeTestFile(t, filepath.Join(outside, "secret.mp3"))
+	if err := os.Symlink(secret, filepath.Join(allowed, "link.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(allowed, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(inside, filepath.Join(outside, "back.mp3")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Serve.AllowedDirs = []string{allowed}
+	})
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"inside", filepath.Join(allowed, "talk.mp3"), http.StatusOK},
+		{"link from outside to inside", filepath.Join(outside, "back.mp3"), http.StatusOK},
+		{"dot dot", filepath.Join(allowed, "..", "outside", "secret.mp3"), http.StatusForbidden},
+		{"unclean dot dot", allowed + "/../outside/secret.mp3", http.StatusForbidden},
+		{"link to outside", filepath.Join(allowed, "link.mp3"), http.StatusForbidden},
+		{"link dir to outside", filepath.Join(allowed, "linkdir", "secret.mp3"), http.StatusForbidden},
+		{"relative", "talk.mp3", http.StatusBadRequest},
+		{"missing", filepath.Join(allowed, "missing.mp3"), http.StatusNotFound},
+		{"dir", allowed, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, status, err := s.localSource(tt.path)
+			if status != tt.want {
+				t.Fatalf("status = %v, want %v: %v", status, tt.want, err)
+			}
+			if status == http.StatusOK && source != inside {
+				t.Errorf("source = %v, want %v", source, inside)
+			}
+		})
+	}
+
+	// Without allowed dirs no local file may be referenced.
+	s = newTestServer(t, nil)
+	if w := submitPath(s, inside); w.Code != http.StatusForbidden {
+		t.Errorf("status without allowed dirs = %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
+
+// multipartUpload returns the body and content type of an upload of size bytes.
+func multipartUpload(t *testing.T, name string, size int) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte("a"), size))
+	mw.Close()
+
+	return &body, mw.FormDataContentType()
+}
+
+func TestServerUpload(t *testing.T) {
+
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Serve.MaxUploadMB = 1
+	})
+
+	body, contentType := multipartUpload(t, "talk.mp3", 1000)
+	w := serve(s, http.MethodPost, "/jobs", body, map[string]string{"Content-Type": contentType})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	v := decodeView(t, w)
+	if v.Name != "talk.mp3" || v.Path != "" || v.Status != statusQueued {
+		t.Errorf("job = %+v, want a queued talk.mp3 without its server path", v)
+	}
+	if w.Header().Get("Location") != "/jobs/"+v.ID {
+		t.Errorf("location = %v, want /jobs/%v", w.Header().Get("Location"), v.ID)
+	}
+	if data, err := os.ReadFile(s.jobs[v.ID].source); err != nil || len(data) != 1000 {
+		t.Errorf("upload not saved: %v bytes: %v", len(data), err)
+	}
+
+	// Uploads over the max are refused and nothing of them is kept.
+	body, contentType = multipartUpload(t, "long.mp3", 2<<20)
+	w = serve(s, http.MethodPost, "/jobs", body, map[string]string{"Content-Type": contentType})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %v, want %v: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body)
+	}
+	if entries, _ := os.ReadDir(s.uploadDir); len(entries) != 1 {
+		t.Errorf("upload dir has %v entries, want only the first upload", len(entries))
+	}
+
+	w = serve(s, http.MethodPost, "/jobs", strings.NewReader("audio"), map[string]string{"Content-Type": "audio/mpeg"})
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestServerQueue(t *testing.T) {
+
+	dir := t.TempDir()
+	first := writeTestFile(t, filepath.Join(dir, "first.mp3"))
+	second := writeTestFile(t, filepath.Join(dir, "second.mp3"))
+
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Serve.AllowedDirs = []string{dir}
+		conf.Serve.QueueSize = 1
+	})
+
+	if w := submitPath(s, first); w.Code != http.StatusAccepted {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	if w := submitPath(s, first); w.Code != http.StatusConflict {
+		t.Errorf("duplicate status = %v, want %v", w.Code, http.StatusConflict)
+	}
+	if w := submitPath(s, second); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("full queue status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+
+	body, contentType := multipartUpload(t, "third.mp3", 10)
+	if w := serve(s, http.MethodPost, "/jobs", body, map[string]string{"Content-Type": contentType}); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("full queue upload status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServerClosed(t *testing.T) {
+
+	dir := t.TempDir()
+	path := writeTestFile(t, filepath.Join(dir, "late.mp3"))
+
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Serve.AllowedDirs = []string{dir}
+	})
+
+	// The queue is closed as by close, which needs a started server.
+	s.mu.Lock()
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	if w := submitPath(s, path); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, want %v: %s", w.Code, http.StatusServiceUnavailable, w.Body)
+	}
+	if len(s.jobs) != 0 {
+		t.Errorf("jobs = %v, want none", s.jobs)
+	}
+}
+
+func TestServerCancelAndOutputs(t *testing.T) {
+
+	dir := t.TempDir()
+	canceled := writeTestFile(t, filepath.Join(dir, "canceled.mp3"))
+	completed := writeTestFile(t, filepath.Join(dir, "completed.mp3"))
+
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Serve.AllowedDirs = []string{dir}
+	})
+
+	// A queued job is canceled once the dispatcher reaches it.
+	id := decodeView(t, submitPath(s, canceled)).ID
+	if w := serve(s, http.MethodPost, "/jobs/"+id+"/cancel", nil, nil); w.Code != http.StatusAccepted {
+		t.Fatalf("cancel status = %v, want %v: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	j := s.jobs[id]
+	if j.ctx.Err() == nil {
+		t.Fatalf("job ctx was not canceled")
+	}
+	s.finish(j, scribe.Job{}, transcribe.Transcript{}, nil, j.ctx.Err())
+
+	if v := decodeView(t, serve(s, http.MethodGet, "/jobs/"+id, nil, nil)); v.Status != statusCanceled {
+		t.Errorf("status = %v, want %v", v.Status, statusCanceled)
+	}
+	if w := serve(s, http.MethodPost, "/jobs/"+id+"/cancel", nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("cancel of a finished job status = %v, want %v", w.Code, http.StatusConflict)
+	}
+	if w := serve(s, http.MethodGet, "/jobs/"+id+"/outputs/txt", nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("outputs of a canceled job status = %v, want %v", w.Code, http.StatusConflict)
+	}
+	if w := serve(s, http.MethodGet, "/jobs/"+id+"/cancel", nil, nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("wrong method status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	// Any output can be fetched once a job has completed, not only the ones
+	// which were written.
+	id = decodeView(t, submitPath(s, completed)).ID
+	transcript := transcribe.Transcript{
+		Text:     "Hello there.",
+		Duration: 1.5,
+		Segments: []transcribe.Segment{{Start: 0, End: 1.5, Text: " Hello there."}},
+		Words: []transcribe.Word{
+			{Word: "Hello", Start: 0, End: 0.5},
+			{Word: "there", Start: 0.6, End: 1.5},
+		},
+	}
+	s.finish(s.jobs[id], scribe.Job{Transcript: transcript}, transcript, []string{completed + ".txt"}, nil)
+
+	v := decodeView(t, serve(s, http.MethodGet, "/jobs/"+id, nil, nil))
+	if v.Status != statusCompleted || len(v.Outputs) != 1 || v.Outputs[0] != "txt" {
+		t.Errorf("job = %+v, want completed with the txt output", v)
+	}
+
+	w := serve(s, http.MethodGet, "/jobs/"+id+"/outputs/srt", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("srt status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if want := "00:00:00,000 --> 00:00:01,500\nHello there."; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("srt = %q, want it to contain %q", w.Body, want)
+	}
+	if want := `inline; filename=completed.mp3.srt`; w.Header().Get("Content-Disposition") != want {
+		t.Errorf("content disposition = %v, want %v", w.Header().Get("Content-Disposition"), want)
+	}
+
+	w = serve(s, http.MethodGet, "/jobs/"+id+"/outputs/json", nil, nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("json status, type = %v, %v, want 200 and application/json", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	if w := serve(s, http.MethodGet, "/jobs/"+id+"/outputs/docx", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unsupported output status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+	if w := serve(s, http.MethodGet, "/jobs/missing", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing job status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestServerPrune(t *testing.T) {
+
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Serve.MaxJobs = 1
+		conf.Serve.JobTTL = 60
+	})
+
+	upload := func() *serverJob {
+		body, contentType := multipartUpload(t, "talk.mp3", 10)
+		w := serve(s, http.MethodPost, "/jobs", body, map[string]string{"Content-Type": contentType})
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusAccepted, w.Body)
+		}
+		return s.jobs[decodeView(t, w).ID]
+	}
+
+	first, second, queued := upload(), upload(), upload()
+
+	// Only the latest finished job is kept over the max jobs, the queued one
+	// is never removed.
+	s.finish(first, scribe.Job{}, transcribe.Transcript{}, nil, context.Canceled)
+	s.finish(second, scribe.Job{}, transcribe.Transcript{}, nil, context.Canceled)
+
+	if _, ok := s.jobs[first.id]; ok {
+		t.Errorf("oldest finished job was kept over the max jobs")
+	}
+	if _, err := os.Stat(filepath.Dir(first.source)); !os.IsNotExist(err) {
+		t.Errorf("upload of the removed job was kept: %v", err)
+	}
+	if _, ok := s.jobs[second.id]; !ok {
+		t.Errorf("latest finished job was removed")
+	}
+
+	// Finished jobs are removed once they are past the TTL.
+	s.prune(time.Now().Add(2 * time.Minute))
+	if _, ok := s.jobs[second.id]; ok {
+		t.Errorf("finished job was kept past the TTL")
+	}
+	if _, err := os.Stat(filepath.Dir(second.source)); !os.IsNotExist(err) {
+		t.Errorf("upload of the expired job was kept: %v", err)
+	}
+	if _, ok := s.jobs[queued.id]; !ok {
+		t.Errorf("queued job was removed")
+	}
+	if _, err := os.Stat(queued.source); err != nil {
+		t.Errorf("upload of the queued job was removed: %v", err)
+	}
+}
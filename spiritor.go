@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/config"
+	"github.com/spiritorai/spiritor/edit"
+	"github.com/spiritorai/spiritor/logging"
+	"github.com/spiritorai/spiritor/progress"
 	"github.com/spiritorai/spiritor/scribe"
 	"github.com/spiritorai/spiritor/transcribe"
 )
@@ -17,158 +24,276 @@ import (
 	TODO:
 
 	* Modify ffmpeg to limit threads and test it out. (https://streaminglearningcenter.com/blogs/ffmpeg-command-threads-how-it-affects-quality-and-performance.html)
-	* Worker context propagation and timeouts/cancellations.
 	* Test worker errors
 	* Re-organize commands into cli/dir structure
-	* Implement pretty console output with debug option
-		* I should use the new log/slog library for standard go as a base (based on zap!)
-		* The library allows you to write your own custom slog handler: https://github.com/golang/example/tree/master/slog-handler-guide
-		* I should be able to find a custom slog handler optimized for pretty console output!
-		* This custom handler is great for local dev and would be great for debug mode as well: https://dusted.codes/creating-a-pretty-console-logger-using-gos-slog-package
-		* A great exercise would be to see if I can get the color/formatting of the ffmpeg logs to come through!
-		* This lists all slog libraries, they even have clickhouse forwarding, adapters from zerolog, integration with CHI!
-		* Awesome zero-deps dev handler: https://github.com/golang-cz/devslog
-	* Support for other outputs, eg: (verbose) json, srt, vtt
-	* Implement dependencies test command and/or abort main process if deps fail
-		* sys write access
-		* ffmpeg
 	* Media path validation: Ensure that all paths are absolute and/or cannot be broken and work across multiple OS
-	* Make api key configurable
 */
 
-const (
-	downsampleWorkerCount    int = 4
-	transcriptionWorkerCount int = 6
-)
-
 type Context struct {
-	Debug bool
+	Ctx        context.Context // root ctx which is canceled on interrupt, carries the logger
+	Logger     *slog.Logger
+	Debug      bool
+	LogFormat  string
+	Config     config.Config // merged config from all layers except flags
+	ConfigPath string        // user config file path
+}
+
+// ScribeFlags are shared by the commands which run the scribe pipeline. Flags
+// without defaults are optional overrides of the config values, the defaults
+// themselves live in the config package. Flags which only apply to some of the
+// commands, such as --force, are declared by those commands.
+type ScribeFlags struct {
+	Engine               string   `help:"Transcription engine (default: openai)." short:"e"`
+	Language             string   `help:"Language of the audio as an ISO-639-1 code, or auto to detect it (default: en)." short:"l"`
+	Prompt               string   `help:"Text to guide the transcription style or vocabulary, eg: names and acronyms."`
+	AudioStream          string   `help:"Audio track of files with several, as a stream index or a language code (default: the track matching --language, else the default track)."`
+	NoCache              bool     `help:"Do not use or update the transcript cache."`
+	Diarize              bool     `help:"Label the speakers of each transcript."`
+	Speakers             int      `help:"Expected number of speakers for --diarize (default: estimated)."`
+	Clean                bool     `help:"Also write a clean read transcript without fillers, repeats and false starts (clean.txt), and add the removed spans to the json output."`
+	SpeakerNames         string   `help:"Speaker mapping json file, eg: {\"SPEAKER_1\": \"Alice\"}. A <file>.speakers.json file next to a source takes precedence." type:"path"`
+	Outputs              []string `name:"output" help:"List of output formats (default: txt)." short:"o"`
+	DownsampleWorkers    int      `help:"Number of parallel downsample workers."`
+	TranscriptionWorkers int      `help:"Number of parallel transcription workers."`
+}
+
+// applyFlags overrides the scribe config with any flags which have been set.
+func (flags *ScribeFlags) applyFlags(conf *config.Config) {
+	if flags.Engine != "" {
+		conf.Scribe.Engine = flags.Engine
+	}
+	if flags.Language != "" {
+		conf.Scribe.Language = flags.Language
+	}
+	if flags.Prompt != "" {
+		conf.Scribe.Prompt = flags.Prompt
+	}
+	if flags.AudioStream != "" {
+		conf.Scribe.AudioStream = flags.AudioStream
+	}
+	if flags.Diarize {
+		conf.Diarize.Enabled = true
+	}
+	if flags.Speakers > 0 {
+		conf.Diarize.Speakers = flags.Speakers
+	}
+	if flags.Clean {
+		conf.Clean.Enabled = true
+	}
+	if flags.SpeakerNames != "" {
+		conf.Diarize.Names = flags.SpeakerNames
+	}
+	if len(flags.Outputs) > 0 {
+		conf.Scribe.Outputs = flags.Outputs
+	}
+	if flags.DownsampleWorkers > 0 {
+		conf.Scribe.DownsampleWorkers = flags.DownsampleWorkers
+	}
+	if flags.TranscriptionWorkers > 0 {
+		conf.Scribe.TranscriptionWorkers = flags.TranscriptionWorkers
+	}
 }
 
 type ScribeCmd struct {
-	Force   bool     `help:"Force overwrite existing transcripts." short:"f" default:false`
-	Outputs []string `name:"output" help:"List of output formats." short:"o" default:"txt"`
-	Files   []string `arg name:"file" help:"Target file path(s)." type:"path"`
+	ScribeFlags `embed:""`
+	Force       bool     `help:"Force overwrite existing transcripts." short:"f" default:"false"`
+	Resume      bool     `help:"Resume unfinished jobs from the last completed stage. Without files all unfinished jobs are resumed."`
+	NoProgress  bool     `help:"Log every step instead of showing the live progress on a terminal."`
+	Files       []string `arg:"" optional:"" name:"file" help:"Target file path(s)." type:"path"`
 }
 
 func (cmd *ScribeCmd) Run(ctx *Context) error {
 
 	fmt.Printf("\nSpiritor AI: Scribe\n\n")
 
-	if ctx.Debug {
-		fmt.Printf("params: force=%v, outputs=%v, files=%v\n", cmd.Force, cmd.Outputs, cmd.Files)
+	conf := ctx.Config
+	cmd.applyFlags(&conf)
+
+	log := ctx.Logger
+	log.Debug("params", "force", cmd.Force, "engine", conf.Scribe.Engine, "language", conf.Scribe.Language, "outputs", conf.Scribe.Outputs, "resume", cmd.Resume, "files", cmd.Files)
+
+	if len(cmd.Files) == 0 && !cmd.Resume {
+		return fmt.Errorf("expected file paths or --resume")
 	}
 
-	// Quit now if any unsupported output formats have been given
-	for _, output := range cmd.Outputs {
-		if !transcribe.OutputAllowed(output) {
-			return fmt.Errorf("unsupported output: %v", output)
+	scriber, err := newScriber(conf, cmd.NoCache)
+	if err != nil {
+		return err
+	}
+	defer scriber.close()
+
+	// Without files all unfinished jobs are resumed
+	fpaths := cmd.Files
+	if len(fpaths) == 0 {
+		for _, record := range scriber.state.Records() {
+			if record.Stage != scribe.StageCompleted {
+				fpaths = append(fpaths, record.Source)
+			}
+		}
+		if len(fpaths) == 0 {
+			log.Info("nothing to resume", "state_dir", conf.Scribe.StateDir)
 		}
 	}
 
-	workdir, err := os.MkdirTemp("", "spiritor")
-	if err != nil {
-		return fmt.Errorf("failed to create temp dir: %v", err)
+	// On a terminal the progress is drawn in place and only warnings are logged
+	// above it, since the info logs of concurrent jobs would push it away.
+	tty := cmd.showProgress(ctx)
+	display := progress.NewDisplay(os.Stderr, tty, os.Getenv("NO_COLOR") == "")
+	runCtx := ctx.Ctx
+	if tty {
+		log = slog.New(logging.NewConsoleHandler(display, &logging.ConsoleHandlerOptions{
+			Level: slog.LevelWarn,
+			Color: os.Getenv("NO_COLOR") == "",
+		}))
+		runCtx = logging.WithLogger(runCtx, log)
 	}
-	// TODO: For some reason this does not get killed when I force exit
-	defer os.RemoveAll(workdir)
+	jobProgress := newScribeProgress(display, log, tty)
 
 	// Parse the initial file paths and extract all files available for processing
-	var files []avmedia.Media
-	for _, fpath := range cmd.Files {
-
-		if fext := strings.ToLower(strings.TrimLeft(filepath.Ext(fpath), ".")); !avmedia.ExtAllowedDownsampleOGG(fext) {
-			if ctx.Debug {
-				fmt.Printf("skipped: %v: unsupported ext: %v\n", fpath, fext)
-			}
+	var jobs []scribe.Job
+	for _, fpath := range fpaths {
+		job, err := scriber.prepare(runCtx, fpath, cmd.Resume, cmd.Force)
+		var errSkip skipError
+		if errors.As(err, &errSkip) {
+			jobProgress.skip(fpath, errSkip.reason)
 			continue
 		}
-
-		sourceMedia, err := avmedia.NewMedia(ctx.Debug, fpath)
 		if err != nil {
-			return fmt.Errorf("new media wrapper failed: %v", err)
-		}
-
-		// Test for outputs now so we can skip early if they already exist. If force
-		// flag has been set or at least one specified output does not exist then
-		// do not skip the file.
-		if !cmd.Force {
-			if len(probeOutputs(sourceMedia, cmd.Outputs)) == len(cmd.Outputs) {
-				fmt.Printf("skipped: %v: outputs already exist\n", fpath)
-				continue
-			}
+			return err
 		}
+		jobs = append(jobs, job)
+		jobProgress.add(job)
+	}
 
-		fmt.Printf("processing: %v\n", fpath)
+	// Each job enters the pipeline after its last completed stage, and the
+	// outputs are written as the jobs come out of it.
+	display.Start()
+	pipeline := scriber.start(jobProgress.context(runCtx), jobProgress.done)
+	for _, job := range jobs {
+		pipeline.Submit(job)
+	}
+	pipeline.Close()
+	display.Stop()
 
-		files = append(files, sourceMedia)
+	if err := ctx.Ctx.Err(); err != nil {
+		return fmt.Errorf("interrupted: %v", err)
 	}
 
-	// set up job and worker channels
-	downsampleJobs := make(chan scribe.Job)
-	defer close(downsampleJobs)
+	fmt.Printf("\nAh, the sweet smell of success!\n")
+	return nil
+}
 
-	trancriptionJobs := make(chan scribe.Job)
-	defer close(trancriptionJobs)
+// showProgress reports whether the live progress is shown, which takes a
+// terminal and the console log format, and is left out in debug mode so that
+// nothing is hidden.
+func (cmd *ScribeCmd) showProgress(ctx *Context) bool {
+	return !cmd.NoProgress &&
+		!ctx.Debug &&
+		ctx.LogFormat == logging.FormatConsole &&
+		os.Getenv("TERM") != "dumb" &&
+		logging.IsTerminal(os.Stderr)
+}
 
-	jobResults := make(chan scribe.Job, len(files))
-	defer close(jobResults)
+var cli struct {
+	Debug      bool       `help:"Enable debug mode."`
+	LogFormat  string     `help:"Log output format, one of: console, json." enum:"console,json" default:"console"`
+	ConfigFile string     `name:"config" help:"Path to the config file (default: $XDG_CONFIG_HOME/spiritor/config.json)." type:"path"`
+	Scribe     ScribeCmd  `cmd:"" help:"Generates transcripts for a file."`
+	Watch      WatchCmd   `cmd:"" help:"Transcribes the recordings which are added to a dir, until interrupted."`
+	Serve      ServeCmd   `cmd:"" help:"Runs an http api which transcribes uploaded and server-local files."`
+	Config     ConfigCmd  `cmd:"" help:"Shows or changes the config."`
+	Jobs       JobsCmd    `cmd:"" help:"Lists the scribe jobs and their status."`
+	Edit       EditCmd    `cmd:"" help:"Cuts a file by the words deleted from a copy of its transcript."`
+	Compose    ComposeCmd `cmd:"" help:"Drafts an essay, video script or article outline from transcripts."`
+	Speak      SpeakCmd   `cmd:"" help:"Reads a text or markdown file out loud into an mp3 or ogg file."`
+	Doctor     DoctorCmd  `cmd:"" help:"Checks that ffmpeg and the transcription engine are available."`
+}
 
-	for w := 1; w <= downsampleWorkerCount; w++ {
-		go scribe.DownsampleWorker(context.TODO(), ctx.Debug, workdir, downsampleJobs, trancriptionJobs, jobResults)
-	}
+// configRepairCommands do not need the loaded config.
+var configRepairCommands = map[string]bool{
+	"config set <key> <value>": true,
+	"config path":              true,
+}
 
-	for w := 1; w <= transcriptionWorkerCount; w++ {
-		go scribe.TranscriptionWorker(context.TODO(), ctx.Debug, workdir, trancriptionJobs, jobResults, jobResults)
+func main() {
+	ctx := kong.Parse(&cli, kong.Vars{
+		"edit_crossfade": edit.DefaultCrossfade.String(),
+		"edit_padding":   edit.DefaultPadding.String(),
+	})
+
+	// The first interrupt cancels the root ctx so that running ffmpeg processes
+	// are killed and temp files are cleaned up. Once canceled the default signal
+	// behavior is restored so that a second interrupt will force exit.
+	rootCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-rootCtx.Done()
+		stop()
+	}()
+
+	configPath := cli.ConfigFile
+	if configPath == "" {
+		path, err := config.Path()
+		ctx.FatalIfErrorf(err)
+		configPath = path
 	}
 
-	// kick off the workers
-	for _, sourceMedia := range files {
-		downsampleJobs <- scribe.Job{
-			SourceMedia: sourceMedia,
-		}
+	level := slog.LevelInfo
+	if cli.Debug {
+		level = slog.LevelDebug
 	}
 
-	// process the results
-	for i := 1; i <= len(files); i++ {
-		job := <-jobResults
-		if job.Err != nil {
-			fmt.Printf("failed: %v: %v\n", job.SourceMedia.GetName(), job.Err)
-			continue
-		}
+	logger, err := logging.New(os.Stderr, cli.LogFormat, level)
+	ctx.FatalIfErrorf(err)
 
-		for _, output := range cmd.Outputs {
-			body, err := job.Transcript.Format(output)
-			if err != nil {
-				fmt.Printf("failed: %v: transcript format error: %v\n", job.SourceMedia.GetName(), err)
-				continue
-			}
+	// The commands which locate and change the user config file must still run
+	// when it is broken, as they are how it gets fixed.
+	conf, err := config.Load(configPath)
+	if err != nil && configRepairCommands[ctx.Command()] {
+		logger.Warn("failed to load config", "err", err)
+		err = nil
+	}
+	ctx.FatalIfErrorf(err)
 
-			outputPath := buildOutputPath(job.SourceMedia, output)
-			if err := os.WriteFile(outputPath, body, 0666); err != nil {
-				fmt.Printf("failed: %v: file write error: %v\n", job.SourceMedia.GetName(), err)
-				continue
-			}
+	// Call the Run() method of the selected parsed command.
 
-			fmt.Printf("succeeded: %v\n", outputPath)
-		}
+	err = ctx.Run(&Context{Ctx: logging.WithLogger(rootCtx, logger), Logger: logger, Debug: cli.Debug, LogFormat: cli.LogFormat, Config: conf, ConfigPath: configPath})
+	ctx.FatalIfErrorf(err)
+}
 
+// newTranscriber builds the configured transcription engine. The local engine
+// has its own config section so that switching between it and openai only takes
+// the engine name, every other engine uses the openai section.
+func newTranscriber(conf config.Config) (transcribe.Transcriber, error) {
+
+	engineConfig := transcribe.EngineConfig{
+		BaseURL:  conf.OpenAI.BaseURL,
+		APIKey:   conf.OpenAI.APIKey,
+		Model:    conf.OpenAI.Model,
+		Language: conf.Scribe.Language,
+		Prompt:   conf.Scribe.Prompt,
+		Timeout:  seconds(conf.Timeouts.Transcribe),
+
+		MaxRetries:        conf.OpenAI.MaxRetries,
+		RequestsPerMinute: conf.OpenAI.RequestsPerMinute,
 	}
 
-	fmt.Printf("\nAh, the sweet smell of success!\n")
-	return nil
-}
+	if conf.Scribe.Engine == transcribe.EngineLocal {
+		engineConfig.BaseURL = conf.Local.BaseURL
+		engineConfig.APIKey = conf.Local.APIKey
+		engineConfig.Model = conf.Local.Model
+		engineConfig.RequestsPerMinute = 0
+	}
 
-var cli struct {
-	Debug  bool      `help:"Enable debug mode."`
-	Scribe ScribeCmd `cmd help:"Generates transcripts for a file."`
-}
+	transcriber, err := transcribe.NewTranscriber(conf.Scribe.Engine, engineConfig)
+	if err != nil && conf.Scribe.Engine == transcribe.EngineOpenAI {
+		return nil, fmt.Errorf("failed to initialize engine %v: %v: set it with `spiritor config set` or the %v env var", conf.Scribe.Engine, err, config.EnvName("openai.api_key"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize engine %v: %v", conf.Scribe.Engine, err)
+	}
 
-func main() {
-	ctx := kong.Parse(&cli)
-	// Call the Run() method of the selected parsed command.
-	err := ctx.Run(&Context{Debug: cli.Debug})
-	ctx.FatalIfErrorf(err)
+	return transcriber, nil
 }
 
 // probeOutputs will test the full path of each of the passed output formats for an
@@ -183,6 +308,29 @@ func probeOutputs(media avmedia.Media, outputs []string) []string {
 	return exists
 }
 
+// selectAudioStream picks the audio track of files which have several. An explicit
+// selector must match, otherwise the track in the transcription language is
+// preferred and the default track is kept if there is none.
+func selectAudioStream(media avmedia.Media, selector, language string) (avmedia.Media, error) {
+	if selector != "" {
+		return media.SelectAudioStream(selector)
+	}
+	if len(media.GetAudioStreams()) > 1 {
+		if selected, err := media.SelectAudioStream(language); err == nil {
+			return selected, nil
+		}
+	}
+	return media, nil
+}
+
+// seconds converts a config value in seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// speakerNamesExt is appended to a source path for its speaker mapping file.
+const speakerNamesExt = "speakers.json"
+
 // buildOutputPath will construct and return the full path of the output file based on the
 // output format param that is passed. This can be called before or after the output
 // file is created.
@@ -0,0 +1,144 @@
+package transcribe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spiritorai/spiritor/avmedia"
+)
+
+// cacheVersion is part of every cache key, bump it if the transcript format
+// changes so that old entries are no longer used.
+const cacheVersion = 1
+
+// Cache is a content addressed store of raw transcripts. Entries are keyed by
+// the hash of the source audio and the engine info, so renamed or copied files
+// and new output formats reuse the earlier transcription instead of calling the
+// api again. It is safe for concurrent use since every entry is written to a
+// temp file and renamed into place.
+type Cache struct {
+	Dir string
+}
+
+// CacheDir returns the default cache dir, based on $XDG_CACHE_HOME with a
+// fallback to the os specific user cache dir.
+func CacheDir() (string, error) {
+
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find user cache dir: %w", err)
+		}
+		dir = userDir
+	}
+
+	return filepath.Join(dir, "spiritor", "transcripts"), nil
+}
+
+// NewCache creates the cache dir if needed.
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+// CacheKey hashes the content of the media file together with the selected audio
+// stream and the engine info. The file name is not part of the key.
+func CacheKey(media avmedia.Media, info EngineInfo) (string, error) {
+	return cacheKey(media.GetPath(), media.GetAudioStream(), info)
+}
+
+func cacheKey(audioPath string, audioStream int, info EngineInfo) (string, error) {
+
+	file, err := os.Open(audioPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %v: %w", audioPath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file %v: %w", audioPath, err)
+	}
+
+	// The info is json encoded so that the fields cannot run into each other.
+	meta, err := json.Marshal(struct {
+		Version     int `json:"version"`
+		AudioStream int `json:"audio_stream"`
+		EngineInfo
+	}{cacheVersion, audioStream, info})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal engine info: %w", err)
+	}
+	hash.Write(meta)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// entryPath shards the entries by the first byte of the key to keep the dirs
+// small.
+func (c *Cache) entryPath(key string) string {
+	return filepath.Join(c.Dir, key[:2], key+".json")
+}
+
+// Get returns the cached transcript for the key. A missing entry is not an error.
+func (c *Cache) Get(key string) (Transcript, bool, error) {
+
+	var ts Transcript
+
+	data, err := os.ReadFile(c.entryPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return ts, false, nil
+	}
+	if err != nil {
+		return ts, false, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return ts, false, fmt.Errorf("failed to parse cache entry %v: %w", c.entryPath(key), err)
+	}
+
+	return ts, true, nil
+}
+
+// Put stores the transcript for the key, replacing any existing entry.
+func (c *Cache) Put(key string, ts Transcript) error {
+
+	path := c.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	data, err := json.Marshal(ts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace cache entry: %w", err)
+	}
+
+	return nil
+}
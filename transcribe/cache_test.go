@@ -0,0 +1,118 @@
+package transcribe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "audio.mp3")
+	copied := filepath.Join(dir, "copy.mp3")
+	other := filepath.Join(dir, "other.mp3")
+	for path, data := range map[string]string{audio: "fake audio", copied: "fake audio", other: "other audio"} {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info := EngineInfo{Engine: EngineOpenAI, Model: "whisper-1", Language: "en"}
+	base, err := cacheKey(audio, 0, info)
+	if err != nil {
+		t.Fatalf("cacheKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		stream int
+		update func(info *EngineInfo)
+		same   bool
+	}{
+		{"same file", audio, 0, nil, true},
+		{"renamed copy", copied, 0, nil, true},
+		{"other content", other, 0, nil, false},
+		{"other audio stream", audio, 1, nil, false},
+		{"engine", audio, 0, func(info *EngineInfo) { info.Engine = EngineLocal }, false},
+		{"model", audio, 0, func(info *EngineInfo) { info.Model = "whisper-2" }, false},
+		{"language", audio, 0, func(info *EngineInfo) { info.Language = "de" }, false},
+		{"prompt", audio, 0, func(info *EngineInfo) { info.Prompt = "Spiritor" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := info
+			if tt.update != nil {
+				tt.update(&info)
+			}
+			key, err := cacheKey(tt.path, tt.stream, info)
+			if err != nil {
+				t.Fatalf("cacheKey() error = %v", err)
+			}
+			if same := key == base; same != tt.same {
+				t.Errorf("key equal = %v, want %v", same, tt.same)
+			}
+		})
+	}
+
+	if _, err := cacheKey(filepath.Join(dir, "missing.mp3"), 0, info); err == nil {
+		t.Errorf("cacheKey() of a missing file error = nil, want error")
+	}
+}
+
+func TestCache(t *testing.T) {
+
+	cache, err := NewCache(filepath.Join(t.TempDir(), "transcripts"))
+	if err != nil {
+		t.Fatalf("NewCache() error = %v", err)
+	}
+
+	const key = "0123456789abcdef"
+	if _, ok, err := cache.Get(key); ok || err != nil {
+		t.Fatalf("Get() of a missing entry = %v, %v, want a miss without error", ok, err)
+	}
+
+	want := Transcript{
+		Text:     "Hello world.",
+		Language: "en",
+		Duration: 1.5,
+		Words:    []Word{{Word: "Hello", Start: 0, End: 0.5}, {Word: "world", Start: 0.6, End: 1.2}},
+		Segments: []Segment{{Start: 0, End: 1.5, Text: " Hello world."}},
+	}
+	if err := cache.Put(key, want); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, ok, err := cache.Get(key)
+	if err != nil || !ok {
+		t.Fatalf("Get() = %v, %v, want a hit", ok, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+
+	// A corrupt entry is a miss, and the next transcript replaces it.
+	if err := os.WriteFile(cache.entryPath(key), []byte(`{"text": "Hel`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := cache.Get(key); ok || err == nil {
+		t.Errorf("Get() of a corrupt entry = %v, %v, want a miss with error", ok, err)
+	}
+	if err := cache.Put(key, want); err != nil {
+		t.Fatalf("Put() over a corrupt entry error = %v", err)
+	}
+	if _, ok, _ := cache.Get(key); !ok {
+		t.Errorf("Get() after replacing a corrupt entry = miss, want a hit")
+	}
+
+	// Only the entry is left behind, no temp files.
+	entries, err := os.ReadDir(filepath.Dir(cache.entryPath(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("cache dir has %v files, want 1", len(entries))
+	}
+}
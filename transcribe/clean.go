@@ -0,0 +1,315 @@
+package transcribe
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	RemovedFiller     = "filler"
+	RemovedRepeat     = "repeat"
+	RemovedFalseStart = "false_start"
+)
+
+// repeatExceptions are words which are commonly doubled on purpose, eg: "I know
+// that that is true".
+var repeatExceptions = map[string]bool{"that": true, "had": true}
+
+// maxRepeatLength is the longest phrase which is checked for repeats, eg: "I
+// think I think".
+const maxRepeatLength = 3
+
+// CleanOptions holds the settings of the cleanup for the clean read outputs. The
+// zero value removes repeats and false starts but no fillers, the default
+// fillers are part of the config.
+type CleanOptions struct {
+	Fillers         []string // filler words and phrases, eg: um, you know
+	KeepRepeats     bool     // keep repeated words and phrases, eg: "the the"
+	KeepFalseStarts bool     // keep words which were cut off, eg: "wh- what"
+}
+
+// Removal is a span of the verbatim transcript which was removed by the cleanup,
+// times are in seconds from the start of the media.
+type Removal struct {
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Text   string  `json:"text"`
+	Reason string  `json:"reason"` // one of filler, repeat or false_start
+}
+
+// Clean returns a clean read copy of the transcript with the fillers, repeats
+// and false starts removed from the text of every segment, along with the spans
+// which were removed. Multi word fillers such as "you know" are only removed
+// when they are set off by punctuation, so that "do you know him" is kept.
+func (ts Transcript) Clean(opts CleanOptions) (Transcript, []Removal) {
+
+	// Longer fillers are matched first so that "uh huh" wins over "uh".
+	var phrases [][]string
+	for _, filler := range opts.Fillers {
+		var phrase []string
+		for _, word := range strings.Fields(filler) {
+			if word = NormalizeWord(word); word != "" {
+				phrase = append(phrase, word)
+			}
+		}
+		if len(phrase) > 0 {
+			phrases = append(phrases, phrase)
+		}
+	}
+	sort.SliceStable(phrases, func(i, j int) bool { return len(phrases[i]) > len(phrases[j]) })
+
+	segments := ts.Segments
+	if len(segments) == 0 {
+		segments = []Segment{{End: ts.Duration, Text: ts.Text}}
+	}
+
+	var removals []Removal
+	var texts []string
+	cleaned := make([]Segment, len(segments))
+	for i, segment := range segments {
+		text, removed := cleanSegment(segment, ts.Words, phrases, opts)
+		cleaned[i] = segment
+		cleaned[i].Text = text
+		removals = append(removals, removed...)
+		if text != "" {
+			texts = append(texts, strings.TrimSpace(text))
+		}
+	}
+
+	if len(ts.Segments) > 0 {
+		ts.Segments = cleaned
+	}
+	ts.Text = strings.Join(texts, " ")
+	ts.Words = removeWords(ts.Words, removals)
+
+	return ts, removals
+}
+
+// cleanSegment returns the cleaned text of the segment and its removed spans.
+func cleanSegment(segment Segment, words []Word, phrases [][]string, opts CleanOptions) (string, []Removal) {
+
+	timed := segmentWords(segment, words)
+	if len(timed) == 0 {
+		return segment.Text, nil
+	}
+
+	norm := make([]string, len(timed))
+	for i, word := range timed {
+		norm[i] = NormalizeWord(word.text)
+	}
+
+	reasons := make([]string, len(timed))
+
+	if !opts.KeepFalseStarts {
+		for i, word := range timed {
+			if norm[i] != "" && strings.TrimRightFunc(word.text, isDash) != word.text {
+				reasons[i] = RemovedFalseStart
+			}
+		}
+	}
+
+	for i := 0; i < len(timed); i++ {
+		for _, phrase := range phrases {
+			end := i + len(phrase)
+			if end > len(timed) || !matchPhrase(norm[i:end], reasons[i:end], phrase) {
+				continue
+			}
+			setOff := i == 0 || endsWithPunct(timed[i-1].text) || endsWithPunct(timed[end-1].text)
+			if len(phrase) > 1 && !setOff {
+				continue
+			}
+			for j := i; j < end; j++ {
+				reasons[j] = RemovedFiller
+			}
+			i = end - 1
+			break
+		}
+	}
+
+	if !opts.KeepRepeats {
+		markRepeats(timed, norm, reasons)
+	}
+
+	return joinCleaned(timed, reasons), buildRemovals(timed, reasons)
+}
+
+func matchPhrase(norm []string, reasons []string, phrase []string) bool {
+	for i, word := range phrase {
+		if norm[i] != word || reasons[i] != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// markRepeats marks the earlier copies of words and phrases which are repeated
+// right away, eg: "I think I think so" keeps the second "I think".
+func markRepeats(timed []timedWord, norm []string, reasons []string) {
+
+	var kept []int
+	for i := range timed {
+		if reasons[i] == "" && norm[i] != "" {
+			kept = append(kept, i)
+		}
+	}
+
+	for k := 0; k < len(kept); k++ {
+		for length := maxRepeatLength; length >= 1; length-- {
+			if k+2*length > len(kept) {
+				continue
+			}
+			if length == 1 && repeatExceptions[norm[kept[k]]] {
+				continue
+			}
+			// A repeat across a sentence end is a new sentence rather than a stutter.
+			if endsWithSentence(timed[kept[k+length-1]].text) {
+				continue
+			}
+			repeated := true
+			for j := 0; j < length; j++ {
+				if norm[kept[k+j]] != norm[kept[k+length+j]] {
+					repeated = false
+					break
+				}
+			}
+			if !repeated {
+				continue
+			}
+			for j := 0; j < length; j++ {
+				reasons[kept[k+j]] = RemovedRepeat
+			}
+			k += length - 1
+			break
+		}
+	}
+}
+
+// joinCleaned joins the kept words and repairs the sentence around each removed
+// run. A sentence end on the last removed word is moved onto the previous kept
+// word, a comma before the run is dropped if the run also ended with one, and the
+// next kept word is capitalized if the run started a sentence.
+func joinCleaned(timed []timedWord, reasons []string) string {
+
+	// A segment may start in the middle of a sentence, so its first word is the
+	// only hint of whether a removed run at the start began a sentence.
+	var out []string
+	sentenceStart := startsUpper(timed[0].text)
+	for i := 0; i < len(timed); i++ {
+
+		if reasons[i] == "" {
+			out = append(out, timed[i].text)
+			sentenceStart = endsWithSentence(timed[i].text)
+			continue
+		}
+
+		for i+1 < len(timed) && reasons[i+1] != "" {
+			i++
+		}
+		last := timed[i].text
+
+		if endsWithSentence(last) && len(out) > 0 && !endsWithSentence(out[len(out)-1]) {
+			punct := last[len(strings.TrimRightFunc(last, unicode.IsPunct)):]
+			out[len(out)-1] = strings.TrimRightFunc(out[len(out)-1], unicode.IsPunct) + punct
+			sentenceStart = true
+		}
+
+		// The commas around a filler were only there because of it, eg: "he was,
+		// uh, there".
+		if strings.HasSuffix(last, ",") && len(out) > 0 && strings.HasSuffix(out[len(out)-1], ",") {
+			out[len(out)-1] = strings.TrimSuffix(out[len(out)-1], ",")
+		}
+
+		if sentenceStart && i+1 < len(timed) && reasons[i+1] == "" {
+			timed[i+1].text = capitalize(timed[i+1].text)
+		}
+	}
+
+	if len(out) == 0 {
+		return ""
+	}
+	return " " + strings.Join(out, " ")
+}
+
+// buildRemovals merges consecutive removed words with the same reason into spans.
+func buildRemovals(timed []timedWord, reasons []string) []Removal {
+	var removals []Removal
+	for i := 0; i < len(timed); i++ {
+		if reasons[i] == "" {
+			continue
+		}
+		first := i
+		for i+1 < len(timed) && reasons[i+1] == reasons[first] {
+			i++
+		}
+		var text []string
+		for _, word := range timed[first : i+1] {
+			text = append(text, word.text)
+		}
+		removals = append(removals, Removal{
+			Start:  timed[first].start,
+			End:    timed[i].end,
+			Text:   strings.Join(text, " "),
+			Reason: reasons[first],
+		})
+	}
+	return removals
+}
+
+// removeWords drops the timed words which fall within a removed span and are one
+// of its words.
+func removeWords(words []Word, removals []Removal) []Word {
+
+	if len(removals) == 0 {
+		return words
+	}
+
+	var kept []Word
+	for _, word := range words {
+		mid := (word.Start + word.End) / 2
+		removed := false
+		for _, removal := range removals {
+			if mid < removal.Start || mid > removal.End {
+				continue
+			}
+			for _, field := range strings.Fields(removal.Text) {
+				if NormalizeWord(field) == NormalizeWord(word.Word) {
+					removed = true
+					break
+				}
+			}
+			if removed {
+				break
+			}
+		}
+		if !removed {
+			kept = append(kept, word)
+		}
+	}
+	return kept
+}
+
+func isDash(r rune) bool {
+	return r == '-' || r == '–' || r == '—'
+}
+
+func endsWithPunct(word string) bool {
+	r, _ := utf8.DecodeLastRuneInString(word)
+	return unicode.IsPunct(r)
+}
+
+func endsWithSentence(word string) bool {
+	word = strings.TrimRight(word, `"')]`)
+	return strings.HasSuffix(word, ".") || strings.HasSuffix(word, "?") || strings.HasSuffix(word, "!")
+}
+
+func startsUpper(word string) bool {
+	r, _ := utf8.DecodeRuneInString(word)
+	return unicode.IsUpper(r)
+}
+
+func capitalize(word string) string {
+	r, size := utf8.DecodeRuneInString(word)
+	return string(unicode.ToUpper(r)) + word[size:]
+}
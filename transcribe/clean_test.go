@@ -0,0 +1,125 @@
+package transcribe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// timedTranscript builds a single segment transcript from the text, with a
+// timed word of one second for each of its words.
+func timedTranscript(text string) Transcript {
+	var words []Word
+	for i, field := range strings.Fields(text) {
+		words = append(words, Word{Word: NormalizeWord(field), Start: float64(i), End: float64(i) + 0.9})
+	}
+	duration := float64(len(words))
+	return Transcript{
+		Text:     text,
+		Duration: duration,
+		Words:    words,
+		Segments: []Segment{{Start: 0, End: duration, Text: " " + text}},
+	}
+}
+
+func TestClean(t *testing.T) {
+
+	fillers := []string{"um", "uh", "you know", "I mean"}
+
+	tests := []struct {
+		name string
+		text string
+		opts CleanOptions
+		want string
+	}{
+		{
+			name: "single word fillers",
+			text: "So, um, we went there.",
+			opts: CleanOptions{Fillers: fillers},
+			want: "So we went there.",
+		},
+		{
+			name: "multi word filler set off by punctuation",
+			text: "It was, you know, fine.",
+			opts: CleanOptions{Fillers: fillers},
+			want: "It was fine.",
+		},
+		{
+			name: "multi word filler within a sentence is kept",
+			text: "Do you know him?",
+			opts: CleanOptions{Fillers: fillers},
+			want: "Do you know him?",
+		},
+		{
+			name: "filler at the start of a sentence",
+			text: "Uh, we left.",
+			opts: CleanOptions{Fillers: fillers},
+			want: "We left.",
+		},
+		{
+			name: "repeats",
+			text: "I think I think the the plan works.",
+			opts: CleanOptions{Fillers: fillers},
+			want: "I think the plan works.",
+		},
+		{
+			name: "doubled exception is kept",
+			text: "I know that that is true.",
+			opts: CleanOptions{Fillers: fillers},
+			want: "I know that that is true.",
+		},
+		{
+			name: "false starts",
+			text: "We wh- went home.",
+			opts: CleanOptions{Fillers: fillers},
+			want: "We went home.",
+		},
+		{
+			name: "everything kept",
+			text: "Um, the the wh- what.",
+			opts: CleanOptions{KeepRepeats: true, KeepFalseStarts: true},
+			want: "Um, the the wh- what.",
+		},
+		{
+			name: "empty fillers remove none",
+			text: "So, um, we went there.",
+			opts: CleanOptions{Fillers: []string{}},
+			want: "So, um, we went there.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, _ := timedTranscript(tt.text).Clean(tt.opts)
+			if cleaned.Text != tt.want {
+				t.Errorf("text = %q, want %q", cleaned.Text, tt.want)
+			}
+			if got := strings.TrimSpace(cleaned.Segments[0].Text); got != tt.want {
+				t.Errorf("segment text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanRemovals(t *testing.T) {
+
+	ts := timedTranscript("Um, the the plan wh- works.")
+	cleaned, removals := ts.Clean(CleanOptions{Fillers: []string{"um"}})
+
+	want := []Removal{
+		{Start: 0, End: 0.9, Text: "Um,", Reason: RemovedFiller},
+		{Start: 1, End: 1.9, Text: "the", Reason: RemovedRepeat},
+		{Start: 4, End: 4.9, Text: "wh-", Reason: RemovedFalseStart},
+	}
+	if !reflect.DeepEqual(removals, want) {
+		t.Errorf("removals = %+v, want %+v", removals, want)
+	}
+
+	var words []string
+	for _, word := range cleaned.Words {
+		words = append(words, word.Word)
+	}
+	if got := strings.Join(words, " "); got != "the plan works" {
+		t.Errorf("words = %q, want the removed words dropped", got)
+	}
+}
@@ -0,0 +1,150 @@
+package transcribe
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DocumentOptions controls the md and html writers, which are meant to be pasted
+// into drafts. The zero value writes no timestamp headings.
+type DocumentOptions struct {
+	HeadingInterval time.Duration // timestamp headings every interval, 0 for none
+}
+
+const defaultTitle = "Transcript"
+
+// documentTitle is the source file name without its extension, eg: interview for
+// interview.mp3.
+func documentTitle(opts FormatOptions) string {
+	if opts.SourceMedia == nil {
+		return defaultTitle
+	}
+	name := opts.SourceMedia.GetName()
+	if title := strings.TrimSuffix(name, filepath.Ext(name)); title != "" {
+		return title
+	}
+	return name
+}
+
+// formatClock writes whole seconds as a timestamp, eg: 01:05:00.
+func formatClock(seconds float64) string {
+	s := int64(math.Max(seconds, 0))
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// headings returns the timestamp heading which comes before each paragraph, or
+// an empty string for none. A heading is written before the first paragraph
+// which starts at or after each multiple of the interval, and intervals without
+// any paragraphs are skipped.
+func headings(paragraphs []paragraph, interval time.Duration) []string {
+	result := make([]string, len(paragraphs))
+	if interval <= 0 {
+		return result
+	}
+	step := interval.Seconds()
+	next := step
+	for i, p := range paragraphs {
+		if p.start < next {
+			continue
+		}
+		mark := math.Floor(p.start/step) * step
+		result[i] = formatClock(mark)
+		next = mark + step
+	}
+	return result
+}
+
+// formatMD writes the title as a heading followed by the paragraphs, with the
+// speaker of each turn in bold.
+func (ts Transcript) formatMD(opts FormatOptions) ([]byte, error) {
+
+	paragraphs, err := ts.paragraphs(opts.Paragraphs)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %v\n", escapeMD(documentTitle(opts)))
+
+	for i, heading := range headings(paragraphs, opts.Document.HeadingInterval) {
+		p := paragraphs[i]
+		if heading != "" {
+			fmt.Fprintf(&b, "\n## %v\n", heading)
+		}
+		b.WriteString("\n")
+		if p.turn && p.speaker != "" {
+			fmt.Fprintf(&b, "**%v:** ", escapeMD(p.speaker))
+		}
+		b.WriteString(escapeMD(p.text()))
+		b.WriteString("\n")
+	}
+
+	return []byte(b.String()), nil
+}
+
+// escapeMD escapes the characters which would otherwise change the formatting of
+// transcript text, eg: a "*" which is spoken as part of a product name.
+func escapeMD(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune("\\`*_[]#<>|", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// formatHTML writes a standalone html page of the paragraphs. Each paragraph
+// starts with a link to its time in the source file, using a media fragment which
+// browsers open at that time, eg: interview.mp3#t=65.
+func (ts Transcript) formatHTML(opts FormatOptions) ([]byte, error) {
+
+	paragraphs, err := ts.paragraphs(opts.Paragraphs)
+	if err != nil {
+		return nil, err
+	}
+
+	title := html.EscapeString(documentTitle(opts))
+
+	// The link is relative since the output is written next to the source file.
+	var source string
+	if opts.SourceMedia != nil {
+		source = (&url.URL{Path: opts.SourceMedia.GetName()}).String()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%v</title>\n", title)
+	b.WriteString("<style>\nbody { max-width: 42em; margin: 2em auto; padding: 0 1em; font-family: Georgia, serif; line-height: 1.6; }\na.time { font-family: monospace; font-size: 0.85em; color: #888; text-decoration: none; margin-right: 0.5em; }\n</style>\n")
+	fmt.Fprintf(&b, "</head>\n<body>\n<h1>%v</h1>\n", title)
+
+	for i, heading := range headings(paragraphs, opts.Document.HeadingInterval) {
+		p := paragraphs[i]
+		if heading != "" {
+			fmt.Fprintf(&b, "<h2>%v</h2>\n", heading)
+		}
+
+		start := math.Floor(p.start)
+		id := fmt.Sprintf("p%d", i+1)
+		fmt.Fprintf(&b, "<p id=\"%v\">", id)
+		if source != "" {
+			fmt.Fprintf(&b, "<a class=\"time\" href=\"%v#t=%d\">%v</a>", html.EscapeString(source), int64(start), formatClock(start))
+		} else {
+			fmt.Fprintf(&b, "<a class=\"time\" href=\"#%v\">%v</a>", id, formatClock(start))
+		}
+		if p.turn && p.speaker != "" {
+			fmt.Fprintf(&b, "<strong>%v:</strong> ", html.EscapeString(p.speaker))
+		}
+		b.WriteString(html.EscapeString(p.text()))
+		b.WriteString("</p>\n")
+	}
+
+	b.WriteString("</body>\n</html>\n")
+
+	return []byte(b.String()), nil
+}
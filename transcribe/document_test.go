@@ -0,0 +1,88 @@
+package transcribe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// documentTranscript has two turns, with a pause in the second one which starts
+// a paragraph after the first minute.
+func documentTranscript() Transcript {
+	return Transcript{Segments: []Segment{
+		{Start: 0, End: 5, Text: " Welcome to the *show*.", Speaker: "Ann"},
+		{Start: 5, End: 30, Text: " Thanks <all>.", Speaker: "Bob"},
+		{Start: 65, End: 70, Text: " Let's begin.", Speaker: "Bob"},
+	}}
+}
+
+func TestHeadings(t *testing.T) {
+
+	starts := func(times ...float64) []paragraph {
+		paragraphs := make([]paragraph, len(times))
+		for i, start := range times {
+			paragraphs[i] = paragraph{start: start}
+		}
+		return paragraphs
+	}
+
+	tests := []struct {
+		name       string
+		paragraphs []paragraph
+		interval   time.Duration
+		want       []string
+	}{
+		{"no interval", starts(0, 70), 0, []string{"", ""}},
+		{"first interval has no heading", starts(0, 30, 59), time.Minute, []string{"", "", ""}},
+		{"heading on the first paragraph of an interval", starts(0, 61, 90, 125), time.Minute, []string{"", "00:01:00", "", "00:02:00"}},
+		{"empty intervals are skipped", starts(10, 3700), time.Minute, []string{"", "01:01:00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := headings(tt.paragraphs, tt.interval); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("headings() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMD(t *testing.T) {
+
+	md, err := documentTranscript().formatMD(FormatOptions{Document: DocumentOptions{HeadingInterval: time.Minute}})
+	if err != nil {
+		t.Fatalf("formatMD() error = %v", err)
+	}
+
+	want := "# Transcript\n" +
+		"\n**Ann:** Welcome to the \\*show\\*.\n" +
+		"\n**Bob:** Thanks \\<all\\>.\n" +
+		"\n## 00:01:00\n" +
+		"\nLet's begin.\n"
+	if string(md) != want {
+		t.Errorf("formatMD() = %q, want %q", md, want)
+	}
+}
+
+func TestFormatHTML(t *testing.T) {
+
+	page, err := documentTranscript().formatHTML(FormatOptions{Document: DocumentOptions{HeadingInterval: time.Minute}})
+	if err != nil {
+		t.Fatalf("formatHTML() error = %v", err)
+	}
+
+	// Without a source file the times link to the paragraphs themselves.
+	want := "<h1>Transcript</h1>\n" +
+		"<p id=\"p1\"><a class=\"time\" href=\"#p1\">00:00:00</a><strong>Ann:</strong> Welcome to the *show*.</p>\n" +
+		"<p id=\"p2\"><a class=\"time\" href=\"#p2\">00:00:05</a><strong>Bob:</strong> Thanks &lt;all&gt;.</p>\n" +
+		"<h2>00:01:00</h2>\n" +
+		"<p id=\"p3\"><a class=\"time\" href=\"#p3\">00:01:05</a>Let&#39;s begin.</p>\n" +
+		"</body>\n</html>\n"
+	if !strings.HasPrefix(string(page), "<!DOCTYPE html>\n") || !strings.HasSuffix(string(page), want) {
+		t.Errorf("formatHTML() = %q, want it to end with %q", page, want)
+	}
+	if !strings.Contains(string(page), "<title>Transcript</title>") {
+		t.Errorf("formatHTML() has no title: %q", page)
+	}
+}
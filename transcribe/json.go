@@ -0,0 +1,95 @@
+package transcribe
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spiritorai/spiritor/avmedia"
+)
+
+// JSONSchemaVersion is the version of the json output document. It must be
+// incremented for any change which is not backwards compatible, eg: renaming or
+// removing a field. Adding new fields does not require a new version.
+const JSONSchemaVersion = 1
+
+// JSONDocument is the top level structure of the json output format.
+type JSONDocument struct {
+	SchemaVersion int         `json:"schema_version"`
+	Source        *JSONSource `json:"source,omitempty"`
+	Transcript    Transcript  `json:"transcript"`
+	Clean         *JSONClean  `json:"clean,omitempty"`
+}
+
+// JSONClean is the clean read of the transcript along with every span of the
+// verbatim transcript which the cleanup removed, so that the edits can be audited.
+type JSONClean struct {
+	Text    string    `json:"text"`
+	Removed []Removal `json:"removed"`
+}
+
+// JSONSource is the metadata of the source media file which was transcribed.
+type JSONSource struct {
+	Name     string  `json:"name"`     // file name, eg: zoom.mp3
+	Path     string  `json:"path"`     // file path, eg: /my/docs/zoom.mp3
+	Ext      string  `json:"ext"`      // file extension, eg: mp3
+	Size     int64   `json:"size"`     // file size in bytes
+	Duration float64 `json:"duration"` // playback length in seconds
+	Bitrate  int     `json:"bitrate"`  // encoded bitrate
+
+	Format      string `json:"format,omitempty"`      // container format names, eg: mov,mp4,m4a,3gp,3g2,mj2
+	AudioStream int    `json:"audio_stream"`          // index of the transcribed audio stream
+	SampleRate  int    `json:"sample_rate,omitempty"` // sample rate of the audio stream in Hz
+	Channels    int    `json:"channels,omitempty"`    // channel count of the audio stream
+}
+
+func newJSONSource(media avmedia.Media) *JSONSource {
+	return &JSONSource{
+		Name:     media.GetName(),
+		Path:     media.GetPath(),
+		Ext:      media.GetExt(),
+		Size:     media.GetSize(),
+		Duration: media.GetDuration().Seconds(),
+		Bitrate:  media.GetBitrate(),
+
+		Format:      media.GetFormat(),
+		AudioStream: media.GetAudioStream(),
+		SampleRate:  media.GetSampleRate(),
+		Channels:    media.GetChannels(),
+	}
+}
+
+func (ts Transcript) formatJSON(opts FormatOptions) ([]byte, error) {
+
+	// Empty lists are always written as arrays rather than null so that consumers
+	// do not need to handle both cases.
+	if ts.Words == nil {
+		ts.Words = []Word{}
+	}
+	if ts.Segments == nil {
+		ts.Segments = []Segment{}
+	}
+
+	doc := JSONDocument{
+		SchemaVersion: JSONSchemaVersion,
+		Transcript:    ts,
+	}
+
+	if opts.SourceMedia != nil {
+		doc.Source = newJSONSource(*opts.SourceMedia)
+	}
+
+	if opts.Clean != nil {
+		clean, removed := ts.Clean(*opts.Clean)
+		if removed == nil {
+			removed = []Removal{}
+		}
+		doc.Clean = &JSONClean{Text: clean.Text, Removed: removed}
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json: %w", err)
+	}
+
+	return append(data, '\n'), nil
+}
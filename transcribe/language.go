@@ -0,0 +1,72 @@
+package transcribe
+
+import (
+	"sort"
+	"strings"
+)
+
+// LanguageAuto lets the engine detect the spoken language, which is then
+// recorded in the transcript.
+const LanguageAuto = "auto"
+
+// whisperLanguages maps the ISO-639-1 codes which whisper supports to the names
+// which the api returns as the detected language.
+var whisperLanguages = map[string]string{
+	"af": "afrikaans", "am": "amharic", "ar": "arabic", "as": "assamese", "az": "azerbaijani",
+	"ba": "bashkir", "be": "belarusian", "bg": "bulgarian", "bn": "bengali", "bo": "tibetan",
+	"br": "breton", "bs": "bosnian", "ca": "catalan", "cs": "czech", "cy": "welsh",
+	"da": "danish", "de": "german", "el": "greek", "en": "english", "es": "spanish",
+	"et": "estonian", "eu": "basque", "fa": "persian", "fi": "finnish", "fo": "faroese",
+	"fr": "french", "gl": "galician", "gu": "gujarati", "ha": "hausa", "haw": "hawaiian",
+	"he": "hebrew", "hi": "hindi", "hr": "croatian", "ht": "haitian creole", "hu": "hungarian",
+	"hy": "armenian", "id": "indonesian", "is": "icelandic", "it": "italian", "ja": "japanese",
+	"jw": "javanese", "ka": "georgian", "kk": "kazakh", "km": "khmer", "kn": "kannada",
+	"ko": "korean", "la": "latin", "lb": "luxembourgish", "ln": "lingala", "lo": "lao",
+	"lt": "lithuanian", "lv": "latvian", "mg": "malagasy", "mi": "maori", "mk": "macedonian",
+	"ml": "malayalam", "mn": "mongolian", "mr": "marathi", "ms": "malay", "mt": "maltese",
+	"my": "myanmar", "ne": "nepali", "nl": "dutch", "nn": "nynorsk", "no": "norwegian",
+	"oc": "occitan", "pa": "punjabi", "pl": "polish", "ps": "pashto", "pt": "portuguese",
+	"ro": "romanian", "ru": "russian", "sa": "sanskrit", "sd": "sindhi", "si": "sinhala",
+	"sk": "slovak", "sl": "slovenian", "sn": "shona", "so": "somali", "sq": "albanian",
+	"sr": "serbian", "su": "sundanese", "sv": "swedish", "sw": "swahili", "ta": "tamil",
+	"te": "telugu", "tg": "tajik", "th": "thai", "tk": "turkmen", "tl": "tagalog",
+	"tr": "turkish", "tt": "tatar", "uk": "ukrainian", "ur": "urdu", "uz": "uzbek",
+	"vi": "vietnamese", "yi": "yiddish", "yo": "yoruba", "yue": "cantonese", "zh": "chinese",
+}
+
+// LanguageAllowed reports whether the language is auto or a code which whisper
+// supports.
+func LanguageAllowed(language string) bool {
+	if language == LanguageAuto {
+		return true
+	}
+	_, ok := whisperLanguages[language]
+	return ok
+}
+
+// Languages returns the sorted codes of all supported languages.
+func Languages() []string {
+	codes := make([]string, 0, len(whisperLanguages))
+	for code := range whisperLanguages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// NormalizeLanguage returns the ISO-639-1 code of a language which is given
+// either as a code or as a name, eg: english from the OpenAI api, so that the
+// transcripts of every engine record the language the same way. Unknown
+// languages are returned lowercased.
+func NormalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if _, ok := whisperLanguages[language]; ok {
+		return language
+	}
+	for code, name := range whisperLanguages {
+		if name == language {
+			return code
+		}
+	}
+	return language
+}
@@ -0,0 +1,15 @@
+package transcribe
+
+const (
+	localBaseURL = "http://localhost:8000/v1"
+	localModel   = "whisper-1"
+)
+
+// NewLocal initializes an engine for a self-hosted server which implements the
+// OpenAI transcriptions api, eg: faster-whisper-server or the whisper.cpp server,
+// so that audio never leaves the machine or network. The api key is optional and
+// only sent if given. Servers which do not return word timestamps are supported,
+// the subtitle timings are then estimated from the segments.
+func NewLocal(config EngineConfig) (*OpenAI, error) {
+	return newOpenAI(config, EngineLocal, localBaseURL, localModel, false)
+}
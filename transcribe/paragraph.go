@@ -0,0 +1,121 @@
+package transcribe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// ParagraphOptions controls how the text writers break the transcript up into
+// paragraphs. Zero values are replaced by the defaults.
+type ParagraphOptions struct {
+	MaxSentences int           // max sentences per paragraph, 1 for one sentence per paragraph
+	PauseGap     time.Duration // min pause between segments which starts a new paragraph
+}
+
+const (
+	defaultMaxSentences int           = 5
+	defaultPauseGap     time.Duration = 2 * time.Second
+)
+
+func (opts ParagraphOptions) withDefaults() ParagraphOptions {
+	if opts.MaxSentences <= 0 {
+		opts.MaxSentences = defaultMaxSentences
+	}
+	if opts.PauseGap <= 0 {
+		opts.PauseGap = defaultPauseGap
+	}
+	return opts
+}
+
+// paragraph is a group of sentences of a single speaker, times are in seconds.
+type paragraph struct {
+	speaker   string
+	turn      bool // first paragraph of a speaker turn
+	start     float64
+	end       float64
+	sentences []string
+}
+
+func (p paragraph) text() string {
+	return strings.Join(p.sentences, " ")
+}
+
+// passage is a run of segments without a speaker change or a long pause, which
+// is split into paragraphs by the sentence count.
+type passage struct {
+	speaker string
+	turn    bool
+	start   float64
+	end     float64
+	text    string
+}
+
+// paragraphs breaks the transcript into paragraphs. A new paragraph starts on
+// every speaker change, after a pause between segments which ends a sentence, and
+// once a paragraph has the max sentences. Without segments the whole text is
+// split by the sentence count only.
+func (ts Transcript) paragraphs(opts ParagraphOptions) ([]paragraph, error) {
+
+	opts = opts.withDefaults()
+
+	var passages []passage
+	if len(ts.Segments) == 0 {
+		passages = []passage{{end: ts.Duration, text: strings.TrimSpace(ts.Text)}}
+	}
+
+	for _, segment := range ts.Segments {
+		text := strings.TrimSpace(segment.Text)
+		if text == "" {
+			continue
+		}
+
+		if n := len(passages); n > 0 {
+			prev := &passages[n-1]
+			speakerChange := prev.speaker != segment.Speaker
+			pause := segment.Start-prev.end >= opts.PauseGap.Seconds() && endsWithSentence(prev.text)
+			if !speakerChange && !pause {
+				prev.text += " " + text
+				prev.end = segment.End
+				continue
+			}
+		}
+
+		passages = append(passages, passage{
+			speaker: segment.Speaker,
+			turn:    len(passages) == 0 || passages[len(passages)-1].speaker != segment.Speaker,
+			start:   segment.Start,
+			end:     segment.End,
+			text:    text,
+		})
+	}
+
+	var paragraphs []paragraph
+	for _, p := range passages {
+		sentences, err := utils.SplitSentences(p.text, NormalizeLanguage(ts.Language))
+		if err != nil {
+			return nil, fmt.Errorf("failed to split sentences: %w", err)
+		}
+
+		// The sentences have no timing of their own, so the times of the paragraphs
+		// within a passage are spread by their share of the text.
+		total := max(len(p.text), 1)
+		offset := 0
+		for i := 0; i < len(sentences); i += opts.MaxSentences {
+			chunk := sentences[i:min(i+opts.MaxSentences, len(sentences))]
+			length := len(strings.Join(chunk, " ")) + 1
+			paragraphs = append(paragraphs, paragraph{
+				speaker:   p.speaker,
+				turn:      p.turn && i == 0,
+				start:     p.start + (p.end-p.start)*float64(offset)/float64(total),
+				end:       p.start + (p.end-p.start)*float64(min(offset+length, total))/float64(total),
+				sentences: chunk,
+			})
+			offset += length
+		}
+	}
+
+	return paragraphs, nil
+}
@@ -0,0 +1,111 @@
+package transcribe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParagraphs(t *testing.T) {
+
+	type want struct {
+		speaker string
+		turn    bool
+		text    string
+	}
+
+	tests := []struct {
+		name string
+		ts   Transcript
+		opts ParagraphOptions
+		want []want
+	}{
+		{
+			name: "segments without a pause are joined",
+			ts: Transcript{Segments: []Segment{
+				{Start: 0, End: 2, Text: " First one."},
+				{Start: 3, End: 5, Text: " Second one."},
+			}},
+			want: []want{{turn: true, text: "First one. Second one."}},
+		},
+		{
+			name: "pause after a sentence starts a paragraph",
+			ts: Transcript{Segments: []Segment{
+				{Start: 0, End: 2, Text: " First one."},
+				{Start: 4, End: 6, Text: " Second one."},
+			}},
+			want: []want{{turn: true, text: "First one."}, {text: "Second one."}},
+		},
+		{
+			name: "pause within a sentence does not",
+			ts: Transcript{Segments: []Segment{
+				{Start: 0, End: 2, Text: " First and"},
+				{Start: 5, End: 6, Text: " second."},
+			}},
+			want: []want{{turn: true, text: "First and second."}},
+		},
+		{
+			name: "speaker change starts a turn",
+			ts: Transcript{Segments: []Segment{
+				{Start: 0, End: 1, Text: " Hi.", Speaker: "Ann"},
+				{Start: 1, End: 2, Text: " Hello.", Speaker: "Bob"},
+				{Start: 2, End: 3, Text: " Bye.", Speaker: "Ann"},
+			}},
+			want: []want{
+				{speaker: "Ann", turn: true, text: "Hi."},
+				{speaker: "Bob", turn: true, text: "Hello."},
+				{speaker: "Ann", turn: true, text: "Bye."},
+			},
+		},
+		{
+			name: "split at the max sentences",
+			ts: Transcript{Segments: []Segment{
+				{Start: 0, End: 5, Text: " One. Two. Three.", Speaker: "Ann"},
+			}},
+			opts: ParagraphOptions{MaxSentences: 2},
+			want: []want{
+				{speaker: "Ann", turn: true, text: "One. Two."},
+				{speaker: "Ann", text: "Three."},
+			},
+		},
+		{
+			name: "text without segments",
+			ts:   Transcript{Text: " One. Two. Three.", Duration: 3},
+			opts: ParagraphOptions{MaxSentences: 1, PauseGap: time.Second},
+			want: []want{{text: "One."}, {text: "Two."}, {text: "Three."}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paragraphs, err := tt.ts.paragraphs(tt.opts)
+			if err != nil {
+				t.Fatalf("paragraphs() error = %v", err)
+			}
+			var got []want
+			for _, p := range paragraphs {
+				got = append(got, want{speaker: p.speaker, turn: p.turn, text: p.text()})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paragraphs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParagraphTimes(t *testing.T) {
+
+	// The paragraphs of a passage share its time by their share of the text.
+	ts := Transcript{Segments: []Segment{{Start: 10, End: 20, Text: " Aaaa. Bbbb."}}}
+	paragraphs, err := ts.paragraphs(ParagraphOptions{MaxSentences: 1})
+	if err != nil {
+		t.Fatalf("paragraphs() error = %v", err)
+	}
+	if len(paragraphs) != 2 {
+		t.Fatalf("paragraphs = %+v, want 2", paragraphs)
+	}
+	if paragraphs[0].start != 10 || paragraphs[0].end <= 10 || paragraphs[0].end != paragraphs[1].start || paragraphs[1].end != 20 {
+		t.Errorf("times = %v-%v and %v-%v, want 10-20 split between them",
+			paragraphs[0].start, paragraphs[0].end, paragraphs[1].start, paragraphs[1].end)
+	}
+}
@@ -0,0 +1,94 @@
+package transcribe
+
+// SpeakerTurn is a period where a single speaker is talking, times are in
+// seconds from the start of the media.
+type SpeakerTurn struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Speaker string  `json:"speaker"`
+}
+
+// AssignSpeakers returns a copy of the transcript where each segment is labeled
+// with the speaker whose turns overlap it the most. Segments which do not overlap
+// any turn, eg: during a pause, get the speaker of the nearest turn.
+func (ts Transcript) AssignSpeakers(turns []SpeakerTurn) Transcript {
+
+	if len(turns) == 0 {
+		return ts
+	}
+
+	segments := make([]Segment, len(ts.Segments))
+	copy(segments, ts.Segments)
+
+	for i, segment := range segments {
+
+		overlaps := map[string]float64{}
+		best := ""
+		for _, turn := range turns {
+			overlap := min(segment.End, turn.End) - max(segment.Start, turn.Start)
+			if overlap <= 0 {
+				continue
+			}
+			overlaps[turn.Speaker] += overlap
+			if best == "" || overlaps[turn.Speaker] > overlaps[best] {
+				best = turn.Speaker
+			}
+		}
+
+		if best == "" {
+			nearest := -1.0
+			for _, turn := range turns {
+				distance := max(turn.Start-segment.End, segment.Start-turn.End)
+				if nearest < 0 || distance < nearest {
+					nearest = distance
+					best = turn.Speaker
+				}
+			}
+		}
+
+		segments[i].Speaker = best
+	}
+
+	ts.Segments = segments
+	return ts
+}
+
+// RenameSpeakers returns a copy of the transcript with the speaker labels
+// replaced by the names in the map, eg: SPEAKER_1 to Alice. Labels which are
+// not in the map are kept.
+func (ts Transcript) RenameSpeakers(names map[string]string) Transcript {
+
+	if len(names) == 0 {
+		return ts
+	}
+
+	segments := make([]Segment, len(ts.Segments))
+	copy(segments, ts.Segments)
+
+	for i, segment := range segments {
+		if name, ok := names[segment.Speaker]; ok && name != "" {
+			segments[i].Speaker = name
+		}
+	}
+
+	ts.Segments = segments
+	return ts
+}
+
+// HasSpeakers reports whether any segment is labeled with a speaker.
+func (ts Transcript) HasSpeakers() bool {
+	for _, segment := range ts.Segments {
+		if segment.Speaker != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// speakerPrefix is written before the text of a speaker in the text writers.
+func speakerPrefix(speaker string) string {
+	if speaker == "" {
+		return ""
+	}
+	return speaker + ": "
+}
@@ -0,0 +1,131 @@
+package transcribe
+
+import (
+	"math"
+	"strings"
+)
+
+// ChunkTranscript is the transcript of a single chunk of a larger media file. The
+// offset is the start of the chunk in seconds from the start of the full media,
+// and the duration is the length of the chunk media. Engines do not always
+// return the duration of the transcript, so it is only used when the duration
+// of the chunk is not set.
+type ChunkTranscript struct {
+	Transcript Transcript
+	Offset     float64
+	Duration   float64
+}
+
+// length returns the length of the chunk in seconds. Without any duration it
+// falls back to the end of the last segment or word.
+func (chunk ChunkTranscript) length() float64 {
+	if chunk.Duration > 0 {
+		return chunk.Duration
+	}
+	if chunk.Transcript.Duration > 0 {
+		return chunk.Transcript.Duration
+	}
+
+	var end float64
+	for _, segment := range chunk.Transcript.Segments {
+		end = math.Max(end, segment.End)
+	}
+	for _, word := range chunk.Transcript.Words {
+		end = math.Max(end, word.End)
+	}
+	return end
+}
+
+// maxOverlapWords is the most words which are compared when removing duplicated
+// text between chunks that have no segments.
+const maxOverlapWords = 50
+
+// Stitch combines the transcripts of consecutive overlapping chunks back into a
+// single transcript. All segment and word timestamps are shifted by the chunk
+// offset. The overlap between two chunks is split at its midpoint, each chunk
+// keeps the segments and words which fall on its side of the midpoint. If the
+// chunks have no segments then the repeated text at the start of each chunk is
+// removed instead.
+func Stitch(chunks []ChunkTranscript) Transcript {
+
+	var final Transcript
+	if len(chunks) == 0 {
+		return final
+	}
+
+	final.Task = chunks[0].Transcript.Task
+	final.Language = chunks[0].Transcript.Language
+
+	var texts []string
+	for i, chunk := range chunks {
+
+		ts := chunk.Transcript
+
+		// Each chunk owns the time between the midpoints of its overlaps with the
+		// previous and next chunks.
+		chunkEnd := chunk.Offset + chunk.length()
+		lo := math.Inf(-1)
+		if i > 0 {
+			prevEnd := chunks[i-1].Offset + chunks[i-1].length()
+			lo = (chunk.Offset + math.Min(prevEnd, chunkEnd)) / 2
+		}
+		hi := math.Inf(1)
+		if i < len(chunks)-1 {
+			hi = (chunks[i+1].Offset + chunkEnd) / 2
+		}
+
+		for _, segment := range ts.Segments {
+			segment.Start += chunk.Offset
+			segment.End += chunk.Offset
+			if mid := (segment.Start + segment.End) / 2; mid < lo || mid >= hi {
+				continue
+			}
+			segment.ID = len(final.Segments)
+			final.Segments = append(final.Segments, segment)
+			texts = append(texts, strings.TrimSpace(segment.Text))
+		}
+
+		for _, word := range ts.Words {
+			word.Start += chunk.Offset
+			word.End += chunk.Offset
+			if mid := (word.Start + word.End) / 2; mid < lo || mid >= hi {
+				continue
+			}
+			final.Words = append(final.Words, word)
+		}
+
+		if len(ts.Segments) == 0 {
+			text := strings.Fields(ts.Text)
+			if i > 0 && len(texts) > 0 {
+				text = text[overlapWords(strings.Fields(texts[len(texts)-1]), text):]
+			}
+			texts = append(texts, strings.Join(text, " "))
+		}
+
+		final.Duration = math.Max(final.Duration, chunkEnd)
+	}
+
+	final.Text = strings.Join(texts, " ")
+
+	return final
+}
+
+// overlapWords returns the number of leading words in next which repeat the
+// trailing words of prev, ignoring case and punctuation. At least two words must
+// match to avoid removing common single words.
+func overlapWords(prev, next []string) int {
+	limit := min(len(prev), len(next), maxOverlapWords)
+	for n := limit; n >= 2; n-- {
+		match := true
+		for i := 0; i < n; i++ {
+			if NormalizeWord(prev[len(prev)-n+i]) != NormalizeWord(next[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return n
+		}
+	}
+	return 0
+}
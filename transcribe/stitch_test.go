@@ -0,0 +1,148 @@
+package transcribe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStitch(t *testing.T) {
+
+	// Two 60s chunks which overlap by 10s, from 50s to 60s. The overlap is split
+	// at 55s, so "two" is kept from the first chunk and dropped from the second.
+	first := Transcript{
+		Task:     "transcribe",
+		Language: "english",
+		Text:     "One. Two.",
+		Segments: []Segment{
+			{Start: 0, End: 20, Text: " One."},
+			{Start: 50, End: 54, Text: " Two."},
+		},
+		Words: []Word{
+			{Word: "One", Start: 0, End: 1},
+			{Word: "Two", Start: 50, End: 54},
+		},
+	}
+	second := Transcript{
+		Text: "Two. Three. Four.",
+		Segments: []Segment{
+			{Start: 0, End: 4, Text: " Two."},
+			{Start: 6, End: 20, Text: " Three."},
+			{Start: 40, End: 50, Text: " Four."},
+		},
+		Words: []Word{
+			{Word: "Two", Start: 0, End: 4},
+			{Word: "Three", Start: 6, End: 7},
+			{Word: "Four", Start: 40, End: 41},
+		},
+	}
+	withDuration := func(ts Transcript, duration float64) Transcript {
+		ts.Duration = duration
+		return ts
+	}
+
+	wantSegments := []Segment{
+		{ID: 0, Start: 0, End: 20, Text: " One."},
+		{ID: 1, Start: 50, End: 54, Text: " Two."},
+		{ID: 2, Start: 56, End: 70, Text: " Three."},
+		{ID: 3, Start: 90, End: 100, Text: " Four."},
+	}
+	wantWords := []Word{
+		{Word: "One", Start: 0, End: 1},
+		{Word: "Two", Start: 50, End: 54},
+		{Word: "Three", Start: 56, End: 57},
+		{Word: "Four", Start: 90, End: 91},
+	}
+
+	tests := []struct {
+		name   string
+		chunks []ChunkTranscript
+	}{
+		{
+			name: "transcript duration",
+			chunks: []ChunkTranscript{
+				{Transcript: withDuration(first, 60), Offset: 0},
+				{Transcript: withDuration(second, 60), Offset: 50},
+			},
+		},
+		{
+			name: "chunk duration",
+			chunks: []ChunkTranscript{
+				{Transcript: withDuration(first, 60), Offset: 0, Duration: 60},
+				{Transcript: withDuration(second, 60), Offset: 50, Duration: 60},
+			},
+		},
+		{
+			// Engines which return no duration must not lose the second half of
+			// each chunk.
+			name: "engine without duration",
+			chunks: []ChunkTranscript{
+				{Transcript: first, Offset: 0, Duration: 60},
+				{Transcript: second, Offset: 50, Duration: 60},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Stitch(tt.chunks)
+
+			if !reflect.DeepEqual(got.Segments, wantSegments) {
+				t.Errorf("segments = %+v, want %+v", got.Segments, wantSegments)
+			}
+			if !reflect.DeepEqual(got.Words, wantWords) {
+				t.Errorf("words = %+v, want %+v", got.Words, wantWords)
+			}
+			if want := "One. Two. Three. Four."; got.Text != want {
+				t.Errorf("text = %q, want %q", got.Text, want)
+			}
+			if got.Duration != 110 {
+				t.Errorf("duration = %v, want 110", got.Duration)
+			}
+			if got.Task != "transcribe" || got.Language != "english" {
+				t.Errorf("task, language = %v, %v, want transcribe, english", got.Task, got.Language)
+			}
+		})
+	}
+}
+
+func TestStitchText(t *testing.T) {
+
+	// Without segments the text repeated at the start of a chunk is removed.
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{
+			name:   "overlap",
+			chunks: []string{"we went to the store and", "The store, and then we left."},
+			want:   "we went to the store and then we left.",
+		},
+		{
+			name:   "single word is kept",
+			chunks: []string{"it was the", "the end"},
+			want:   "it was the the end",
+		},
+		{
+			name:   "no overlap",
+			chunks: []string{"first part", "second part"},
+			want:   "first part second part",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks []ChunkTranscript
+			for i, text := range tt.chunks {
+				chunks = append(chunks, ChunkTranscript{
+					Transcript: Transcript{Text: text},
+					Offset:     float64(i) * 50,
+					Duration:   60,
+				})
+			}
+			if got := Stitch(chunks).Text; got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
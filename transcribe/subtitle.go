@@ -0,0 +1,236 @@
+package transcribe
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SubtitleOptions controls how the transcript is broken up into subtitle cues.
+// Zero values are replaced by the defaults.
+type SubtitleOptions struct {
+	MaxLineLength  int           // max characters per line, eg: 42
+	MaxLines       int           // max lines per cue, eg: 2
+	MaxCueDuration time.Duration // max time a single cue stays on screen
+}
+
+const (
+	defaultMaxLineLength  int           = 42
+	defaultMaxLines       int           = 2
+	defaultMaxCueDuration time.Duration = 7 * time.Second
+)
+
+func (opts SubtitleOptions) withDefaults() SubtitleOptions {
+	if opts.MaxLineLength <= 0 {
+		opts.MaxLineLength = defaultMaxLineLength
+	}
+	if opts.MaxLines <= 0 {
+		opts.MaxLines = defaultMaxLines
+	}
+	if opts.MaxCueDuration <= 0 {
+		opts.MaxCueDuration = defaultMaxCueDuration
+	}
+	return opts
+}
+
+// cue is a single block of subtitle text, times are in seconds.
+type cue struct {
+	start float64
+	end   float64
+	lines []string
+}
+
+// timedWord is a display word (with punctuation) along with its timing.
+type timedWord struct {
+	text  string
+	start float64
+	end   float64
+}
+
+// buildCues breaks the transcript segments up into cues based on the subtitle
+// options. Cues never span multiple segments since the segments are the natural
+// phrase boundaries from the transcription engine. If the segment has a speaker
+// then every cue starts with it, counted as part of the first line.
+func (ts Transcript) buildCues(opts SubtitleOptions) ([]cue, error) {
+
+	if len(ts.Segments) == 0 {
+		return nil, fmt.Errorf("transcript has no segments: timing data is required for subtitles")
+	}
+
+	opts = opts.withDefaults()
+	maxSeconds := opts.MaxCueDuration.Seconds()
+
+	var cues []cue
+	for _, segment := range ts.Segments {
+
+		// The prefix is a timed word so that it is wrapped along with the text, a cue
+		// is never left with only the prefix.
+		var prefix []timedWord
+		if segment.Speaker != "" {
+			prefix = []timedWord{{text: strings.TrimSpace(speakerPrefix(segment.Speaker))}}
+		}
+
+		var current []timedWord
+		flush := func() {
+			if len(current) <= len(prefix) {
+				current = nil
+				return
+			}
+			cues = append(cues, cue{
+				start: current[len(prefix)].start,
+				end:   current[len(current)-1].end,
+				lines: wrapWords(current, opts.MaxLineLength),
+			})
+			current = nil
+		}
+
+		for _, word := range segmentWords(segment, ts.Words) {
+			if len(current) > len(prefix) {
+				next := append(current[:len(current):len(current)], word)
+				if len(wrapWords(next, opts.MaxLineLength)) > opts.MaxLines || word.end-current[len(prefix)].start > maxSeconds {
+					flush()
+				}
+			}
+			if len(current) == 0 {
+				current = append(current, prefix...)
+			}
+			current = append(current, word)
+		}
+		flush()
+	}
+
+	return cues, nil
+}
+
+// segmentWords splits the segment text into display words. If word level timing
+// is available and lines up with the segment text then it is used, otherwise the
+// segment time is spread across the words based on their length.
+func segmentWords(segment Segment, words []Word) []timedWord {
+
+	tokens := strings.Fields(segment.Text)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var matched []Word
+	for _, word := range words {
+		mid := (word.Start + word.End) / 2
+		if mid >= segment.Start && mid < segment.End {
+			matched = append(matched, word)
+		}
+	}
+
+	timed := make([]timedWord, len(tokens))
+
+	if len(matched) == len(tokens) && wordsAlign(tokens, matched) {
+		for i, token := range tokens {
+			timed[i] = timedWord{text: token, start: matched[i].Start, end: matched[i].End}
+		}
+		return timed
+	}
+
+	var total int
+	for _, token := range tokens {
+		total += len(token)
+	}
+
+	offset := segment.Start
+	span := segment.End - segment.Start
+	for i, token := range tokens {
+		length := span * float64(len(token)) / float64(total)
+		timed[i] = timedWord{text: token, start: offset, end: offset + length}
+		offset += length
+	}
+
+	return timed
+}
+
+// wordsAlign reports whether the display tokens and the timed words are the same
+// words, ignoring case and punctuation.
+func wordsAlign(tokens []string, words []Word) bool {
+	for i := range tokens {
+		if NormalizeWord(tokens[i]) != NormalizeWord(words[i].Word) {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeWord lowercases the word and trims its punctuation so that words can
+// be compared between texts, eg: "Hello," and "hello".
+func NormalizeWord(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}))
+}
+
+// wrapWords greedily fills lines up to the max length. A single word longer than
+// the max length is placed on its own line rather than being broken.
+func wrapWords(words []timedWord, maxLength int) []string {
+	var lines []string
+	var line string
+	for _, word := range words {
+		switch {
+		case line == "":
+			line = word.text
+		case len(line)+1+len(word.text) <= maxLength:
+			line += " " + word.text
+		default:
+			lines = append(lines, line)
+			line = word.text
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// formatTimestamp formats seconds as hh:mm:ss followed by the millisecond
+// separator and the milliseconds, eg: 00:01:02,500 for srt.
+func formatTimestamp(seconds float64, separator string) string {
+	ms := int64(math.Round(math.Max(seconds, 0) * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%v%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
+
+func (ts Transcript) formatSRT(opts SubtitleOptions) ([]byte, error) {
+
+	cues, err := ts.buildCues(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	for i, c := range cues {
+		fmt.Fprintf(&b, "%d\n%v --> %v\n%v\n\n",
+			i+1,
+			formatTimestamp(c.start, ","),
+			formatTimestamp(c.end, ","),
+			strings.Join(c.lines, "\n"),
+		)
+	}
+
+	return []byte(b.String()), nil
+}
+
+func (ts Transcript) formatVTT(opts SubtitleOptions) ([]byte, error) {
+
+	cues, err := ts.buildCues(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(&b, "%v --> %v\n%v\n\n",
+			formatTimestamp(c.start, "."),
+			formatTimestamp(c.end, "."),
+			strings.Join(c.lines, "\n"),
+		)
+	}
+
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,152 @@
+package transcribe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWrapWords(t *testing.T) {
+
+	words := func(texts ...string) []timedWord {
+		timed := make([]timedWord, len(texts))
+		for i, text := range texts {
+			timed[i] = timedWord{text: text}
+		}
+		return timed
+	}
+
+	tests := []struct {
+		name      string
+		words     []timedWord
+		maxLength int
+		want      []string
+	}{
+		{"fits on one line", words("one", "two", "three"), 13, []string{"one two three"}},
+		{"wraps at the max length", words("one", "two", "three"), 12, []string{"one two", "three"}},
+		{"long word on its own line", words("a", "extraordinarily", "b"), 5, []string{"a", "extraordinarily", "b"}},
+		{"no words", nil, 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapWords(tt.words, tt.maxLength); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrapWords() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCues(t *testing.T) {
+
+	tests := []struct {
+		name string
+		ts   Transcript
+		opts SubtitleOptions
+		want []cue
+	}{
+		{
+			name: "segment in a single cue",
+			ts:   timedTranscript("Hello there."),
+			want: []cue{{start: 0, end: 1.9, lines: []string{"Hello there."}}},
+		},
+		{
+			name: "split at the max lines",
+			ts:   timedTranscript("one two three four five"),
+			opts: SubtitleOptions{MaxLineLength: 9, MaxLines: 1},
+			want: []cue{
+				{start: 0, end: 1.9, lines: []string{"one two"}},
+				{start: 2, end: 2.9, lines: []string{"three"}},
+				{start: 3, end: 4.9, lines: []string{"four five"}},
+			},
+		},
+		{
+			name: "split at the max duration",
+			ts:   timedTranscript("one two three four five"),
+			opts: SubtitleOptions{MaxCueDuration: 2 * time.Second},
+			want: []cue{
+				{start: 0, end: 1.9, lines: []string{"one two"}},
+				{start: 2, end: 3.9, lines: []string{"three four"}},
+				{start: 4, end: 4.9, lines: []string{"five"}},
+			},
+		},
+		{
+			name: "speaker prefix on every cue",
+			ts: func() Transcript {
+				ts := timedTranscript("one two three")
+				ts.Segments[0].Speaker = "Ann"
+				return ts
+			}(),
+			opts: SubtitleOptions{MaxLineLength: 12, MaxLines: 1},
+			want: []cue{
+				{start: 0, end: 1.9, lines: []string{"Ann: one two"}},
+				{start: 2, end: 2.9, lines: []string{"Ann: three"}},
+			},
+		},
+		{
+			name: "segment time spread without word timing",
+			ts: Transcript{Segments: []Segment{
+				{Start: 10, End: 13, Text: " ab abcd"},
+				{Start: 13, End: 14, Text: " next"},
+			}},
+			opts: SubtitleOptions{MaxLineLength: 4, MaxLines: 1},
+			want: []cue{
+				{start: 10, end: 11, lines: []string{"ab"}},
+				{start: 11, end: 13, lines: []string{"abcd"}},
+				{start: 13, end: 14, lines: []string{"next"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.ts.buildCues(tt.opts)
+			if err != nil {
+				t.Fatalf("buildCues() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildCues() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (Transcript{Text: "no segments"}).buildCues(SubtitleOptions{}); err == nil {
+		t.Errorf("buildCues() without segments error = nil, want error")
+	}
+}
+
+func TestFormatSubtitles(t *testing.T) {
+
+	ts := Transcript{
+		Words: []Word{
+			{Word: "Hello", Start: 0.5, End: 1},
+			{Word: "there", Start: 1.1, End: 1.6},
+			{Word: "Bye", Start: 3661.25, End: 3662},
+		},
+		Segments: []Segment{
+			{Start: 0.5, End: 1.6, Text: " Hello there."},
+			{Start: 3661, End: 3662, Text: " Bye."},
+		},
+	}
+
+	srt, err := ts.formatSRT(SubtitleOptions{})
+	if err != nil {
+		t.Fatalf("formatSRT() error = %v", err)
+	}
+	wantSRT := "1\n00:00:00,500 --> 00:00:01,600\nHello there.\n\n" +
+		"2\n01:01:01,250 --> 01:01:02,000\nBye.\n\n"
+	if string(srt) != wantSRT {
+		t.Errorf("formatSRT() = %q, want %q", srt, wantSRT)
+	}
+
+	vtt, err := ts.formatVTT(SubtitleOptions{})
+	if err != nil {
+		t.Fatalf("formatVTT() error = %v", err)
+	}
+	wantVTT := "WEBVTT\n\n" +
+		"00:00:00.500 --> 00:00:01.600\nHello there.\n\n" +
+		"01:01:01.250 --> 01:01:02.000\nBye.\n\n"
+	if string(vtt) != wantVTT {
+		t.Errorf("formatVTT() = %q, want %q", vtt, wantVTT)
+	}
+}
@@ -0,0 +1,214 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// Transcriber is implemented by every transcription engine. Implementations must
+// be safe for concurrent use since a single instance is shared by all of the
+// transcription workers.
+type Transcriber interface {
+	Transcribe(ctx context.Context, media avmedia.Media) (Transcript, error)
+	Info() EngineInfo
+}
+
+// EngineInfo describes the settings which affect the transcript produced by an
+// engine, eg: for cache keys. Two transcribers with equal info are expected to
+// produce equivalent transcripts for the same audio.
+type EngineInfo struct {
+	Engine   string `json:"engine"`
+	Model    string `json:"model"`
+	Language string `json:"language"`
+	Prompt   string `json:"prompt,omitempty"`
+}
+
+// EngineConfig holds the common settings which are passed to an engine factory.
+// Each engine is responsible for applying its own defaults to empty values.
+type EngineConfig struct {
+	BaseURL  string        // api base url, eg: https://api.openai.com/v1
+	APIKey   string        // api key sent as a bearer token, if required
+	Model    string        // model name, eg: whisper-1
+	Language string        // ISO-639-1 language code, eg: en
+	Prompt   string        // optional text to guide the style or vocabulary
+	Timeout  time.Duration // max time for a single request, 0 for none
+
+	MaxRetries        int // max retries of a failed request, 0 for none
+	RequestsPerMinute int // max requests started per minute, 0 for no limit
+}
+
+// EngineFactory builds a new transcriber from the engine config.
+type EngineFactory func(config EngineConfig) (Transcriber, error)
+
+const (
+	EngineOpenAI = "openai"
+	EngineLocal  = "local"
+)
+
+var engines = utils.NewRegistry(map[string]EngineFactory{
+	EngineOpenAI: func(config EngineConfig) (Transcriber, error) {
+		return NewOpenAI(config)
+	},
+	EngineLocal: func(config EngineConfig) (Transcriber, error) {
+		return NewLocal(config)
+	},
+})
+
+// Checker is implemented by engines which can verify their setup without
+// transcribing anything, eg: that the api is reachable and the key is valid.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// RegisterEngine makes a transcription engine available by name. Registering an
+// existing name will replace the previous factory, which allows callers to swap
+// in self-hosted or mock engines without forking this package.
+func RegisterEngine(name string, factory EngineFactory) {
+	engines.Register(name, factory)
+}
+
+func EngineAllowed(engine string) bool {
+	_, ok := engines.Get(engine)
+	return ok
+}
+
+// Engines returns the sorted names of all registered engines.
+func Engines() []string {
+	return engines.Names()
+}
+
+// NewTranscriber builds the named engine from the config.
+func NewTranscriber(engine string, config EngineConfig) (Transcriber, error) {
+	factory, ok := engines.Get(engine)
+	if !ok {
+		return nil, fmt.Errorf("unsupported engine: %v", engine)
+	}
+	return factory(config)
+}
+
+const (
+	outputTXT  = "txt"
+	outputJSON = "json"
+	outputSRT  = "srt"
+	outputVTT  = "vtt"
+	outputMD   = "md"
+	outputHTML = "html"
+
+	outputCleanTXT = "clean.txt"
+)
+
+var supportedOutput = map[string]struct{}{
+	outputTXT:  {},
+	outputJSON: {},
+	outputSRT:  {},
+	outputVTT:  {},
+	outputMD:   {},
+	outputHTML: {},
+
+	outputCleanTXT: {},
+}
+
+func OutputAllowed(output string) bool {
+	if _, ok := supportedOutput[output]; !ok {
+		return false
+	}
+	return true
+}
+
+type Transcript struct {
+	Task     string    `json:"task"`
+	Language string    `json:"language"`
+	Duration float64   `json:"duration"`
+	Text     string    `json:"text"`
+	Words    []Word    `json:"words"`
+	Segments []Segment `json:"segments"`
+}
+
+type Word struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+type Segment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+	Speaker          string  `json:"speaker,omitempty"` // speaker label or name, set by diarization
+}
+
+// FormatOptions holds the settings for all of the output writers. The zero value
+// is valid and uses the defaults of each writer.
+type FormatOptions struct {
+	Subtitles   SubtitleOptions  // srt and vtt cue settings
+	Paragraphs  ParagraphOptions // txt, md and html paragraph settings
+	Document    DocumentOptions  // md and html settings
+	SourceMedia *avmedia.Media   // source file metadata for the json output, optional
+	Clean       *CleanOptions    // cleanup of the clean.txt output, also adds the removed spans to the json output if set
+}
+
+func (ts Transcript) Format(output string, opts FormatOptions) ([]byte, error) {
+	switch output {
+	case outputTXT:
+		return ts.formatTXT(opts.Paragraphs)
+
+	case outputJSON:
+		return ts.formatJSON(opts)
+
+	case outputSRT:
+		return ts.formatSRT(opts.Subtitles)
+
+	case outputVTT:
+		return ts.formatVTT(opts.Subtitles)
+
+	case outputMD:
+		return ts.formatMD(opts)
+
+	case outputHTML:
+		return ts.formatHTML(opts)
+
+	case outputCleanTXT:
+		var cleanOpts CleanOptions
+		if opts.Clean != nil {
+			cleanOpts = *opts.Clean
+		}
+		clean, _ := ts.Clean(cleanOpts)
+		return clean.formatTXT(opts.Paragraphs)
+
+	default:
+		return nil, fmt.Errorf("output format not supported: %v", output)
+	}
+}
+
+// formatTXT writes the paragraphs separated by blank lines. If the transcript has
+// speakers then the first paragraph of each speaker turn is prefixed with the
+// speaker.
+func (ts Transcript) formatTXT(opts ParagraphOptions) ([]byte, error) {
+
+	paragraphs, err := ts.paragraphs(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var texts []string
+	for _, p := range paragraphs {
+		text := p.text()
+		if p.turn {
+			text = speakerPrefix(p.speaker) + text
+		}
+		texts = append(texts, text)
+	}
+
+	return []byte(utils.CombineSentences(texts, "\n\n")), nil
+}
@@ -6,55 +6,108 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/spiritorai/spiritor/utils"
+	"github.com/spiritorai/spiritor/apiclient"
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/logging"
 )
 
-// TODO: The api key is required for whisper. It is read in from API_KEY_OPENAI by
-// init(). This op should be moved to the scribe package so as to be command specific
-// and return a cleaner error.
-var apiKey string
+const (
+	whisperMaxBytes        int64 = 25 * 1024 * 1024 // 25mb
+	whisperMaxBytesPadding int64 = 100 * 1024       // 100kb
+)
 
-func init() {
-	apiKey = os.Getenv("API_KEY_OPENAI")
-	if apiKey == "" {
-		log.Fatal("Missing value for API_KEY_OPENAI: Please set the env var.")
-	}
+func MaxUploadSize() int64 {
+	return (whisperMaxBytes - whisperMaxBytesPadding)
 }
 
 const (
-	outputTXT  = "txt"
-	outputJSON = "json"
-	outputSRT  = "srt"
-	outputVTT  = "vtt"
+	openAIBaseURL      = "https://api.openai.com/v1"
+	openAIModel        = "whisper-1"
+	openAILanguage     = "en"
+	openAITranscribeEP = "/audio/transcriptions"
+	openAIModelsEP     = "/models"
+
+	checkTimeout = 10 * time.Second
 )
 
-var supportedOutput = map[string]struct{}{
-	outputTXT: {},
+// OpenAI is the transcription engine for the OpenAI Whisper API, and for any
+// server which implements the same api, see NewLocal. The zero value is not
+// usable, use NewOpenAI to initialize it with defaults.
+type OpenAI struct {
+	apiclient.Client
+
+	Name     string // engine name reported by Info, eg: openai
+	Model    string // model name, eg: whisper-1
+	Language string // ISO-639-1 language code, eg: en
+	Prompt   string // optional text to guide the style or vocabulary
+}
+
+// NewOpenAI will initialize a new OpenAI engine from the config and fill in the
+// defaults for any empty values. The api key is required and it is the callers
+// responsibility to supply it, eg: from the config package.
+func NewOpenAI(config EngineConfig) (*OpenAI, error) {
+	return newOpenAI(config, EngineOpenAI, openAIBaseURL, openAIModel, true)
 }
 
-func OutputAllowed(output string) bool {
-	if _, ok := supportedOutput[output]; !ok {
-		return false
+// newOpenAI initializes an engine of the OpenAI api with the name, and the base
+// url and model for empty values. The api key is only checked if required.
+func newOpenAI(config EngineConfig, name, baseURL, model string, keyRequired bool) (*OpenAI, error) {
+
+	engine := &OpenAI{
+		Client: apiclient.Client{
+			BaseURL:    strings.TrimRight(config.BaseURL, "/"),
+			APIKey:     config.APIKey,
+			Timeout:    config.Timeout,
+			Retry:      apiclient.RetryPolicy{MaxRetries: config.MaxRetries},
+			Limiter:    apiclient.NewLimiter(config.RequestsPerMinute),
+			HTTPClient: &http.Client{},
+		},
+		Name:     name,
+		Model:    config.Model,
+		Language: config.Language,
+		Prompt:   config.Prompt,
+	}
+
+	if engine.BaseURL == "" {
+		engine.BaseURL = baseURL
+	}
+
+	if keyRequired && engine.APIKey == "" {
+		return nil, fmt.Errorf("missing api key")
+	}
+
+	if engine.Model == "" {
+		engine.Model = model
+	}
+
+	if engine.Language == "" {
+		engine.Language = openAILanguage
 	}
-	return true
+
+	return engine, nil
 }
 
-const (
-	whisperMaxBytes        int64 = 25 * 1024 * 1024 // 25mb
-	whisperMaxBytesPadding int64 = 100 * 1024       // 100kb
-)
+func (o *OpenAI) Transcribe(ctx context.Context, media avmedia.Media) (Transcript, error) {
+	return o.transcribeFile(ctx, media.GetPath())
+}
 
-func MaxUploadSize() int64 {
-	return (whisperMaxBytes - whisperMaxBytesPadding)
+func (o *OpenAI) Info() EngineInfo {
+	return EngineInfo{
+		Engine:   o.Name,
+		Model:    o.Model,
+		Language: o.Language,
+		Prompt:   o.Prompt,
+	}
 }
 
-func Transcribe(ctx context.Context, inputPath string) (Transcript, error) {
+func (o *OpenAI) transcribeFile(ctx context.Context, inputPath string) (Transcript, error) {
 
 	var ts Transcript
 
@@ -77,120 +130,96 @@ func Transcribe(ctx context.Context, inputPath string) (Transcript, error) {
 		return ts, fmt.Errorf("failed to write file %v to part: %v", inputPath, err)
 	}
 
-	if err := writer.WriteField("model", "whisper-1"); err != nil {
+	if err := writer.WriteField("model", o.Model); err != nil {
 		return ts, fmt.Errorf("failed to write field: model: %v", err)
 	}
 
-	if err := writer.WriteField("language", "en"); err != nil {
-		return ts, fmt.Errorf("failed to write field: model: %v", err)
-	}
-
-	if err := writer.WriteField("response_format", "json"); err != nil {
-		return ts, fmt.Errorf("failed to write field: response_format: %v", err)
+	// Without a language the api detects it from the audio.
+	if o.Language != LanguageAuto {
+		if err := writer.WriteField("language", o.Language); err != nil {
+			return ts, fmt.Errorf("failed to write field: language: %v", err)
+		}
 	}
 
-	/*
-		TODO: I want verbose_json with words and/or segments but currently the API is not
-		behaving as expected.
-
-		if err := writer.WriteField("response_format", "verbose_json"); err != nil {
-			return ts, fmt.Errorf("failed to write field: response_format: %v", err)
+	if o.Prompt != "" {
+		if err := writer.WriteField("prompt", o.Prompt); err != nil {
+			return ts, fmt.Errorf("failed to write field: prompt: %v", err)
 		}
+	}
 
-		if err := writer.WriteField("timestamp_granularities", "word"); err != nil {
-			return ts, fmt.Errorf("failed to write field: timestamp_granularities=word: %v", err)
-		}
+	// The verbose format is required for the timestamps. Multiple granularities are
+	// sent as repeated array fields, if only segment is requested then the words
+	// are omitted from the response.
+	if err := writer.WriteField("response_format", "verbose_json"); err != nil {
+		return ts, fmt.Errorf("failed to write field: response_format: %v", err)
+	}
 
-		if err := writer.WriteField("timestamp_granularities", "segment"); err != nil {
-			return ts, fmt.Errorf("failed to write field: timestamp_granularities=segment: %v", err)
+	for _, granularity := range []string{"word", "segment"} {
+		if err := writer.WriteField("timestamp_granularities[]", granularity); err != nil {
+			return ts, fmt.Errorf("failed to write field: timestamp_granularities[]=%v: %v", granularity, err)
 		}
-	*/
+	}
 
 	err = writer.Close()
 	if err != nil {
 		return ts, fmt.Errorf("failed to close writer: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/audio/transcriptions", body)
-	if err != nil {
-		return ts, fmt.Errorf("failed create new http request: %v", err)
-	}
-
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", apiKey))
-
-	client := &http.Client{}
-
-	// TODO: Timeout the request?
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return ts, fmt.Errorf("failed to execute http request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errMsg string
-		if body, err := io.ReadAll(resp.Body); err == nil {
-			errMsg = string(body)
-		}
-		return ts, fmt.Errorf("request failed with status: %v: %v", resp.StatusCode, errMsg)
-	}
-
-	respBody, err := io.ReadAll(resp.Body)
+	// The body is kept in memory for the retries, the upload size cap keeps this
+	// reasonable.
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("file", filepath.Base(inputPath)))
+	data, err := o.Post(ctx, openAITranscribeEP, writer.FormDataContentType(), body.Bytes())
 	if err != nil {
-		return ts, fmt.Errorf("failed to read response body: %v", err)
+		return ts, err
 	}
 
-	if err := json.Unmarshal(respBody, &ts); err != nil {
+	if err := json.Unmarshal(data, &ts); err != nil {
 		return ts, fmt.Errorf("failed to marshal json resp: %v", err)
 	}
 
-	//fmt.Printf("TRANSCRIPT:\n%+v", ts)
-
-	return ts, nil
+	return o.recordLanguage(ts), nil
 }
 
-type Transcript struct {
-	Task     string    `json:"task"`
-	Language string    `json:"language"`
-	Duration float64   `json:"duration"`
-	Text     string    `json:"text"`
-	Words    []Word    `json:"words"`
-	Segments []Segment `json:"segments"`
+// recordLanguage normalizes the language which the api reports, eg: english, to
+// its code. Servers which do not report it get the requested language.
+func (o *OpenAI) recordLanguage(ts Transcript) Transcript {
+	ts.Language = NormalizeLanguage(ts.Language)
+	if ts.Language == "" && o.Language != LanguageAuto {
+		ts.Language = o.Language
+	}
+	return ts
 }
 
-type Word struct {
-	Word  string  `json:"word"`
-	Start float64 `json:"start"`
-	End   float64 `json:"end"`
-}
+// Check verifies that the api is reachable and accepts the api key by listing
+// the models, without uploading any audio. Local servers which do not implement
+// the models endpoint, eg: whisper.cpp, pass as long as they respond.
+func (o *OpenAI) Check(ctx context.Context) error {
 
-type Segment struct {
-	ID               int     `json:"id"`
-	Seek             int     `json:"seek"`
-	Start            float64 `json:"start"`
-	End              float64 `json:"end"`
-	Text             string  `json:"text"`
-	Tokens           []int   `json:"tokens"`
-	Temperature      float64 `json:"temperature"`
-	AvgLogprob       float64 `json:"avg_logprob"`
-	CompressionRatio float64 `json:"compression_ratio"`
-	NoSpeechProb     float64 `json:"no_speech_prob"`
-}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
 
-func (ts Transcript) Format(output string) ([]byte, error) {
-	switch output {
-	case outputTXT:
+	req, err := http.NewRequestWithContext(ctx, "GET", o.BaseURL+openAIModelsEP, nil)
+	if err != nil {
+		return fmt.Errorf("failed create new http request: %v", err)
+	}
+	o.Authorize(req)
 
-		sentences, err := utils.SplitSentences(ts.Text)
-		if err != nil {
-			return nil, fmt.Errorf("failed to split sentences: %w", err)
-		}
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v is not reachable: %w", o.BaseURL, err)
+	}
+	defer resp.Body.Close()
 
-		return []byte(utils.CombineSentences(sentences, "\n\n")), nil
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if o.Name != EngineOpenAI && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed) {
+		return nil
+	}
 
-	default:
-		return nil, fmt.Errorf("output format not supported: %v", output)
+	var errMsg string
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		errMsg = string(body)
 	}
+	return apiclient.ClassifyStatus(resp, errMsg, time.Now())
 }
@@ -0,0 +1,175 @@
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spiritorai/spiritor/apiclient"
+	"github.com/spiritorai/spiritor/apiclient/apitest"
+)
+
+func writeTestAudio(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audio.ogg")
+	if err := os.WriteFile(path, []byte("fake audio"), 0666); err != nil {
+		t.Fatalf("failed to write test audio: %v", err)
+	}
+	return path
+}
+
+func TestOpenAITranscribe(t *testing.T) {
+
+	server := apitest.NewServer(t, openAITranscribeEP, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatalf("failed to parse multipart form: %v", err)
+		}
+
+		if got := r.FormValue("model"); got != "whisper-test" {
+			t.Errorf("model = %q, want %q", got, "whisper-test")
+		}
+		if got := r.FormValue("language"); got != "en" {
+			t.Errorf("language = %q, want %q", got, "en")
+		}
+		if got := r.FormValue("prompt"); got != "Spiritor, Whisper" {
+			t.Errorf("prompt = %q, want %q", got, "Spiritor, Whisper")
+		}
+		if got := r.FormValue("response_format"); got != "verbose_json" {
+			t.Errorf("response_format = %q, want %q", got, "verbose_json")
+		}
+		if got := r.MultipartForm.Value["timestamp_granularities[]"]; len(got) != 2 || got[0] != "word" || got[1] != "segment" {
+			t.Errorf("timestamp_granularities[] = %q, want [word segment]", got)
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Fatalf("missing form file: %v", err)
+		}
+		defer file.Close()
+
+		if header.Filename != "audio.ogg" {
+			t.Errorf("filename = %q, want %q", header.Filename, "audio.ogg")
+		}
+		if data, _ := io.ReadAll(file); string(data) != "fake audio" {
+			t.Errorf("file contents = %q, want %q", data, "fake audio")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{
+			"task": "transcribe",
+			"language": "english",
+			"duration": 1.5,
+			"text": "Hello world.",
+			"words": [{"word": "Hello", "start": 0, "end": 0.5}, {"word": "world", "start": 0.6, "end": 1.2}],
+			"segments": [{"id": 0, "start": 0, "end": 1.5, "text": " Hello world."}]
+		}`)
+	})
+
+	engine, err := NewOpenAI(EngineConfig{
+		BaseURL: server.URL + "/v1/",
+		APIKey:  apitest.APIKey,
+		Model:   "whisper-test",
+		Prompt:  "Spiritor, Whisper",
+	})
+	if err != nil {
+		t.Fatalf("NewOpenAI() error = %v", err)
+	}
+
+	ts, err := engine.transcribeFile(context.Background(), writeTestAudio(t))
+	if err != nil {
+		t.Fatalf("transcribeFile() error = %v", err)
+	}
+
+	if ts.Text != "Hello world." {
+		t.Errorf("text = %q, want %q", ts.Text, "Hello world.")
+	}
+	if ts.Language != "en" {
+		t.Errorf("language = %q, want %q", ts.Language, "en")
+	}
+	if len(ts.Words) != 2 || ts.Words[1].Word != "world" || ts.Words[1].Start != 0.6 {
+		t.Errorf("words = %+v, want 2 timed words", ts.Words)
+	}
+	if len(ts.Segments) != 1 || ts.Segments[0].End != 1.5 {
+		t.Errorf("segments = %+v, want 1 timed segment", ts.Segments)
+	}
+}
+
+func TestOpenAITranscribeAutoLanguage(t *testing.T) {
+
+	server := apitest.NewServer(t, openAITranscribeEP, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatalf("failed to parse multipart form: %v", err)
+		}
+		if _, ok := r.MultipartForm.Value["language"]; ok {
+			t.Errorf("language field sent, want it omitted for auto detection")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"language": "german", "text": "Hallo Welt."}`)
+	})
+
+	engine, err := NewOpenAI(EngineConfig{BaseURL: server.URL + "/v1", APIKey: apitest.APIKey, Language: LanguageAuto})
+	if err != nil {
+		t.Fatalf("NewOpenAI() error = %v", err)
+	}
+
+	ts, err := engine.transcribeFile(context.Background(), writeTestAudio(t))
+	if err != nil {
+		t.Fatalf("transcribeFile() error = %v", err)
+	}
+	if ts.Language != "de" {
+		t.Errorf("language = %q, want the detected %q", ts.Language, "de")
+	}
+}
+
+func TestOpenAITranscribeErrorStatus(t *testing.T) {
+
+	server := apitest.NewServer(t, openAITranscribeEP, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error": {"message": "bad file"}}`)
+	})
+
+	engine, err := NewOpenAI(EngineConfig{BaseURL: server.URL + "/v1", APIKey: apitest.APIKey})
+	if err != nil {
+		t.Fatalf("NewOpenAI() error = %v", err)
+	}
+
+	_, err = engine.transcribeFile(context.Background(), writeTestAudio(t))
+	if err == nil {
+		t.Fatal("transcribeFile() error = nil, want error")
+	}
+	if !errors.As(err, &apiclient.ErrClient{}) {
+		t.Errorf("error type = %T, want ErrClient", err)
+	}
+	if !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "bad file") {
+		t.Errorf("error = %v, want status and message", err)
+	}
+}
+
+func TestNewOpenAIMissingKey(t *testing.T) {
+	if _, err := NewOpenAI(EngineConfig{}); err == nil {
+		t.Fatal("NewOpenAI() error = nil, want missing key error")
+	}
+}
+
+func TestNewLocalDefaults(t *testing.T) {
+	engine, err := NewLocal(EngineConfig{})
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v, want no key required", err)
+	}
+	if engine.Name != EngineLocal || engine.BaseURL != localBaseURL || engine.Model != localModel {
+		t.Errorf("engine = %v at %v with %v, want the local defaults", engine.Name, engine.BaseURL, engine.Model)
+	}
+}
+
+func TestNewTranscriberUnknownEngine(t *testing.T) {
+	if _, err := NewTranscriber("nope", EngineConfig{}); err == nil {
+		t.Fatal("NewTranscriber() error = nil, want unsupported engine error")
+	}
+}
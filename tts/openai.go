@@ -0,0 +1,108 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spiritorai/spiritor/apiclient"
+)
+
+const (
+	openAIBaseURL  = "https://api.openai.com/v1"
+	openAIModel    = "tts-1"
+	openAIVoice    = "alloy"
+	openAIFormat   = "flac"
+	openAISpeechEP = "/audio/speech"
+
+	// OpenAIMaxInput is the max characters of text per request of the openai api.
+	OpenAIMaxInput = 4096
+)
+
+// OpenAI is the provider for the OpenAI speech api, and for any server which
+// implements the same api, eg: kokoro-fastapi or openedai-speech. The zero value
+// is not usable, use NewOpenAI to initialize it with defaults.
+type OpenAI struct {
+	apiclient.Client
+
+	Model  string  // model name, eg: tts-1
+	Voice  string  // voice name, eg: alloy
+	Speed  float64 // speaking speed, 0 for the api default
+	Format string  // response format, eg: flac
+}
+
+// NewOpenAI will initialize a new OpenAI provider from the config and fill in
+// the defaults for any empty values. The api key is only required by the openai
+// api itself, self-hosted servers usually run without one. The speech is
+// requested as flac, which is lossless so that it is only lossy encoded once
+// when the chunks are joined.
+func NewOpenAI(config ProviderConfig) (*OpenAI, error) {
+
+	provider := &OpenAI{
+		Client: apiclient.Client{
+			BaseURL:    strings.TrimRight(config.BaseURL, "/"),
+			APIKey:     config.APIKey,
+			Timeout:    config.Timeout,
+			Retry:      apiclient.RetryPolicy{MaxRetries: config.MaxRetries},
+			Limiter:    apiclient.NewLimiter(config.RequestsPerMinute),
+			HTTPClient: &http.Client{},
+		},
+		Model:  config.Model,
+		Voice:  config.Voice,
+		Speed:  config.Speed,
+		Format: openAIFormat,
+	}
+
+	if provider.BaseURL == "" {
+		provider.BaseURL = openAIBaseURL
+	}
+
+	if provider.APIKey == "" && provider.BaseURL == openAIBaseURL {
+		return nil, fmt.Errorf("missing api key")
+	}
+
+	if provider.Model == "" {
+		provider.Model = openAIModel
+	}
+
+	if provider.Voice == "" {
+		provider.Voice = openAIVoice
+	}
+
+	if provider.Speed != 0 && (provider.Speed < 0.25 || provider.Speed > 4) {
+		return nil, fmt.Errorf("invalid speed %v: must be between 0.25 and 4", provider.Speed)
+	}
+
+	return provider, nil
+}
+
+type speechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+// Synthesize sends the text to the speech endpoint, retrying rate limit, server
+// and network errors with the same policy as the transcription engines.
+func (o *OpenAI) Synthesize(ctx context.Context, text string) (Audio, error) {
+
+	data, err := o.PostJSON(ctx, openAISpeechEP, speechRequest{
+		Model:          o.Model,
+		Input:          text,
+		Voice:          o.Voice,
+		ResponseFormat: o.Format,
+		Speed:          o.Speed,
+	})
+	if err != nil {
+		return Audio{}, err
+	}
+
+	if len(data) == 0 {
+		return Audio{}, fmt.Errorf("speech response is empty")
+	}
+
+	return Audio{Data: data, Format: o.Format}, nil
+}
@@ -0,0 +1,178 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spiritorai/spiritor/avmedia"
+	"github.com/spiritorai/spiritor/logging"
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// Options controls how a text is split up for the provider. Zero values are
+// replaced by the defaults.
+type Options struct {
+	MaxChars int    // max characters of text per request
+	Workers  int    // max concurrent requests
+	Language string // ISO-639-1 code used to split sentences, empty for english
+}
+
+const defaultWorkers = 4
+
+func (opts Options) withDefaults() Options {
+	if opts.MaxChars <= 0 {
+		opts.MaxChars = OpenAIMaxInput
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	return opts
+}
+
+type SpeakConfig struct {
+	OutputPath string        // path of the output file, one of mp3, ogg or opus
+	Chapters   bool          // add a chapter marker for every titled section
+	Timeout    time.Duration // max time to join the chunks, 0 for none
+}
+
+// Speaker reads texts out loud with a provider.
+type Speaker struct {
+	Provider Provider
+	Options  Options
+}
+
+// chunk is a request of the synthesis, along with the section it belongs to.
+type chunk struct {
+	section int
+	text    string
+	media   avmedia.Media
+}
+
+// Speak synthesizes the sections into a single audio file and returns a media
+// wrapper for it. Each section is split into chunks which fit in a request, the
+// chunks are synthesized concurrently and then joined in order. The title of a
+// section is read out before its text.
+func (s Speaker) Speak(ctx context.Context, sections []Section, config SpeakConfig) (avmedia.Media, error) {
+
+	var result avmedia.Media
+	opts := s.Options.withDefaults()
+
+	var chunks []chunk
+	for i, section := range sections {
+		if section.Title != "" {
+			chunks = append(chunks, chunk{section: i, text: withPeriod(section.Title)})
+		}
+		texts, err := SplitText(section.Text, opts.Language, opts.MaxChars)
+		if err != nil {
+			return result, err
+		}
+		for _, text := range texts {
+			chunks = append(chunks, chunk{section: i, text: text})
+		}
+	}
+
+	if len(chunks) == 0 {
+		return result, fmt.Errorf("text has nothing to speak")
+	}
+
+	workdir, err := os.MkdirTemp("", "spiritor-speak-*")
+	if err != nil {
+		return result, fmt.Errorf("failed to create work dir: %v", err)
+	}
+	defer os.RemoveAll(workdir)
+
+	logging.FromContext(ctx).Info("synthesizing speech", "sections", len(sections), "chunks", len(chunks))
+
+	if err := s.synthesize(ctx, opts.Workers, workdir, chunks); err != nil {
+		return result, err
+	}
+
+	sources := make([]avmedia.Media, len(chunks))
+	for i, c := range chunks {
+		sources[i] = c.media
+	}
+
+	var chapters []avmedia.Chapter
+	if config.Chapters {
+		chapters = sectionChapters(sections, chunks)
+	}
+
+	return avmedia.Concat(ctx, sources, avmedia.ConcatConfig{
+		OutputPath: config.OutputPath,
+		Chapters:   chapters,
+		Timeout:    config.Timeout,
+	})
+}
+
+// synthesize writes the speech of every chunk into the work dir with up to
+// workers requests at once. The first error cancels the remaining requests.
+func (s Speaker) synthesize(ctx context.Context, workers int, workdir string, chunks []chunk) error {
+
+	failed, err := utils.Parallel(ctx, workers, len(chunks), func(ctx context.Context, i int) error {
+		var err error
+		chunks[i].media, err = s.synthesizeChunk(ctx, workdir, i, chunks[i].text)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to synthesize chunk %d: %w", failed+1, err)
+	}
+
+	return nil
+}
+
+func (s Speaker) synthesizeChunk(ctx context.Context, workdir string, i int, text string) (avmedia.Media, error) {
+
+	audio, err := s.Provider.Synthesize(ctx, text)
+	if err != nil {
+		return avmedia.Media{}, err
+	}
+
+	path := filepath.Join(workdir, fmt.Sprintf("chunk-%05d.%v", i+1, audio.Format))
+	if err := os.WriteFile(path, audio.Data, 0666); err != nil {
+		return avmedia.Media{}, fmt.Errorf("failed to write chunk: %v", err)
+	}
+
+	// The chunk is probed for its duration, which places the chapter markers.
+	media, err := avmedia.NewMedia(ctx, path)
+	if err != nil {
+		return media, fmt.Errorf("provider returned unreadable audio: %w", err)
+	}
+
+	return media, nil
+}
+
+// sectionChapters returns a chapter for every titled section, which spans its
+// chunks.
+func sectionChapters(sections []Section, chunks []chunk) []avmedia.Chapter {
+
+	var chapters []avmedia.Chapter
+	var offset time.Duration
+	for i := 0; i < len(chunks); {
+		section := chunks[i].section
+		start := offset
+		for ; i < len(chunks) && chunks[i].section == section; i++ {
+			offset += chunks[i].media.GetDuration()
+		}
+		if title := sections[section].Title; title != "" && offset > start {
+			chapters = append(chapters, avmedia.Chapter{Start: start, End: offset, Title: title})
+		}
+	}
+
+	return chapters
+}
+
+// withPeriod ends a title with a period, so that it is read with a pause before
+// the text which follows it.
+func withPeriod(title string) string {
+	r, _ := utf8.DecodeLastRuneInString(strings.TrimSpace(title))
+	if unicode.IsPunct(r) {
+		return title
+	}
+	return title + "."
+}
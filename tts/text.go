@@ -0,0 +1,133 @@
+package tts
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// Section is a titled part of a text, which becomes a chapter of the speech.
+type Section struct {
+	Title string // heading of the section, empty for the text before the first heading
+	Text  string // spoken text of the section, without the markdown formatting
+}
+
+var (
+	headingLine = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	cueLine     = regexp.MustCompile(`^\[[^\]]*\]$`)             // eg: [b-roll of the garden], in video scripts
+	ruleLine    = regexp.MustCompile(`^([-*_]\s*){3,}$`)         // eg: ---
+	listMarker  = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+`) // eg: - item, 1. item
+	image       = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	link        = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	emphasis    = regexp.MustCompile("(\\*{1,3}|_{2,3}|`+)")
+)
+
+// ParseSections splits a text at its markdown headings, and removes the markdown
+// formatting which would otherwise be read out, along with the lines which are
+// not meant to be spoken, eg: the visual cues of a video script. Plain text
+// without any headings is a single untitled section.
+func ParseSections(text string) []Section {
+
+	var sections []Section
+	current := Section{}
+	var lines []string
+
+	flush := func() {
+		current.Text = strings.TrimSpace(strings.Join(lines, "\n"))
+		if current.Title != "" || current.Text != "" {
+			sections = append(sections, current)
+		}
+		lines = nil
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if match := headingLine.FindStringSubmatch(trimmed); match != nil {
+			flush()
+			current = Section{Title: speakable(match[1])}
+			continue
+		}
+
+		if cueLine.MatchString(trimmed) || ruleLine.MatchString(trimmed) {
+			continue
+		}
+
+		// List items are usually written without a period, which would run them
+		// together when read out.
+		if item := listMarker.ReplaceAllString(trimmed, ""); item != trimmed {
+			if item = speakable(item); item != "" {
+				lines = append(lines, withPeriod(item))
+			}
+			continue
+		}
+
+		trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))
+		lines = append(lines, speakable(trimmed))
+	}
+	flush()
+
+	return sections
+}
+
+// speakable removes the inline markdown formatting of a line.
+func speakable(line string) string {
+	line = image.ReplaceAllString(line, "")
+	line = link.ReplaceAllString(line, "$1")
+	line = emphasis.ReplaceAllString(line, "")
+	return strings.TrimSpace(line)
+}
+
+// SplitText splits the text into chunks of up to maxChars on sentence boundaries,
+// using the sentence data of the ISO-639-1 language if there is any. Paragraphs
+// are kept together where they fit, and a sentence which is still too long is
+// cut at the last space before the limit.
+func SplitText(text string, language string, maxChars int) ([]string, error) {
+
+	if maxChars <= 0 {
+		return nil, fmt.Errorf("max chars must be greater than 0")
+	}
+
+	var chunks []string
+	var b strings.Builder
+	add := func(part, sep string) {
+		if b.Len() > 0 && b.Len()+len(sep)+len(part) > maxChars {
+			chunks = append(chunks, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(part)
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.Join(strings.Fields(paragraph), " ")
+		if paragraph == "" {
+			continue
+		}
+		if len(paragraph) <= maxChars {
+			add(paragraph, "\n\n")
+			continue
+		}
+
+		sentences, err := utils.SplitSentencesMax(paragraph, language, maxChars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split sentences: %w", err)
+		}
+
+		sep := "\n\n"
+		for _, sentence := range sentences {
+			add(sentence, sep)
+			sep = " "
+		}
+	}
+
+	if b.Len() > 0 {
+		chunks = append(chunks, b.String())
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,79 @@
+package tts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spiritorai/spiritor/utils"
+)
+
+// Provider is implemented by every speech synthesis provider. Implementations
+// must be safe for concurrent use since a single instance is shared by all of the
+// synthesis workers.
+type Provider interface {
+	// Synthesize returns the speech of the text. Every call of a provider must
+	// return audio in the same format and parameters, so that the chunks of a
+	// text can be joined without re-encoding them first.
+	Synthesize(ctx context.Context, text string) (Audio, error)
+}
+
+// Audio is the speech of a single chunk of text.
+type Audio struct {
+	Data   []byte
+	Format string // file extension of the data, eg: flac
+}
+
+// ProviderConfig holds the common settings which are passed to a provider
+// factory. Each provider is responsible for applying its own defaults to empty
+// values.
+type ProviderConfig struct {
+	BaseURL string        // api base url, eg: https://api.openai.com/v1
+	APIKey  string        // api key sent as a bearer token, if required
+	Model   string        // model name, eg: tts-1
+	Voice   string        // voice name, eg: alloy
+	Speed   float64       // speaking speed, 1 for normal or 0 for the provider default
+	Timeout time.Duration // max time for a single request, 0 for none
+
+	MaxRetries        int // max retries of a failed request, 0 for none
+	RequestsPerMinute int // max requests started per minute, 0 for no limit
+}
+
+// ProviderFactory builds a new provider from the provider config.
+type ProviderFactory func(config ProviderConfig) (Provider, error)
+
+const (
+	ProviderOpenAI = "openai"
+)
+
+var providers = utils.NewRegistry(map[string]ProviderFactory{
+	ProviderOpenAI: func(config ProviderConfig) (Provider, error) {
+		return NewOpenAI(config)
+	},
+})
+
+// RegisterProvider makes a speech synthesis provider available by name, eg: one
+// backed by a local model with a different api. Registering an existing name
+// replaces the previous factory.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providers.Register(name, factory)
+}
+
+func ProviderAllowed(provider string) bool {
+	_, ok := providers.Get(provider)
+	return ok
+}
+
+// Providers returns the sorted names of all registered speech providers.
+func Providers() []string {
+	return providers.Names()
+}
+
+// NewProvider builds the named provider from the config.
+func NewProvider(provider string, config ProviderConfig) (Provider, error) {
+	factory, ok := providers.Get(provider)
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %v", provider)
+	}
+	return factory(config)
+}
@@ -0,0 +1,119 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spiritorai/spiritor/apiclient/apitest"
+)
+
+// newSpeechStub starts a stand-in for an OpenAI compatible speech endpoint. The
+// handler is called with the decoded request.
+func newSpeechStub(t *testing.T, handler func(w http.ResponseWriter, req speechRequest)) *httptest.Server {
+	t.Helper()
+
+	return apitest.NewServer(t, openAISpeechEP, func(w http.ResponseWriter, r *http.Request) {
+		var req speechRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode speech request: %v", err)
+		}
+		handler(w, req)
+	})
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server) *OpenAI {
+	t.Helper()
+
+	provider, err := NewOpenAI(ProviderConfig{
+		BaseURL: server.URL + "/v1",
+		APIKey:  apitest.APIKey,
+		Model:   "tts-test",
+		Voice:   "nova",
+		Speed:   1.25,
+	})
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+
+	return provider
+}
+
+func TestOpenAISynthesize(t *testing.T) {
+
+	server := newSpeechStub(t, func(w http.ResponseWriter, req speechRequest) {
+		want := speechRequest{Model: "tts-test", Input: "Hello there.", Voice: "nova", ResponseFormat: "flac", Speed: 1.25}
+		if req != want {
+			t.Errorf("request = %+v, want %+v", req, want)
+		}
+		w.Header().Set("Content-Type", "audio/flac")
+		w.Write([]byte("fake flac"))
+	})
+
+	audio, err := newTestProvider(t, server).Synthesize(context.Background(), "Hello there.")
+	if err != nil {
+		t.Fatalf("synthesize failed: %v", err)
+	}
+	if string(audio.Data) != "fake flac" || audio.Format != "flac" {
+		t.Errorf("audio = %q as %v, want %q as flac", audio.Data, audio.Format, "fake flac")
+	}
+}
+
+func TestOpenAISynthesizeEmpty(t *testing.T) {
+
+	server := newSpeechStub(t, func(w http.ResponseWriter, req speechRequest) {
+		w.Header().Set("Content-Type", "audio/flac")
+	})
+
+	_, err := newTestProvider(t, server).Synthesize(context.Background(), "Hello there.")
+	if err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Fatalf("err = %v, want an empty response error", err)
+	}
+}
+
+func TestParseSections(t *testing.T) {
+
+	text := "Intro with **bold** and a [link](https://example.com).\n\n" +
+		"# Chapter One #\n\n" +
+		"[b-roll of the garden]\n" +
+		"- first point\n" +
+		"- second point\n\n" +
+		"---\n\n" +
+		"## Chapter Two\n\n" +
+		"> A quote.\n"
+
+	want := []Section{
+		{Title: "", Text: "Intro with bold and a link."},
+		{Title: "Chapter One", Text: "first point.\nsecond point."},
+		{Title: "Chapter Two", Text: "A quote."},
+	}
+
+	if got := ParseSections(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("sections = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+
+	text := "One two three. Four five six.\n\nSeven eight nine ten eleven twelve thirteen."
+
+	chunks, err := SplitText(text, "en", 30)
+	if err != nil {
+		t.Fatalf("split failed: %v", err)
+	}
+
+	want := []string{"One two three. Four five six.", "Seven eight nine ten eleven", "twelve thirteen."}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("chunks = %q, want %q", chunks, want)
+	}
+
+	for _, chunk := range chunks {
+		if len(chunk) > 30 || strings.TrimSpace(chunk) != chunk {
+			t.Errorf("chunk %q is not trimmed to the max chars", chunk)
+		}
+	}
+}
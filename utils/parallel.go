@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Parallel calls fn for every index from 0 to n-1, with up to workers calls at
+// once. The first error cancels the ctx of the other calls, and it is returned
+// along with the index of the call which failed, or -1 if none did. The failed
+// call is reported rather than the cancellations which it caused in the others.
+func Parallel(ctx context.Context, workers, n int, fn func(ctx context.Context, i int) error) (int, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, n)
+	sem := make(chan struct{}, max(workers, 1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			if errs[i] = fn(ctx, i); errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return i, err
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return -1, nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallel(t *testing.T) {
+
+	var running, peak atomic.Int32
+	done := make([]bool, 10)
+	failed, err := Parallel(context.Background(), 3, len(done), func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		done[i] = true
+		return nil
+	})
+	if err != nil || failed != -1 {
+		t.Fatalf("Parallel() = %v, %v, want -1, nil", failed, err)
+	}
+	if peak.Load() > 3 {
+		t.Errorf("%v calls ran at once, want at most 3", peak.Load())
+	}
+	for i, ok := range done {
+		if !ok {
+			t.Errorf("call %v was not made", i)
+		}
+	}
+}
+
+func TestParallelReportsFailure(t *testing.T) {
+
+	errFailed := errors.New("failed")
+	failed, err := Parallel(context.Background(), 5, 5, func(ctx context.Context, i int) error {
+		if i == 1 {
+			return errFailed
+		}
+		// The others only end once they are canceled by the failure.
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if failed != 1 || !errors.Is(err, errFailed) {
+		t.Errorf("Parallel() = %v, %v, want 1, %v", failed, err, errFailed)
+	}
+}
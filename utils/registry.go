@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry holds the factories of the implementations of an interface by name,
+// eg: the providers of a package, so that other packages can add their own
+// without forking it. It is safe for concurrent use.
+type Registry[F any] struct {
+	mu        sync.RWMutex
+	factories map[string]F
+}
+
+// NewRegistry returns a registry with the built in factories.
+func NewRegistry[F any](factories map[string]F) *Registry[F] {
+	r := &Registry[F]{factories: make(map[string]F, len(factories))}
+	for name, factory := range factories {
+		r.factories[name] = factory
+	}
+	return r
+}
+
+// Register adds the factory by name, replacing any previous one of the name.
+func (r *Registry[F]) Register(name string, factory F) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// Get returns the factory of the name, if there is one.
+func (r *Registry[F]) Get(name string) (F, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	factory, ok := r.factories[name]
+	return factory, ok
+}
+
+// Names returns the sorted names of all the factories.
+func (r *Registry[F]) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -3,38 +3,189 @@ package utils
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/neurosnap/sentences"
 	"github.com/spiritorai/spiritor/neurosnaptrainingdata"
 )
 
+// punktData maps the ISO-639-1 codes to the punkt training data which is embedded
+// in neurosnaptrainingdata, these are all of the languages which neurosnap/sentences
+// has been trained on.
+var punktData = map[string]string{
+	"cs": "czech.json",
+	"da": "danish.json",
+	"de": "german.json",
+	"el": "greek.json",
+	"en": "english.json",
+	"es": "spanish.json",
+	"et": "estonian.json",
+	"fi": "finnish.json",
+	"fr": "french.json",
+	"it": "italian.json",
+	"nl": "dutch.json",
+	"no": "norwegian.json",
+	"nb": "norwegian.json",
+	"nn": "norwegian.json",
+	"pl": "polish.json",
+	"pt": "portuguese.json",
+	"sl": "slovene.json",
+	"sv": "swedish.json",
+	"tr": "turkish.json",
+}
+
+// defaultLanguage is used when the language is not known, eg: for transcripts
+// from before the language was recorded.
+const defaultLanguage = "en"
+
+// The training data is large so each tokenizer is only loaded once, on first use.
+var (
+	tokenizersMu sync.Mutex
+	tokenizers   = map[string]*sentences.DefaultSentenceTokenizer{}
+)
+
+// SentenceLanguageSupported reports whether sentences of the language, as an
+// ISO-639-1 code, are split with trained data rather than by punctuation only.
+func SentenceLanguageSupported(language string) bool {
+	_, ok := punktData[strings.ToLower(language)]
+	return ok
+}
+
 // SplitSentences will use the neurosnap/sentences tokenizer to split the content up into
-// a slice of strings. Each string is a sentence, based on existing punctuation. This util
-// method is hardcoded to english at the moment but the sentence splitter does support
-// multiple languages which can be enabled later.
-func SplitSentences(content string) ([]string, error) {
+// a slice of strings. Each string is a sentence, based on existing punctuation. The
+// training data is picked by the ISO-639-1 language code, with english for an empty
+// code. Languages without training data are split at the sentence punctuation
+// instead, which also handles scripts such as chinese which do not put spaces
+// between sentences.
+func SplitSentences(content string, language string) ([]string, error) {
+
+	if language == "" {
+		language = defaultLanguage
+	}
+
+	file, ok := punktData[strings.ToLower(language)]
+	if !ok {
+		return splitPunctuation(content), nil
+	}
+
+	tokenizer, err := loadTokenizer(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var final []string
+	for _, s := range tokenizer.Tokenize(content) {
+		if text := strings.TrimSpace(s.Text); text != "" {
+			final = append(final, text)
+		}
+	}
+
+	return final, nil
+}
+
+// SplitSentencesMax splits the content into sentences like SplitSentences, and
+// cuts any sentence which is longer than maxChars, eg: of a transcript without
+// any punctuation, at the last space before the limit. Sentences without a space
+// to cut at are cut at the last rune boundary instead.
+func SplitSentencesMax(content string, language string, maxChars int) ([]string, error) {
+
+	if maxChars <= 0 {
+		return nil, fmt.Errorf("max chars must be greater than 0")
+	}
+
+	sentences, err := SplitSentences(content, language)
+	if err != nil {
+		return nil, err
+	}
 
 	var final []string
+	for _, sentence := range sentences {
+		for len(sentence) > maxChars {
+			// A space right after the limit still leaves a part which fits.
+			cut := strings.LastIndex(sentence[:maxChars+1], " ")
+			if cut <= 0 {
+				cut = maxChars
+				for cut > 0 && !utf8.RuneStart(sentence[cut]) {
+					cut--
+				}
+			}
+			final = append(final, strings.TrimSpace(sentence[:cut]))
+			sentence = strings.TrimSpace(sentence[cut:])
+		}
+		final = append(final, sentence)
+	}
+
+	return final, nil
+}
+
+func loadTokenizer(file string) (*sentences.DefaultSentenceTokenizer, error) {
+
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
 
-	tdata, err := neurosnaptrainingdata.FS.ReadFile("english.json")
+	if tokenizer, ok := tokenizers[file]; ok {
+		return tokenizer, nil
+	}
+
+	tdata, err := neurosnaptrainingdata.FS.ReadFile(file)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read training data: %w", err)
 	}
 
-	training, _ := sentences.LoadTraining(tdata)
+	training, err := sentences.LoadTraining(tdata)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load training data: %w", err)
 	}
 
 	// create the default sentence tokenizer
 	tokenizer := sentences.NewSentenceTokenizer(training)
-	sentences := tokenizer.Tokenize(content)
+	tokenizers[file] = tokenizer
 
-	for _, s := range sentences {
-		final = append(final, strings.TrimSpace(s.Text))
+	return tokenizer, nil
+}
+
+// sentenceEnds are the punctuation marks which end a sentence in the common
+// scripts, the full width forms are used by chinese and japanese.
+const sentenceEnds = ".!?。！？…؟।"
+
+// splitPunctuation splits after each run of sentence punctuation along with any
+// closing quotes or brackets. Latin style marks only end a sentence when followed
+// by a space, so that eg: 3.5 is kept whole, while the full width marks always do.
+func splitPunctuation(content string) []string {
+
+	runes := []rune(content)
+
+	var final []string
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !strings.ContainsRune(sentenceEnds, runes[i]) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && (strings.ContainsRune(sentenceEnds, runes[end]) || strings.ContainsRune(`"')]」』”’`, runes[end])) {
+			end++
+		}
+
+		fullWidth := runes[i] >= 0x3000
+		if !fullWidth && end < len(runes) && !unicode.IsSpace(runes[end]) {
+			continue
+		}
+
+		if text := strings.TrimSpace(string(runes[start:end])); text != "" {
+			final = append(final, text)
+		}
+		start = end
+		i = end - 1
 	}
 
-	return final, nil
+	if text := strings.TrimSpace(string(runes[start:])); text != "" {
+		final = append(final, text)
+	}
+
+	return final
 }
 
 // CombineSentences will take the output from the SplitSentences func and
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSentencesMax(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		content  string
+		maxChars int
+		want     []string
+	}{
+		{
+			name:     "short sentences are kept whole",
+			content:  "This is one. This is two.",
+			maxChars: 20,
+			want:     []string{"This is one.", "This is two."},
+		},
+		{
+			name:     "long sentence is cut at the last space",
+			content:  "one two three four five six",
+			maxChars: 10,
+			want:     []string{"one two", "three four", "five six"},
+		},
+		{
+			name:     "words without spaces are cut on a rune boundary",
+			content:  "ééééé",
+			maxChars: 5,
+			want:     []string{"éé", "éé", "é"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitSentencesMax(tt.content, "en", tt.maxChars)
+			if err != nil {
+				t.Fatalf("SplitSentencesMax() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitSentencesMax() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := SplitSentencesMax("text", "en", 0); err == nil {
+		t.Errorf("SplitSentencesMax() with 0 max chars did not fail")
+	}
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package watcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// notifyMask selects the inotify events which may mean a new or changed file.
+// Removals are included so that a file which comes back is handed off again.
+const notifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+// notifier reads inotify events of the watched dirs.
+type notifier struct {
+	fd     int
+	file   *os.File
+	events chan event
+	done   chan struct{} // closed with the notifier, stops the sends of read
+
+	mu   sync.Mutex
+	dirs map[int]string // watched dirs by watch descriptor
+}
+
+func newNotifier() (*notifier, error) {
+
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init failed: %w", err)
+	}
+
+	// A non-blocking fd uses the runtime poller, so that close interrupts read.
+	n := &notifier{
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan event, 64),
+		done:   make(chan struct{}),
+		dirs:   map[int]string{},
+	}
+	go n.read()
+
+	return n, nil
+}
+
+// add watches a dir, watching it again is a no-op.
+func (n *notifier) add(dir string) error {
+
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, notifyMask)
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("inotify watch limit reached, raise fs.inotify.max_user_watches or use polling: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("inotify watch failed: %w", err)
+	}
+
+	n.mu.Lock()
+	n.dirs[wd] = dir
+	n.mu.Unlock()
+
+	return nil
+}
+
+func (n *notifier) close() {
+	close(n.done)
+	n.file.Close()
+}
+
+func (n *notifier) send(e event) bool {
+	select {
+	case n.events <- e:
+		return true
+	case <-n.done:
+		return false
+	}
+}
+
+// read decodes the events until the notifier is closed or fails, the events
+// channel is closed on failure.
+func (n *notifier) read() {
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			select {
+			case <-n.done:
+			default:
+				close(n.events)
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(raw.Len)
+			offset = nameEnd
+			if nameEnd > count {
+				break
+			}
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				if !n.send(event{rescan: true}) {
+					return
+				}
+				continue
+			}
+
+			n.mu.Lock()
+			dir, ok := n.dirs[int(raw.Wd)]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(n.dirs, int(raw.Wd))
+			}
+			n.mu.Unlock()
+
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			if !ok || name == "" {
+				continue
+			}
+			if !n.send(event{path: filepath.Join(dir, name)}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+// notifier is only implemented with inotify, other platforms poll.
+type notifier struct {
+	events chan event
+}
+
+func newNotifier() (*notifier, error) {
+	return nil, errors.New("filesystem notifications are not supported on this platform")
+}
+
+func (n *notifier) add(dir string) error {
+	return nil
+}
+
+func (n *notifier) close() {}
@@ -0,0 +1,317 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spiritorai/spiritor/logging"
+)
+
+const (
+	defaultPollInterval = 10 * time.Second
+	defaultSettle       = 5 * time.Second
+)
+
+// Options of a Watcher, zero values use the defaults.
+type Options struct {
+	Recursive    bool          // also watch the sub dirs, including the ones created later
+	Poll         bool          // always poll, eg: for network shares which do not send notifications
+	PollInterval time.Duration // time between scans when polling
+	Settle       time.Duration // time a file must stay unchanged before it is ready
+}
+
+// Watcher finds the files which are added to or changed in a dir, and hands each
+// of them off once it has finished writing. A file is considered finished once
+// its size and modification time have not changed for the settle time, since
+// the writers of shared folders, eg: sync clients and network shares, may close
+// and reopen a file several times while copying it.
+//
+// Filesystem notifications are used where they are supported, otherwise and if
+// they fail to start the dir is polled instead.
+type Watcher struct {
+	dir     string
+	options Options
+
+	known   map[string]stamp    // files which have been handed off, by path
+	pending map[string]*settler // files which are still being written, by path
+}
+
+// stamp identifies a version of a file.
+type stamp struct {
+	size    int64
+	modTime time.Time
+}
+
+type settler struct {
+	stamp stamp
+	since time.Time // when the file was last seen to change
+}
+
+// event is a change in a watched dir. A rescan event means that changes may have
+// been missed, eg: the notification queue overflowed.
+type event struct {
+	path   string
+	rescan bool
+}
+
+// New returns a watcher of the dir, which must exist.
+func New(dir string, options Options) (*Watcher, error) {
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a dir: %v", dir)
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	if options.Settle <= 0 {
+		options.Settle = defaultSettle
+	}
+
+	return &Watcher{
+		dir:     dir,
+		options: options,
+		known:   map[string]stamp{},
+		pending: map[string]*settler{},
+	}, nil
+}
+
+// Run watches the dir until the ctx is canceled. The files which already exist
+// are handed off as well, so the caller decides which of them still need work.
+// Ready is called from the goroutine of Run, one file at a time, so a slow ready
+// holds back the files after it rather than dropping them.
+func (w *Watcher) Run(ctx context.Context, ready func(path string)) error {
+
+	log := logging.FromContext(ctx)
+
+	var events <-chan event
+	var watch func(dir string) error
+	if !w.options.Poll {
+		n, err := newNotifier()
+		if err == nil {
+			defer n.close()
+			events = n.events
+			watch = n.add
+			log.Debug("watching with filesystem notifications", "dir", w.dir)
+		} else {
+			log.Warn("filesystem notifications unavailable, polling instead", "dir", w.dir, "err", err)
+		}
+	}
+
+	// Without notifications every scan also detects the changes, otherwise the
+	// tick only checks on the files which are still being written.
+	tick := w.options.PollInterval
+	if watch != nil || w.options.Settle/2 < tick {
+		tick = max(w.options.Settle/2, 100*time.Millisecond)
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	lastScan := time.Now()
+
+	if err := w.scan(ctx, watch); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case e, ok := <-events:
+			if !ok {
+				log.Warn("filesystem notifications failed, polling instead", "dir", w.dir)
+				events, watch = nil, nil
+				continue
+			}
+			if e.rescan {
+				log.Warn("missed filesystem notifications, rescanning", "dir", w.dir)
+				if err := w.scan(ctx, watch); err != nil {
+					log.Error("scan failed", "dir", w.dir, "err", err)
+				}
+				continue
+			}
+			w.observe(ctx, e.path, watch)
+
+		case now := <-ticker.C:
+			if watch == nil && now.Sub(lastScan) >= w.options.PollInterval {
+				if err := w.scan(ctx, watch); err != nil {
+					log.Error("scan failed", "dir", w.dir, "err", err)
+				}
+				lastScan = now
+			}
+			w.settle(ctx, now, ready)
+		}
+	}
+}
+
+// scan observes every file of the watched dir, and starts watching the dirs if
+// notifications are used. Files which have been handed off and no longer exist
+// are forgotten, so that they are handed off again if they come back.
+func (w *Watcher) scan(ctx context.Context, watch func(dir string) error) error {
+
+	seen := map[string]bool{}
+	err := filepath.WalkDir(w.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// The root is checked by the caller, anything below it may be removed
+			// while walking.
+			if path == w.dir {
+				return err
+			}
+			return nil
+		}
+
+		if entry.IsDir() {
+			if path == w.dir {
+				return w.watchDir(path, watch)
+			}
+			if !w.options.Recursive || ignored(entry.Name()) {
+				return filepath.SkipDir
+			}
+			if err := w.watchDir(path, watch); err != nil {
+				logging.FromContext(ctx).Warn("failed to watch dir", "dir", path, "err", err)
+			}
+			return nil
+		}
+
+		seen[path] = true
+		w.observe(ctx, path, nil)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan %v: %w", w.dir, err)
+	}
+
+	for path := range w.known {
+		if !seen[path] {
+			delete(w.known, path)
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) watchDir(dir string, watch func(dir string) error) error {
+	if watch == nil {
+		return nil
+	}
+	return watch(dir)
+}
+
+// observe records a change of the path. New dirs are scanned when recursive,
+// since files may have been written to them before they were watched.
+func (w *Watcher) observe(ctx context.Context, path string, watch func(dir string) error) {
+
+	if ignored(filepath.Base(path)) {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		delete(w.known, path)
+		delete(w.pending, path)
+		return
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to stat file", "path", path, "err", err)
+		return
+	}
+
+	if info.IsDir() {
+		if watch != nil && w.options.Recursive {
+			if err := w.scanDir(ctx, path, watch); err != nil {
+				logging.FromContext(ctx).Warn("failed to watch dir", "dir", path, "err", err)
+			}
+		}
+		return
+	}
+	if !info.Mode().IsRegular() {
+		return
+	}
+
+	s := stamp{size: info.Size(), modTime: info.ModTime()}
+	if known, ok := w.known[path]; ok && known == s {
+		return
+	}
+	if p, ok := w.pending[path]; ok && p.stamp == s {
+		return
+	}
+	w.pending[path] = &settler{stamp: s, since: time.Now()}
+}
+
+// scanDir watches a dir which was created after the scan, along with its files
+// and sub dirs.
+func (w *Watcher) scanDir(ctx context.Context, dir string, watch func(dir string) error) error {
+
+	if err := watch(dir); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		w.observe(ctx, filepath.Join(dir, entry.Name()), watch)
+	}
+
+	return nil
+}
+
+// settle hands off the pending files which have not changed for the settle time.
+func (w *Watcher) settle(ctx context.Context, now time.Time, ready func(path string)) {
+
+	for path, p := range w.pending {
+		if ctx.Err() != nil {
+			return
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(w.pending, path)
+			continue
+		}
+
+		s := stamp{size: info.Size(), modTime: info.ModTime()}
+		if s != p.stamp {
+			p.stamp = s
+			p.since = now
+			continue
+		}
+		if now.Sub(p.since) < w.options.Settle {
+			continue
+		}
+
+		delete(w.pending, path)
+		w.known[path] = s
+		ready(path)
+	}
+}
+
+// ignored reports whether a file or dir name is skipped, ie: hidden files and
+// dirs, and the temp files of browsers, sync clients and ffmpeg outputs.
+func ignored(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") {
+		return true
+	}
+	for _, suffix := range []string{".part", ".crdownload", ".download", ".tmp", ".swp"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return strings.Contains(name, ".partial.")
+}
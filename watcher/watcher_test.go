@@ -0,0 +1,103 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// runWatcher runs a watcher of the dir until the test ends, and returns the
+// channel of the files which it hands off.
+func runWatcher(t *testing.T, dir string, options Options) <-chan string {
+	t.Helper()
+
+	w, err := New(dir, options)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan string, 16)
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx, func(path string) { ready <- path })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("run failed: %v", err)
+		}
+	})
+
+	return ready
+}
+
+func expectReady(t *testing.T, ready <-chan string, want string) {
+	t.Helper()
+
+	select {
+	case got := <-ready:
+		if got != want {
+			t.Errorf("ready = %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %v", want)
+	}
+}
+
+func expectNone(t *testing.T, ready <-chan string, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case got := <-ready:
+		t.Errorf("unexpected ready file: %v", got)
+	case <-time.After(wait):
+	}
+}
+
+func TestWatcherSettles(t *testing.T) {
+
+	for _, poll := range []bool{false, true} {
+		t.Run(map[bool]string{false: "notify", true: "poll"}[poll], func(t *testing.T) {
+
+			dir := t.TempDir()
+			existing := filepath.Join(dir, "existing.mp3")
+			if err := os.WriteFile(existing, []byte("audio"), 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			ready := runWatcher(t, dir, Options{
+				Recursive:    true,
+				Poll:         poll,
+				PollInterval: 50 * time.Millisecond,
+				Settle:       300 * time.Millisecond,
+			})
+			expectReady(t, ready, existing)
+
+			// A file which is still being written is held back until it settles.
+			if err := os.Mkdir(filepath.Join(dir, "sub"), 0777); err != nil {
+				t.Fatal(err)
+			}
+			added := filepath.Join(dir, "sub", "added.mp3")
+			f, err := os.Create(added)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 4; i++ {
+				f.Write([]byte("more audio"))
+				time.Sleep(100 * time.Millisecond)
+			}
+			expectNone(t, ready, 0)
+			f.Close()
+			expectReady(t, ready, added)
+
+			// Hidden and partial files are ignored, unchanged files are only
+			// handed off once.
+			os.WriteFile(filepath.Join(dir, ".hidden.mp3"), []byte("audio"), 0666)
+			os.WriteFile(filepath.Join(dir, "download.mp3.part"), []byte("audio"), 0666)
+			expectNone(t, ready, time.Second)
+		})
+	}
+}